@@ -3,8 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
 	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/graph"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
 	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+	"github.com/ahmedkhaeld/ecommerce/internal/secureheaders"
+	"github.com/ahmedkhaeld/ecommerce/internal/webhooks"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+	"github.com/graph-gophers/graphql-go"
 	"log"
 	"net/http"
 	"os"
@@ -17,11 +27,14 @@ type config struct {
 	port int
 	env  string
 	db   struct {
-		dsn string
+		driver  string
+		dsn     string
+		migrate bool // apply the pending migrations on start, in development
 	}
 	stripe struct {
-		secret string
-		key    string
+		secret  string
+		key     string
+		webhook string // the signing secret of the webhook endpoint
 	}
 	smtp struct {
 		host     string
@@ -29,8 +42,23 @@ type config struct {
 		username string
 		password string
 	}
+	auth struct {
+		accessTTL  time.Duration // how long an access token lasts
+		refreshTTL time.Duration // how long a sign in lasts without being refreshed
+	}
+	limiter struct {
+		enabled    bool
+		store      string // memory, or db to share the limits between instances
+		rpm        int    // requests a minute a client may make
+		authRPM    int    // sign in and password reset attempts a minute
+		paymentRPM int    // payment attempts a minute
+	}
+	csp       string // enforce, report-only or off; by default it depends on env
+	events    string // the event bus: local, or db so the front end and invoice service hear about what happens here
+	webhooks  bool   // whether this process delivers the events webhooks are registered for
 	secretkey string //  the key to sign in our url
 	frontend  string // the address for the frontend
+	invoice   string // the address of the invoicing microservice
 }
 
 type application struct {
@@ -39,6 +67,13 @@ type application struct {
 	errorLog *log.Logger
 	version  string
 	DB       models.DBModel
+	limiter  ratelimit.Store
+	csrf     csrf.Tokens
+	headers  secureheaders.Policy
+	events   events.Bus
+	webhooks *webhooks.Dispatcher
+	graph    *graphql.Schema
+	invoices invoicepb.InvoiceServiceClient
 }
 
 func (app *application) serve() error {
@@ -62,7 +97,9 @@ func main() {
 	var cfg config
 
 	flag.IntVar(&cfg.port, "port", 4001, "Server port to listen on")
+	flag.StringVar(&cfg.db.driver, "dbdriver", "mysql", "Database driver {mysql | postgres | sqlite3}")
 	flag.StringVar(&cfg.db.dsn, "dsn", "ahmed:secret@tcp(localhost:3306)/widgets?parseTime=true&tls=false", "DSN")
+	flag.BoolVar(&cfg.db.migrate, "migrate", false, "Apply the pending migrations on start, in development only")
 	flag.StringVar(&cfg.env, "env", "development", "Application environment {development | production|maintenance")
 	flag.StringVar(&cfg.smtp.host, "smtphost", "smtp.mailtrap.io", "smtp host")
 	flag.StringVar(&cfg.smtp.username, "smtpuser", "a4348fa5d56133", "smtp user")
@@ -70,28 +107,90 @@ func main() {
 	flag.IntVar(&cfg.smtp.port, "smtpport", 587, "smtp port")
 	flag.StringVar(&cfg.secretkey, "secret", "bRWmrwNUTqNUuzckjxsFlHZjxHkjrzKP", "secret key")
 	flag.StringVar(&cfg.frontend, "frontend", "http://localhost:4000", "url to front end")
+	flag.StringVar(&cfg.invoice, "invoice", "localhost:5000", "address of the invoice micro service")
+	flag.DurationVar(&cfg.auth.accessTTL, "access-ttl", 15*time.Minute, "How long an access token lasts")
+	flag.DurationVar(&cfg.auth.refreshTTL, "refresh-ttl", 7*24*time.Hour, "How long a sign in lasts without being refreshed")
+	flag.StringVar(&cfg.csp, "csp", "", "Content Security Policy {enforce | report-only | off}, report-only in development and enforce otherwise by default")
+	flag.StringVar(&cfg.events, "events", "db", "Event bus {local | db}")
+	flag.BoolVar(&cfg.webhooks, "webhooks", true, "Deliver the events webhooks are registered for")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limit store {memory | db}")
+	flag.IntVar(&cfg.limiter.rpm, "limiter-rpm", 120, "Requests a minute a client may make")
+	flag.IntVar(&cfg.limiter.authRPM, "limiter-auth-rpm", 10, "Sign in and password reset attempts a minute a client may make")
+	flag.IntVar(&cfg.limiter.paymentRPM, "limiter-payment-rpm", 5, "Payment attempts a minute a client may make")
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
 	cfg.stripe.secret = os.Getenv("STRIPE_SECRET")
+	cfg.stripe.webhook = os.Getenv("STRIPE_WEBHOOK_SECRET")
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	conn, err := driver.OpenDB(cfg.db.dsn)
+	conn, err := driver.OpenDB(driver.Dialect(cfg.db.driver), cfg.db.dsn)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 	defer conn.Close()
 
+	if cfg.db.migrate && cfg.env == "development" {
+		m := &migrate.Migrator{DB: conn, Dialect: driver.Dialect(cfg.db.driver), FS: migrations.FS}
+		done, err := m.Up()
+		for _, mig := range done {
+			infoLog.Printf("Applied migration %s_%s", mig.Version, mig.Name)
+		}
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
 	app := &application{
 		config:   cfg,
 		infoLog:  infoLog,
 		errorLog: errorLog,
 		version:  version,
 		DB: models.DBModel{
-			DB: conn,
+			DB:      conn,
+			Dialect: driver.Dialect(cfg.db.driver),
 		},
+		csrf: csrf.Tokens{
+			Secret: []byte(cfg.secretkey),
+		},
+	}
+
+	app.events, err = events.NewBus(cfg.events, conn, errorLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	app.webhooks = webhooks.New(app.DB, []byte(cfg.secretkey), errorLog)
+	if cfg.webhooks {
+		app.events.Subscribe(app.webhooks.Enqueue)
+		go app.webhooks.Run()
+	}
+
+	invoices, invoiceConn, err := invoicepb.Dial(cfg.invoice, []byte(cfg.secretkey))
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer invoiceConn.Close()
+	app.invoices = invoices
+
+	app.graph, err = graph.NewSchema(app.DB)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	app.headers, err = securityHeaders(cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	if cfg.limiter.enabled {
+		app.limiter, err = ratelimit.New(cfg.limiter.store, conn, driver.Dialect(cfg.db.driver))
+		if err != nil {
+			errorLog.Fatal(err)
+		}
 	}
 
 	err = app.serve()
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiError is what every /api/v1 response that is not a success has under "error"
+type apiError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Fields  map[string]string      `json:"fields,omitempty"`  // what is wrong with each field, when validation failed
+	Details map[string]interface{} `json:"details,omitempty"` // anything else there is to know, like retry_after
+}
+
+// errorCodes are the codes of the errors of each status; others are the status text in snake case
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusTooManyRequests:       "rate_limited",
+}
+
+// errorCode returns the code of an error with status
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// envelope reshapes the JSON body a handler wrote with status into a /api/v1 response. Handlers say what went wrong
+// the way they always have, with {"error": true, "message": ...}; its errors become the fields, a flag like
+// two_factor_required becomes the code, and anything else goes in the details
+func envelope(status int, body []byte) (interface{}, error) {
+	var data interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		err := json.Unmarshal(body, &data)
+		if err != nil {
+			return nil, fmt.Errorf("reading the response to put it in an envelope: %w", err)
+		}
+	}
+	obj, _ := data.(map[string]interface{})
+
+	if status < http.StatusBadRequest {
+		// the status tells a success from a failure now, rather than these flags
+		if failed, ok := obj["error"].(bool); ok && !failed {
+			delete(obj, "error")
+		}
+		if okay, ok := obj["ok"].(bool); ok && okay {
+			delete(obj, "ok")
+		}
+
+		var resp struct {
+			Data interface{} `json:"data"`
+		}
+		resp.Data = data
+		return resp, nil
+	}
+
+	e := apiError{
+		Code:    errorCode(status),
+		Message: http.StatusText(status),
+	}
+	for k, v := range obj {
+		switch k {
+		case "error", "ok":
+		case "message":
+			if message, ok := v.(string); ok && message != "" {
+				e.Message = message
+			}
+		case "errors":
+			fields, _ := v.(map[string]interface{})
+			for field, problem := range fields {
+				if e.Fields == nil {
+					e.Fields = make(map[string]string)
+				}
+				e.Fields[field] = fmt.Sprint(problem)
+			}
+		default:
+			if flag, ok := v.(bool); ok && flag {
+				e.Code = k
+				continue
+			}
+			if e.Details == nil {
+				e.Details = make(map[string]interface{})
+			}
+			e.Details[k] = v
+		}
+	}
+
+	var resp struct {
+		Error apiError `json:"error"`
+	}
+	resp.Error = e
+	return resp, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/validator"
+)
+
+// SalesAnalytics returns the revenue, order and subscription reports for the admin dashboard
+func (app *application) SalesAnalytics(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From     string `json:"from"`
+		To       string `json:"to"`
+		Currency string `json:"currency"`
+		Interval string `json:"interval"`
+	}
+
+	err := app.readParams(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	// default to the last 30 days, by day; the end date is inclusive
+	filter := models.AnalyticsFilter{
+		To:       time.Now().Truncate(24*time.Hour).AddDate(0, 0, 1),
+		Currency: payload.Currency,
+		Interval: payload.Interval,
+	}
+	if filter.Interval == "" {
+		filter.Interval = "day"
+	}
+
+	v := validator.New()
+	if payload.To != "" {
+		to, err := time.Parse("2006-01-02", payload.To)
+		v.Check(err == nil, "to", "Must be a date like 2022-01-31")
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	filter.From = filter.To.AddDate(0, 0, -30)
+	if payload.From != "" {
+		from, err := time.Parse("2006-01-02", payload.From)
+		v.Check(err == nil, "from", "Must be a date like 2022-01-01")
+		filter.From = from
+	}
+	v.Check(filter.From.Before(filter.To), "from", "Must be before the end date")
+	v.Check(filter.Interval == "day" || filter.Interval == "week" || filter.Interval == "month",
+		"interval", "Must be day, week or month")
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	revenue, err := app.DB.GetRevenueByPeriod(filter)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	sales, err := app.DB.GetSalesSummary(filter)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	topWidgets, err := app.DB.GetTopWidgets(filter, 5)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	subscriptions, err := app.DB.GetSubscriptionSummary(filter)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		From          string                     `json:"from"`
+		To            string                     `json:"to"`
+		Currency      string                     `json:"currency"`
+		Interval      string                     `json:"interval"`
+		Revenue       []*models.RevenuePoint     `json:"revenue"`
+		Sales         models.SalesSummary        `json:"sales"`
+		TopWidgets    []*models.WidgetSales      `json:"top_widgets"`
+		Subscriptions models.SubscriptionSummary `json:"subscriptions"`
+	}
+
+	resp.From = filter.From.Format("2006-01-02")
+	resp.To = filter.To.AddDate(0, 0, -1).Format("2006-01-02")
+	resp.Currency = filter.Currency
+	resp.Interval = filter.Interval
+	resp.Revenue = revenue
+	resp.Sales = sales
+	resp.TopWidgets = topWidgets
+	resp.Subscriptions = subscriptions
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ahmedkhaeld/ecommerce/internal/cards"
 	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
 	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
 	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
 	"github.com/ahmedkhaeld/ecommerce/internal/validator"
 	"github.com/go-chi/chi/v5"
 	"github.com/stripe/stripe-go/v72"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"net/http"
 	"strconv"
 	"strings"
@@ -46,13 +50,13 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 	var payload stripePayload
 	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
 		return
 	}
 	// typecast amount to int
 	amount, err := strconv.Atoi(payload.Amount)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.badRequest(w, r, errors.New("amount must be a whole number"))
 		return
 	}
 	// now currency and amount are ready
@@ -73,13 +77,7 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 
 	// if payment intent success, then send back actual payment intent, else send back json response with false
 	if okay {
-		out, err := json.MarshalIndent(pi, "", "  ")
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		app.writeJSON(w, http.StatusOK, pi)
 
 	} else {
 		j := jsonResponse{
@@ -88,12 +86,7 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 			Content: "",
 		}
 
-		out, err := json.MarshalIndent(j, "", "  ")
-		if err != nil {
-			app.errorLog.Println(err)
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		app.writeJSON(w, app.chargeFailedStatus(r), j)
 	}
 
 }
@@ -103,31 +96,16 @@ func (app *application) GetWidgetByID(w http.ResponseWriter, r *http.Request) {
 	widgetID, _ := strconv.Atoi(id)
 
 	widget, err := app.DB.GetWidget(widgetID)
-	if err != nil {
-		app.errorLog.Println(err)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No widget %d", widgetID))
 		return
 	}
-
-	out, err := json.MarshalIndent(widget, "", "  ")
 	if err != nil {
-		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
-}
 
-// Invoice describes the JSON payload sent to the microservice
-type Invoice struct {
-	ID        int       `json:"id"`
-	WidgetID  int       `json:"widget_id"`
-	Amount    int       `json:"amount"`
-	Product   string    `json:"product"`
-	Quantity  int       `json:"quantity"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
+	app.writeJSON(w, http.StatusOK, widget)
 }
 
 // CreateCustomerAndSubscriptionPlan is the handler for subscribing to the bronze plan
@@ -135,7 +113,7 @@ func (app *application) CreateCustomerAndSubscriptionPlan(w http.ResponseWriter,
 	var data stripePayload
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
 		return
 	}
 	// validate data
@@ -177,7 +155,7 @@ func (app *application) CreateCustomerAndSubscriptionPlan(w http.ResponseWriter,
 		productID, _ := strconv.Atoi(data.ProductID)
 		customerID, err := app.SaveCustomer(data.FirstName, data.LastName, data.Email)
 		if err != nil {
-			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
 			return
 		}
 		// create a new txn
@@ -196,7 +174,7 @@ func (app *application) CreateCustomerAndSubscriptionPlan(w http.ResponseWriter,
 
 		txnID, err := app.SaveTransaction(txn)
 		if err != nil {
-			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
 			return
 		}
 
@@ -214,19 +192,31 @@ func (app *application) CreateCustomerAndSubscriptionPlan(w http.ResponseWriter,
 
 		orderID, err := app.SaveOrder(order)
 		if err != nil {
-			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
 			return
 		}
 
-		inv := Invoice{
-			ID:        orderID,
-			Amount:    2000,
-			Product:   "Bronze Plan monthly subscription",
-			Quantity:  order.Quantity,
-			FirstName: data.FirstName,
-			LastName:  data.LastName,
-			Email:     data.Email,
-			CreatedAt: time.Now(),
+		app.publish(events.OrderCreated, events.Order{
+			OrderID:  orderID,
+			Product:  "Bronze Plan",
+			Quantity: order.Quantity,
+			Amount:   order.Amount,
+			Email:    data.Email,
+		})
+
+		inv := &invoicepb.Order{
+			Id:       int64(orderID),
+			WidgetId: int64(productID),
+			Amount:   2000,
+			Product:  "Bronze Plan monthly subscription",
+			Quantity: int64(order.Quantity),
+			Customer: &invoicepb.Customer{
+				FirstName: data.FirstName,
+				LastName:  data.LastName,
+				Email:     data.Email,
+			},
+			CreatedAt: timestamppb.Now(),
+			StatusUrl: app.orderStatusURL(orderID),
 		}
 
 		err = app.callInvoiceMicro(inv)
@@ -240,38 +230,20 @@ func (app *application) CreateCustomerAndSubscriptionPlan(w http.ResponseWriter,
 		Message: txnMsg,
 	}
 
-	out, err := json.MarshalIndent(resp, "", "  ")
-	if err != nil {
-		app.errorLog.Println(err)
-		return
+	status := http.StatusOK
+	if !okay {
+		status = app.chargeFailedStatus(r)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+	app.writeJSON(w, status, resp)
 }
 
-// callInvoiceMicro calls the invoicing microservice
-func (app *application) callInvoiceMicro(inv Invoice) error {
-	url := "http://localhost:5000/invoice/create-and-send"
-	out, err := json.MarshalIndent(inv, "", "\t")
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(out))
-	if err != nil {
-		return err
+// chargeFailedStatus is the status of a response saying a card could not be charged. The routes from before /api/v1
+// said so with a 200 and "ok": false, and keep doing so for the clients that still use them
+func (app *application) chargeFailedStatus(r *http.Request) int {
+	if app.deprecated(r) {
+		return http.StatusOK
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+	return http.StatusBadRequest
 }
 
 // SaveCustomer saves a customer and returns id
@@ -313,6 +285,7 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 	var userInput struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		twoFactorInput
 	}
 
 	err := app.readJSON(w, r, &userInput)
@@ -320,9 +293,24 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 		app.badRequest(w, r, err)
 		return
 	}
+	// too many failed attempts for the email or from this address make the client wait, whether or not the email
+	// has an account
+	status, err := app.DB.CheckLogin(userInput.Email, ratelimit.ClientIP(r))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if status.RetryAfter > 0 {
+		app.tooManyAttempts(w, status.RetryAfter)
+		return
+	}
+
 	// get the user from the database by email; send error if invalid email(invalid credentials)
 	user, err := app.DB.GetUserByEmail(userInput.Email)
 	if err != nil {
+		// compare against a dummy hash, so an unknown email takes as long to turn away as a wrong password
+		app.passwordMatches(dummyPasswordHash, userInput.Password)
+		app.failedLogin(r, userInput.Email)
 		app.invalidCredentials(w)
 		return
 	}
@@ -335,19 +323,41 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 	}
 
 	if !validPassword {
+		app.failedLogin(r, userInput.Email)
 		app.invalidCredentials(w)
 		return
 	}
 
-	// generate the token to be sent back as part of the response
-	token, err := models.GenerateToken(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	// a user linked to the identity provider signs in through it, so that it decides who still may
+	if user.SingleSignOn {
+		app.forbidden(w, "Sign in with single sign on")
+		return
+	}
+
+	deviceToken, ok := app.checkTwoFactor(w, r, user, userInput.twoFactorInput)
+	if !ok {
+		return
+	}
+
+	err = app.DB.ClearFailedLogins(user.Email)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	setupRequired, err := app.DB.TwoFactorSetupRequired(user.ID)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
 
-	// save token to the database
-	err = app.DB.InsertToken(token, user)
+	// generate the tokens to be sent back as part of the response, the first of a new sign in
+	family, err := models.NewTokenFamily()
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.issueTokens(r, user, family, models.ScopeAuthentication)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -355,14 +365,20 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 	// send response
 
 	var payload struct {
-		Error   bool          `json:"error"`
-		Message string        `json:"message"`
-		Token   *models.Token `json:"authentication_token"`
+		Error                  bool                 `json:"error"`
+		Message                string               `json:"message"`
+		Token                  *models.Token        `json:"authentication_token"`
+		RefreshToken           *models.RefreshToken `json:"refresh_token"`
+		DeviceToken            string               `json:"device_token,omitempty"`
+		TwoFactorSetupRequired bool                 `json:"two_factor_setup_required"`
 	}
 
 	payload.Error = false
 	payload.Message = fmt.Sprintf("token for %s created", userInput.Email)
 	payload.Token = token
+	payload.RefreshToken = refreshToken
+	payload.DeviceToken = deviceToken
+	payload.TwoFactorSetupRequired = setupRequired
 
 	_ = app.writeJSON(w, http.StatusOK, payload)
 
@@ -371,7 +387,7 @@ func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request)
 // CheckAuthentication
 func (app *application) CheckAuthentication(w http.ResponseWriter, r *http.Request) {
 	// validate the token, and get associated user
-	user, err := app.authenticateToken(r)
+	user, _, err := app.authenticateToken(r)
 	if err != nil {
 		app.invalidCredentials(w)
 		return
@@ -388,30 +404,30 @@ func (app *application) CheckAuthentication(w http.ResponseWriter, r *http.Reque
 }
 
 // authenticateToken get the header Authorization
-func (app *application) authenticateToken(r *http.Request) (*models.User, error) {
+func (app *application) authenticateToken(r *http.Request) (*models.User, *models.Token, error) {
 	// extract the token from authorization header
 	authorizationHeader := r.Header.Get("Authorization")
 	if authorizationHeader == "" {
-		return nil, errors.New("no authorization header received")
+		return nil, nil, errors.New("no authorization header received")
 	}
 
 	headerParts := strings.Split(authorizationHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return nil, errors.New("no authorization received")
+		return nil, nil, errors.New("no authorization received")
 	}
 	// get the token
 	token := headerParts[1]
-	if len(token) != 26 {
-		return nil, errors.New("authorization wrong size")
+	if token == "" || len(token) > maxTokenLength {
+		return nil, nil, errors.New("authorization wrong size")
 	}
 
 	// get the user from the tokens table
-	user, err := app.DB.GetUserForToken(token)
+	user, t, err := app.DB.GetToken(token)
 	if err != nil {
-		return nil, errors.New("no matching user found")
+		return nil, nil, errors.New("no matching user found")
 	}
 
-	return user, nil
+	return user, t, nil
 }
 
 func (app *application) VirtualTerminalSucceeded(w http.ResponseWriter, r *http.Request) {
@@ -486,16 +502,20 @@ func (app *application) SendPasswordResetEmail(w http.ResponseWriter, r *http.Re
 		app.badRequest(w, r, err)
 		return
 	}
-	// verify the email exist in the database
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "If that email has an account, we have sent it a link to reset the password"
+
+	// the response is the same whether or not the email has an account, and the email goes out in the background so
+	// it takes no longer either, so it cannot be used to find out who has one
 	_, err = app.DB.GetUserByEmail(payload.Email)
 	if err != nil {
-		var resp struct {
-			Error   bool   `json:"error"`
-			Message string `json:"message"`
-		}
-		resp.Error = true
-		resp.Message = "No matching email found in the database"
-		app.writeJSON(w, http.StatusAccepted, resp)
+		app.writeJSON(w, http.StatusCreated, resp)
 		return
 	}
 
@@ -514,18 +534,12 @@ func (app *application) SendPasswordResetEmail(w http.ResponseWriter, r *http.Re
 	data.Link = signedLink
 
 	// send mail
-	err = app.SendMail("info@widget.com", payload.Email, "password reset request", "password-reset", data)
-	if err != nil {
-		app.errorLog.Println(err)
-		app.badRequest(w, r, err)
-	}
-
-	var resp struct {
-		Error   bool   `json:"error"`
-		Message string `json:"message"`
-	}
-
-	resp.Error = false
+	go func() {
+		err := app.SendMail("info@widget.com", payload.Email, "password reset request", "password-reset", data)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}()
 
 	app.writeJSON(w, http.StatusCreated, resp)
 }
@@ -578,17 +592,50 @@ func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusCreated, resp)
 }
+
+// orderListPayload is the request for a page of sales or subscriptions, with optional filters and sorting
+type orderListPayload struct {
+	PageSize    int    `json:"page_size"`
+	CurrentPage int    `json:"page"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	models.OrderFilter
+}
+
+// filter returns the order filter for the payload, with its dates parsed; the to date is inclusive
+func (p orderListPayload) filter() (models.OrderFilter, error) {
+	f := p.OrderFilter
+	if p.From != "" {
+		from, err := time.Parse("2006-01-02", p.From)
+		if err != nil {
+			return f, errors.New("from must be a date like 2022-01-01")
+		}
+		f.From = from
+	}
+	if p.To != "" {
+		to, err := time.Parse("2006-01-02", p.To)
+		if err != nil {
+			return f, errors.New("to must be a date like 2022-01-31")
+		}
+		f.To = to.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
 func (app *application) AllSales(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		PageSize    int `json:"page_size"`
-		CurrentPage int `json:"current_page"`
+	var payload orderListPayload
+	err := app.readParams(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
 	}
-	err := app.readJSON(w, r, &payload)
+	filter, err := payload.filter()
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
-	allSales, lastPage, totalRecords, err := app.DB.GetAllOrdersPaginated(payload.PageSize, payload.CurrentPage)
+	payload.CurrentPage, payload.PageSize = pageParams(payload.CurrentPage, payload.PageSize)
+	allSales, lastPage, totalRecords, err := app.DB.GetAllOrdersPaginated(payload.PageSize, payload.CurrentPage, filter)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -610,18 +657,23 @@ func (app *application) AllSales(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) AllSubscriptions(w http.ResponseWriter, r *http.Request) {
 
-	var payload struct {
-		PageSize    int `json:"page_size"`
-		CurrentPage int `json:"page"`
+	var payload orderListPayload
+
+	err := app.readParams(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
 	}
 
-	err := app.readJSON(w, r, &payload)
+	filter, err := payload.filter()
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
 
-	allSales, lastPage, totalRecords, err := app.DB.GetAllSubscriptionsPaginated(payload.PageSize, payload.CurrentPage)
+	payload.CurrentPage, payload.PageSize = pageParams(payload.CurrentPage, payload.PageSize)
+
+	allSales, lastPage, totalRecords, err := app.DB.GetAllSubscriptionsPaginated(payload.PageSize, payload.CurrentPage, filter)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -648,6 +700,10 @@ func (app *application) Sale(w http.ResponseWriter, r *http.Request) {
 	orderID, _ := strconv.Atoi(id)
 
 	order, err := app.DB.GetOrderByID(orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No order %d", orderID))
+		return
+	}
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -669,6 +725,7 @@ func (app *application) RefundCharge(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, r, err)
 		return
 	}
+	ChargeToRefund.ID = idParam(r, ChargeToRefund.ID)
 
 	card := cards.Card{
 		Secret:   app.config.stripe.secret,
@@ -687,6 +744,20 @@ func (app *application) RefundCharge(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, r, errors.New("the charge was refunded but the database could not be updated"))
 		return
 	}
+
+	refund := events.Order{
+		OrderID: ChargeToRefund.ID,
+		Amount:  ChargeToRefund.Amount,
+	}
+	order, err := app.DB.GetOrderByID(ChargeToRefund.ID)
+	if err == nil {
+		refund.Product = order.Widget.Name
+		refund.Quantity = order.Quantity
+		refund.FirstName = order.Customer.FirstName
+		refund.Email = order.Customer.Email
+	}
+	app.publish(events.RefundIssued, refund)
+
 	var resp struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -712,6 +783,7 @@ func (app *application) CancelSubscription(w http.ResponseWriter, r *http.Reques
 		app.badRequest(w, r, err)
 		return
 	}
+	subToCancel.ID = idParam(r, subToCancel.ID)
 
 	card := cards.Card{
 		Secret:   app.config.stripe.secret,
@@ -730,6 +802,11 @@ func (app *application) CancelSubscription(w http.ResponseWriter, r *http.Reques
 		app.badRequest(w, r, errors.New("the subscription was cancelled but the database could not be updated"))
 		return
 	}
+
+	app.publish(events.SubscriptionCancelled, events.Order{
+		OrderID: subToCancel.ID,
+	})
+
 	var resp struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -755,12 +832,32 @@ func (app *application) OneUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	userID, _ := strconv.Atoi(id)
 	user, err := app.DB.GetOneUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No user %d", userID))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	lockedUntil, err := app.DB.AccountLockedUntil(user.Email)
 	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, user)
+	var resp struct {
+		models.User
+		LockedUntil *time.Time `json:"locked_until,omitempty"`
+	}
+
+	resp.User = user
+	if !lockedUntil.IsZero() {
+		resp.LockedUntil = &lockedUntil
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
 }
 
 func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
@@ -774,6 +871,7 @@ func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if userID > 0 {
+		user.ID = userID
 		err = app.DB.EditUser(user)
 		if err != nil {
 			app.badRequest(w, r, err)
@@ -822,6 +920,8 @@ func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.publish(events.UserDeleted, events.User{UserID: userID})
+
 	var resp struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
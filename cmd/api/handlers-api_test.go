@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+)
+
+// newTestApp returns an api with a database of its own
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+
+	conn, err := driver.OpenDB(driver.SQLite, t.TempDir()+"/widgets.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = (&migrate.Migrator{DB: conn, Dialect: driver.SQLite, FS: migrations.FS}).Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discard := log.New(io.Discard, "", 0)
+	return &application{
+		infoLog:  discard,
+		errorLog: discard,
+		DB:       models.DBModel{DB: conn, Dialect: driver.SQLite},
+	}
+}
+
+func TestListsEchoThePageUsed(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		query    string
+		page     int
+		pageSize int
+	}{
+		{"sales by default", app.AllSales, "", 1, 10},
+		{"sales asked for", app.AllSales, "?page=3&page_size=5", 3, 5},
+		{"sales out of range", app.AllSales, "?page=-2&page_size=0", 1, 10},
+		{"subscriptions by default", app.AllSubscriptions, "", 1, 10},
+		{"subscriptions asked for", app.AllSubscriptions, "?page=2&page_size=25", 2, 25},
+		{"customers by default", app.AllCustomers, "", 1, 10},
+		{"customers asked for", app.AllCustomers, "?page=4&page_size=2", 4, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/list"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+
+			var resp struct {
+				CurrentPage int `json:"current_page"`
+				PageSize    int `json:"page_size"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.CurrentPage != tt.page || resp.PageSize != tt.pageSize {
+				t.Errorf("got page %d of %d, want page %d of %d", resp.CurrentPage, resp.PageSize, tt.page, tt.pageSize)
+			}
+		})
+	}
+}
+
+func TestChargeFailedStatus(t *testing.T) {
+	app := &application{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(app.chargeFailedStatus(r))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/payment-intents", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d on /api/v1, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	app.Deprecated(handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/payment-intent", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d on the deprecated route, want %d as it always had", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// AllCustomers returns a page of customers, optionally searching their names and emails
+func (app *application) AllCustomers(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PageSize    int    `json:"page_size"`
+		CurrentPage int    `json:"page"`
+		Search      string `json:"search"`
+	}
+
+	err := app.readParams(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	payload.CurrentPage, payload.PageSize = pageParams(payload.CurrentPage, payload.PageSize)
+
+	customers, lastPage, totalRecords, err := app.DB.GetCustomersPaginated(payload.PageSize, payload.CurrentPage, payload.Search)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int                       `json:"current_page"`
+		PageSize     int                       `json:"page_size"`
+		LastPage     int                       `json:"last_page"`
+		TotalRecords int                       `json:"total_records"`
+		Customers    []*models.CustomerSummary `json:"customers"`
+	}
+
+	resp.CurrentPage = payload.CurrentPage
+	resp.PageSize = payload.PageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Customers = customers
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// OneCustomer returns a customer's profile, with their orders split into purchases, subscriptions and refunds, the
+// emails we sent them, and any other customer rows with the same email
+func (app *application) OneCustomer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	customerID, _ := strconv.Atoi(id)
+
+	customer, err := app.DB.GetCustomer(customerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No customer %d", customerID))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	orders, err := app.DB.GetOrdersForCustomer(customerID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	emails, err := app.DB.GetEmailsSentTo(customer.Email)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	duplicates, err := app.DB.GetDuplicateCustomers(customer.Customer)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Customer      models.CustomerSummary `json:"customer"`
+		Orders        []*models.Order        `json:"orders"`
+		Subscriptions []*models.Order        `json:"subscriptions"`
+		Refunds       []*models.Order        `json:"refunds"`
+		Emails        []*models.EmailLog     `json:"emails"`
+		Duplicates    []*models.Customer     `json:"duplicates"`
+	}
+
+	resp.Customer = customer
+	for _, o := range orders {
+		if o.Widget.IsRecurring {
+			resp.Subscriptions = append(resp.Subscriptions, o)
+		} else {
+			resp.Orders = append(resp.Orders, o)
+		}
+		if o.StatusID == 2 {
+			resp.Refunds = append(resp.Refunds, o)
+		}
+	}
+	resp.Emails = emails
+	resp.Duplicates = duplicates
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EditCustomer updates a customer's contact details
+func (app *application) EditCustomer(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	customerID, _ := strconv.Atoi(id)
+
+	var customer models.Customer
+	err := app.readJSON(w, r, &customer)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	customer.ID = customerID
+
+	v := validator.New()
+	v.Check(customer.FirstName != "", "first_name", "Must not be empty")
+	v.Check(customer.LastName != "", "last_name", "Must not be empty")
+	v.Check(customer.Email != "", "email", "Must not be empty")
+
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	err = app.DB.EditCustomer(customer)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Customer updated"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// MergeCustomers moves the orders of duplicate customer rows onto one customer, and deletes the duplicates
+func (app *application) MergeCustomers(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TargetID int   `json:"target_id"`
+		IDs      []int `json:"ids"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if payload.TargetID < 1 || len(payload.IDs) == 0 {
+		app.badRequest(w, r, errors.New("a customer to merge into and the customers to merge are required"))
+		return
+	}
+
+	err = app.DB.MergeCustomers(payload.TargetID, payload.IDs)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Customers merged"
+	app.writeJSON(w, http.StatusOK, resp)
+}
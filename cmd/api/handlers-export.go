@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/spreadsheet"
+	"github.com/go-chi/chi/v5"
+)
+
+// exportWriteTimeout is how long an export may take to stream; the server's write timeout is far too short for it
+const exportWriteTimeout = 10 * time.Minute
+
+// exportScopes are the scopes an API key needs, besides orders:read, to export each dataset
+var exportScopes = map[string]string{
+	"orders":        models.ScopeOrdersRead,
+	"subscriptions": models.ScopeOrdersRead,
+	"transactions":  models.ScopeOrdersRead,
+	"customers":     models.ScopeCustomersRead,
+	"users":         models.ScopeAuthentication,
+}
+
+// ExportDatasets lists the datasets that can be exported, with their columns
+func (app *application) ExportDatasets(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, models.ExportDatasets())
+}
+
+// ExportData streams a dataset out as a csv or xlsx file, one row at a time. It accepts the query parameters format
+// (csv or xlsx), columns (comma separated, defaults to all) and from and to (inclusive dates like 2022-01-31)
+func (app *application) ExportData(w http.ResponseWriter, r *http.Request) {
+	dataset := chi.URLParam(r, "dataset")
+	qs := r.URL.Query()
+
+	format := qs.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := spreadsheet.ContentType(format)
+	if !ok {
+		app.badRequest(w, r, fmt.Errorf("unknown format %q", format))
+		return
+	}
+
+	var columns []string
+	if qs.Get("columns") != "" {
+		columns = strings.Split(qs.Get("columns"), ",")
+	}
+
+	header, err := models.ExportHeader(dataset, columns)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if scope := exportScopes[dataset]; !app.authenticatedToken(r).HasScope(scope) {
+		app.forbidden(w, fmt.Sprintf("This token does not have the %s scope", scope))
+		return
+	}
+
+	var from, to time.Time
+	if qs.Get("from") != "" {
+		from, err = time.Parse("2006-01-02", qs.Get("from"))
+		if err != nil {
+			app.badRequest(w, r, fmt.Errorf("from must be a date like 2022-01-01"))
+			return
+		}
+	}
+	if qs.Get("to") != "" {
+		to, err = time.Parse("2006-01-02", qs.Get("to"))
+		if err != nil {
+			app.badRequest(w, r, fmt.Errorf("to must be a date like 2022-01-31"))
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", dataset, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	sheet, err := spreadsheet.New(format, w)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = sheet.Write(header)
+	if err == nil {
+		err = app.DB.ExportRows(r.Context(), dataset, columns, from, to, sheet.Write)
+	}
+	if err == nil {
+		err = sheet.Close()
+	}
+	// the status has been sent with the first row, so all we can do now is stop and log
+	if err != nil {
+		app.errorLog.Printf("export of %s failed: %s", dataset, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+)
+
+// FulfilmentStatuses returns the fulfilment statuses an order can be moved to
+func (app *application) FulfilmentStatuses(w http.ResponseWriter, r *http.Request) {
+	statuses, err := app.DB.GetAllFulfilmentStatuses()
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, statuses)
+}
+
+// UpdateFulfilment moves an order to another fulfilment status. Its customer sees the change on their order status
+// page as it happens
+func (app *application) UpdateFulfilment(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID                 int `json:"id"`
+		FulfilmentStatusID int `json:"fulfilment_status_id"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	payload.ID = idParam(r, payload.ID)
+
+	if payload.FulfilmentStatusID < models.FulfilmentPending || payload.FulfilmentStatusID > models.FulfilmentDelivered {
+		app.badRequest(w, r, errors.New("unknown fulfilment status"))
+		return
+	}
+
+	_, err = app.DB.GetOrderStatus(payload.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No order %d", payload.ID))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.UpdateFulfilmentStatus(payload.ID, payload.FulfilmentStatusID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	status, err := app.DB.GetOrderStatus(payload.ID)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+	app.publish(events.FulfilmentUpdated, events.Fulfilment{
+		OrderID:  payload.ID,
+		StatusID: payload.FulfilmentStatusID,
+		Status:   status.Fulfilment,
+	})
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Fulfilment status updated"
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/graph"
+)
+
+// GraphQL answers a query of the admin dashboards. The response is the GraphQL one as it is, errors and all, so it
+// has a content type of its own that Envelope leaves alone
+func (app *application) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	ctx := graph.NewContext(r.Context(), app.DB, app.authenticatedToken(r))
+	result := app.graph.Exec(ctx, payload.Query, payload.OperationName, payload.Variables)
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/graphql-response+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
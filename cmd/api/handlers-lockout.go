@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+	"github.com/go-chi/chi/v5"
+)
+
+// dummyPasswordHash is a bcrypt hash, at the cost passwords are hashed with, that no password matches
+const dummyPasswordHash = "$2a$12$oLOBWYGYk5/LID3rx8U9ROIX/GcQLXXtWm9cgc1ZUPFMy/qxd4DIm"
+
+// tooManyAttempts tells the client to wait before trying to sign in again
+func (app *application) tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	app.tooManyRequests(w, "Too many failed sign in attempts", retryAfter)
+}
+
+// failedLogin records a failed sign in, and emails the owner of the account when it locks them out. The email goes
+// out in the background, so a lockout takes no longer to answer for an account that exists than for one that does not
+func (app *application) failedLogin(r *http.Request, email string) {
+	locked, err := app.DB.RecordFailedLogin(email, ratelimit.ClientIP(r))
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	app.infoLog.Printf("sign in locked for %s after failed attempts from %s", email, ratelimit.ClientIP(r))
+
+	user, err := app.DB.GetUserByEmail(email)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Name      string
+		Attempts  int
+		Minutes   int
+		ResetLink string
+	}
+	data.Name = user.FirstName
+	data.Attempts = models.AccountLockoutThreshold
+	data.Minutes = int(models.LockoutDuration.Minutes())
+	data.ResetLink = fmt.Sprintf("%s/forgot-password", app.config.frontend)
+
+	go func() {
+		err := app.SendMail("info@widget.com", user.Email, "Your account has been locked", "account-locked", data)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}()
+}
+
+// UnlockUser lifts the lockout of a user who failed to sign in too many times
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := strconv.Atoi(id)
+
+	user, err := app.DB.GetOneUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No user %d", userID))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.ClearFailedLogins(user.Email)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "User unlocked"
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+	"github.com/ahmedkhaeld/ecommerce/internal/validator"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// privacyActions are what a customer can ask us to do with their data
+var privacyActions = map[string]bool{
+	"export": true,
+	"erase":  true,
+}
+
+// privacyLinkTTL is how long the link emailed for a personal data request can be used
+const privacyLinkTTL = time.Hour
+
+// PrivacyRequest emails a customer a signed link to confirm that they want their personal data exported or erased.
+// The response is the same, and as quick, whether or not we hold anything about the email, so it cannot be used to
+// find customers
+func (app *application) PrivacyRequest(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email  string `json:"email"`
+		Action string `json:"action"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(strings.Contains(payload.Email, "@"), "email", "Must be a valid email address")
+	v.Check(privacyActions[payload.Action], "action", "Must be export or erase")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	data, err := app.DB.GetPersonalData(payload.Email)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if data.HasData() {
+		link := fmt.Sprintf("%s/privacy/confirm?email=%s&action=%s",
+			app.config.frontend, url.QueryEscape(data.Email), url.QueryEscape(payload.Action))
+		sign := urlsigner.Signer{
+			Secret: []byte(app.config.secretkey),
+		}
+
+		var mailData struct {
+			Link   string
+			Action string
+		}
+		mailData.Link = sign.GenerateTokenFromString(link)
+		mailData.Action = payload.Action
+
+		go func() {
+			err := app.SendMail("info@widget.com", data.Email, "Your personal data request", "privacy-request", mailData)
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+		}()
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "If we hold any data for that email, we have sent it a link to confirm the request"
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// PrivacyExport sends a customer everything we hold about them. The front end passes on the signed link we emailed
+// them, which says whose data it is and that they asked for an export
+func (app *application) PrivacyExport(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Link   string `json:"link"`
+		Format string `json:"format"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	email, ok := app.verifyPrivacyLink(payload.Link, "export")
+	if !ok {
+		app.forbidden(w, "This link is not valid, or has expired")
+		return
+	}
+
+	app.writePersonalData(w, r, email, payload.Format)
+}
+
+// PrivacyErase erases the data of a customer who has confirmed it through the signed link we emailed them, passed on
+// by the front end
+func (app *application) PrivacyErase(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Link string `json:"link"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	email, ok := app.verifyPrivacyLink(payload.Link, "erase")
+	if !ok {
+		app.forbidden(w, "This link is not valid, or has expired")
+		return
+	}
+
+	app.erasePersonalData(w, r, email)
+}
+
+// AdminPrivacyExport sends an admin everything we hold about an email
+func (app *application) AdminPrivacyExport(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email  string `json:"email"`
+		Format string `json:"format"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.writePersonalData(w, r, payload.Email, payload.Format)
+}
+
+// AdminPrivacyErase erases everything we hold about an email, on the request of the customer
+func (app *application) AdminPrivacyErase(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.erasePersonalData(w, r, payload.Email)
+}
+
+// verifyPrivacyLink returns the email of the signed link PrivacyRequest emailed, and whether the link is ours, has not
+// expired and was sent for action. Other links we sign, like those for password resets, are not privacy links
+func (app *application) verifyPrivacyLink(link, action string) (string, bool) {
+	if !strings.HasPrefix(link, app.config.frontend+"/privacy/confirm?") {
+		return "", false
+	}
+
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	if !signer.VerifyToken(link) || signer.Expired(link, int(privacyLinkTTL.Minutes())) {
+		return "", false
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+
+	q := u.Query()
+	email := q.Get("email")
+	if email == "" || q.Get("action") != action {
+		return "", false
+	}
+
+	return email, true
+}
+
+// writePersonalData writes out everything held about email, as a single json document or as a zip bundle with one
+// json file for each kind of record; zip is the default
+func (app *application) writePersonalData(w http.ResponseWriter, r *http.Request, email, format string) {
+	data, err := app.DB.GetPersonalData(email)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !data.HasData() {
+		app.badRequest(w, r, errors.New("no personal data is held for that email"))
+		return
+	}
+
+	filename := fmt.Sprintf("personal-data-%s", time.Now().Format("20060102"))
+
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		app.writeJSON(w, http.StatusOK, data)
+		return
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"customers.json", data.Customers},
+		{"orders.json", data.Orders},
+		{"subscription-invoices.json", data.SubscriptionInvoices},
+		{"emails.json", data.Emails},
+		{"user.json", data.User},
+		{"tokens.json", data.Tokens},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: data.ExportedAt,
+		})
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(f.content)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// erasePersonalData erases everything held about email and redacts the invoices of the erased orders
+func (app *application) erasePersonalData(w http.ResponseWriter, r *http.Request, email string) {
+	erasure, err := app.DB.ErasePersonalData(email)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.infoLog.Printf("erased the personal data of %d customers as %s", erasure.Customers, erasure.Pseudonym)
+
+	// the data is gone from the database; an invoice left unredacted is logged so it can be dealt with by hand
+	err = app.redactInvoices(erasure)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Personal data erased"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// redactInvoices has the invoicing microservice write again, with the pseudonymised customer, the invoice of every
+// erased order and of every renewal of an erased subscription. Amounts are unchanged, so they still match the books
+func (app *application) redactInvoices(erasure models.Erasure) error {
+	var invoices []*invoicepb.Order
+
+	for _, o := range erasure.Orders {
+		inv := &invoicepb.Order{
+			Id:       int64(o.ID),
+			WidgetId: int64(o.WidgetID),
+			Amount:   int64(o.Amount),
+			Product:  o.Widget.Name,
+			Quantity: int64(o.Quantity),
+			Customer: &invoicepb.Customer{
+				FirstName: o.Customer.FirstName,
+				LastName:  o.Customer.LastName,
+				Email:     o.Customer.Email,
+			},
+			CreatedAt: timestamppb.New(o.CreatedAt),
+		}
+		invoices = append(invoices, inv)
+
+		renewals, err := app.DB.GetSubscriptionInvoicesForOrder(o.ID)
+		if err != nil {
+			return err
+		}
+		for _, si := range renewals {
+			renewal := proto.Clone(inv).(*invoicepb.Order)
+			renewal.Amount = int64(si.Amount)
+			renewal.Product = fmt.Sprintf("%s monthly subscription", o.Widget.Name)
+			renewal.CreatedAt = timestamppb.New(si.CreatedAt)
+			renewal.Period = &invoicepb.BillingPeriod{
+				Start: timestamppb.New(si.PeriodStart),
+				End:   timestamppb.New(si.PeriodEnd),
+			}
+			invoices = append(invoices, renewal)
+		}
+	}
+
+	if len(invoices) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), invoiceTimeout)
+	defer cancel()
+
+	_, err := app.invoices.RedactInvoices(ctx, &invoicepb.RedactInvoicesRequest{Orders: invoices})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
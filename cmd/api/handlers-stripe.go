@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/cards"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/stripe/stripe-go/v72"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// StripeWebhook receives the events stripe sends for our account. Recurring charges for subscriptions happen on
+// stripe's side, so this is the only place we hear about them
+func (app *application) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 65536))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	card := cards.Card{
+		Secret: app.config.stripe.secret,
+		Key:    app.config.stripe.key,
+	}
+
+	event, err := card.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), app.config.stripe.webhook)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.badRequest(w, r, err)
+		return
+	}
+
+	switch event.Type {
+	case "invoice.payment_succeeded", "invoice.payment_failed":
+		var inv stripe.Invoice
+		err = json.Unmarshal(event.Data.Raw, &inv)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		if event.Type == "invoice.payment_succeeded" {
+			err = app.subscriptionRenewed(event.ID, &inv)
+		} else {
+			err = app.subscriptionPaymentFailed(event.ID, &inv)
+		}
+
+		// anything but a 2xx makes stripe deliver the event again later, so only an event we could not record fails
+		if err != nil {
+			app.errorLog.Println(err)
+			app.badRequest(w, r, err)
+			return
+		}
+	default:
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{OK: true})
+}
+
+// subscriptionRenewed records the monthly charge of a subscription as a transaction linked to the order that started
+// the subscription, and has the invoice microservice email an invoice for the billing period. A renewal is recorded
+// once, however often stripe sends the event eventID
+func (app *application) subscriptionRenewed(eventID string, inv *stripe.Invoice) error {
+	// the first charge is invoiced by CreateCustomerAndSubscriptionPlan
+	if inv.BillingReason != stripe.InvoiceBillingReasonSubscriptionCycle || inv.Subscription == nil {
+		return nil
+	}
+
+	order, err := app.DB.GetOrderBySubscriptionID(inv.Subscription.ID)
+	if err != nil {
+		return fmt.Errorf("no order for subscription %s: %w", inv.Subscription.ID, err)
+	}
+
+	txn := models.Transaction{
+		Amount:              int(inv.AmountPaid),
+		Currency:            string(inv.Currency),
+		LastFour:            order.Transaction.LastFour,
+		ExpiryMonth:         order.Transaction.ExpiryMonth,
+		ExpiryYear:          order.Transaction.ExpiryYear,
+		TransactionStatusID: 2,
+	}
+	if inv.PaymentIntent != nil {
+		txn.PaymentIntent = inv.PaymentIntent.ID
+	}
+	if inv.Charge != nil {
+		txn.BankReturnCode = inv.Charge.ID
+	}
+
+	periodStart, periodEnd := invoicePeriod(inv)
+
+	recorded, err := app.DB.RecordSubscriptionRenewal(eventID, txn, models.SubscriptionInvoice{
+		OrderID:         order.ID,
+		StripeInvoiceID: inv.ID,
+		PeriodStart:     periodStart,
+		PeriodEnd:       periodEnd,
+	})
+	if err != nil || !recorded {
+		return err
+	}
+
+	invoice := &invoicepb.Order{
+		Id:       int64(order.ID),
+		WidgetId: int64(order.WidgetID),
+		Amount:   int64(txn.Amount),
+		Product:  fmt.Sprintf("%s monthly subscription", order.Widget.Name),
+		Quantity: int64(order.Quantity),
+		Customer: &invoicepb.Customer{
+			FirstName: order.Customer.FirstName,
+			LastName:  order.Customer.LastName,
+			Email:     order.Customer.Email,
+		},
+		CreatedAt: timestamppb.Now(),
+		Period: &invoicepb.BillingPeriod{
+			Start: timestamppb.New(periodStart),
+			End:   timestamppb.New(periodEnd),
+		},
+		StatusUrl: app.orderStatusURL(order.ID),
+	}
+
+	// the charge is recorded; a failure to send the invoice must not make stripe send the event again
+	err = app.callInvoiceMicro(invoice)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	return nil
+}
+
+// subscriptionPaymentFailed emails the customer when stripe could not charge the card for a subscription, telling
+// them when stripe will try again and where to update their card. The event eventID is handled once, however often
+// stripe sends it
+func (app *application) subscriptionPaymentFailed(eventID string, inv *stripe.Invoice) error {
+	if inv.Subscription == nil {
+		return nil
+	}
+
+	recorded, err := app.DB.RecordStripeEvent(eventID, "invoice.payment_failed")
+	if err != nil || !recorded {
+		return err
+	}
+
+	var data struct {
+		FirstName    string
+		Amount       string
+		AttemptCount int64
+		NextAttempt  string
+		Link         string
+	}
+
+	payment := events.Payment{
+		Email:        inv.CustomerEmail,
+		Amount:       int(inv.AmountDue),
+		AttemptCount: int(inv.AttemptCount),
+	}
+
+	// without the order the event goes out without its id, and the email to the address stripe has
+	order, err := app.DB.GetOrderBySubscriptionID(inv.Subscription.ID)
+	if err != nil {
+		app.errorLog.Printf("finding the order of subscription %s whose payment failed: %v", inv.Subscription.ID, err)
+	} else {
+		payment.OrderID = order.ID
+		payment.Email = order.Customer.Email
+		data.FirstName = order.Customer.FirstName
+	}
+
+	app.publish(events.PaymentFailed, payment)
+
+	data.Amount = fmt.Sprintf("$%.2f", float64(inv.AmountDue)/100)
+	data.AttemptCount = inv.AttemptCount
+	data.Link = inv.HostedInvoiceURL
+	if inv.NextPaymentAttempt > 0 {
+		data.NextAttempt = time.Unix(inv.NextPaymentAttempt, 0).Format("January 2, 2006")
+	}
+
+	// the event is recorded; a failure to send the email must not make stripe send it again
+	err = app.SendMail("info@widget.com", payment.Email, "We could not renew your subscription", "payment-failed", data)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	return nil
+}
+
+// invoicePeriod returns the billing period a subscription invoice is for. The period on the invoice itself is the
+// period that just ended, so prefer the period of the subscription line
+func invoicePeriod(inv *stripe.Invoice) (time.Time, time.Time) {
+	if inv.Lines != nil {
+		for _, line := range inv.Lines.Data {
+			if line.Period != nil && line.Period.Start > 0 {
+				return time.Unix(line.Period.Start, 0), time.Unix(line.Period.End, 0)
+			}
+		}
+	}
+
+	return time.Unix(inv.PeriodStart, 0), time.Unix(inv.PeriodEnd, 0)
+}
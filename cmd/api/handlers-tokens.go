@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+	"github.com/ahmedkhaeld/ecommerce/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAPIKeyDays is the longest an API key can last; defaultAPIKeyDays is how long one lasts when not said
+const (
+	maxAPIKeyDays     = 365
+	defaultAPIKeyDays = 90
+)
+
+// maxTokenLength is the longest bearer token that is looked up
+const maxTokenLength = 255
+
+// signInTokenName names a sign in token after the browser or client it was issued to, so a user can tell their
+// sessions apart
+func signInTokenName(r *http.Request) string {
+	agent := r.UserAgent()
+	if agent == "" {
+		return "Sign in"
+	}
+
+	name := "Sign in from " + agent
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// issueTokens issues user an access token with scopes, and a refresh token for more like it, in family
+func (app *application) issueTokens(r *http.Request, user models.User, family string, scopes ...string) (*models.Token, *models.RefreshToken, error) {
+	token, err := models.GenerateToken(user.ID, app.config.auth.accessTTL, scopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.Name = signInTokenName(r)
+	token.Family = family
+
+	err = app.DB.InsertToken(token, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := models.GenerateRefreshToken(user.ID, family, app.config.auth.refreshTTL, scopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = app.DB.InsertRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token, which lasts from now. Each
+// refresh token is good once; one presented again means it was copied, and the whole sign in is revoked
+func (app *application) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	refreshToken, err := app.DB.UseRefreshToken(payload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			app.infoLog.Printf("refresh token reused from %s, sign in revoked", ratelimit.ClientIP(r))
+		}
+		app.invalidCredentials(w)
+		return
+	}
+
+	user, err := app.DB.GetOneUser(refreshToken.UserID)
+	if err != nil {
+		app.invalidCredentials(w)
+		return
+	}
+
+	token, refreshToken, err := app.issueTokens(r, user, refreshToken.Family, refreshToken.Scopes...)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error        bool                 `json:"error"`
+		Message      string               `json:"message"`
+		Token        *models.Token        `json:"authentication_token"`
+		RefreshToken *models.RefreshToken `json:"refresh_token"`
+	}
+
+	resp.Error = false
+	resp.Message = "token refreshed"
+	resp.Token = token
+	resp.RefreshToken = refreshToken
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// Logout revokes the token the request was made with and, for a sign in, every token refreshed from it
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	token := app.authenticatedToken(r)
+
+	err := app.DB.DeleteToken(token.ID, int(token.UserID))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Logged out"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// AllTokens lists the signed in user's sessions and API keys
+func (app *application) AllTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.DB.GetTokensForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Tokens  []*models.Token `json:"tokens"`
+		Current int             `json:"current"`
+		Scopes  []string        `json:"scopes"`
+	}
+
+	current := app.authenticatedToken(r)
+
+	resp.Tokens = tokens
+	for _, t := range tokens {
+		if t.ID == current.ID || (t.Family != "" && t.Family == current.Family) {
+			resp.Current = t.ID
+		}
+	}
+	resp.Scopes = models.APIKeyScopes
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateAPIKey issues the signed in user a long lived API key with some scopes. Its plain text is in the response,
+// and only there
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Days   int      `json:"days"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if payload.Days == 0 {
+		payload.Days = defaultAPIKeyDays
+	}
+
+	v := validator.New()
+	v.Check(payload.Name != "", "name", "Must be given")
+	v.Check(utf8.RuneCountInString(payload.Name) <= 255, "name", "Must be at most 255 characters")
+	v.Check(len(payload.Scopes) > 0, "scopes", "Must have at least one scope")
+	for _, s := range payload.Scopes {
+		v.Check(models.IsAPIKeyScope(s), "scopes", fmt.Sprintf("Unknown scope %q", s))
+	}
+	v.Check(payload.Days > 0 && payload.Days <= maxAPIKeyDays, "days", fmt.Sprintf("Must be from 1 to %d", maxAPIKeyDays))
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	token, err := models.GenerateToken(user.ID, time.Duration(payload.Days)*24*time.Hour, payload.Scopes...)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	token.Name = payload.Name
+
+	err = app.DB.InsertToken(token, *user)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Token   *models.Token `json:"token"`
+	}
+
+	resp.Error = false
+	resp.Message = "API key created"
+	resp.Token = token
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// RevokeToken revokes one of the signed in user's sessions or API keys
+func (app *application) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := app.DB.DeleteToken(id, app.authenticatedUser(r).ID)
+	if err != nil {
+		app.badRequest(w, r, fmt.Errorf("no token %d", id))
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Token revoked"
+	app.writeJSON(w, http.StatusOK, resp)
+}
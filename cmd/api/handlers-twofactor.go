@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/totp"
+	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+// twoFactorIssuer is the name authenticator apps show next to our codes
+const twoFactorIssuer = "Widgets Co."
+
+// recoveryCodeCount is how many recovery codes a user gets when they turn on two factor authentication
+const recoveryCodeCount = 10
+
+// trustedDeviceTTL is how long a device the user asks us to remember can skip the two factor code
+const trustedDeviceTTL = 30 * 24 * time.Hour
+
+// twoFactorInput is the part of a sign in that proves the second factor
+type twoFactorInput struct {
+	Code           string `json:"code"`
+	DeviceToken    string `json:"device_token"`
+	RememberDevice bool   `json:"remember_device"`
+}
+
+// checkTwoFactor checks the second factor of a user who has passed their password. A user with two factor
+// authentication turned on needs a code from their app, one of their recovery codes, or a device they asked us to
+// remember. When the check fails the response has been written and ok is false; when the user asked us to remember
+// the device, deviceToken is the token it should send next time
+func (app *application) checkTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, in twoFactorInput) (deviceToken string, ok bool) {
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return "", false
+	}
+
+	if !tf.Enabled {
+		return "", true
+	}
+
+	trusted, err := app.DB.TrustedDevice(user.ID, in.DeviceToken)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return "", false
+	}
+	if trusted {
+		return "", true
+	}
+
+	if in.Code == "" {
+		app.twoFactorRequired(w, "Enter the code from your authenticator app")
+		return "", false
+	}
+
+	valid, err := app.validTwoFactorCode(user.ID, tf, in.Code)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return "", false
+	}
+	if !valid {
+		// a code is only six digits, so guessing one counts against the account like guessing the password
+		app.failedLogin(r, user.Email)
+		app.twoFactorRequired(w, "Invalid two factor code")
+		return "", false
+	}
+
+	if in.RememberDevice {
+		deviceToken, err = app.DB.InsertTrustedDevice(user.ID, trustedDeviceTTL)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return "", false
+		}
+	}
+
+	return deviceToken, true
+}
+
+// validTwoFactorCode reports whether code is the current code for the user's secret, or one of their recovery codes;
+// either is used up
+func (app *application) validTwoFactorCode(userID int, tf models.TwoFactor, code string) (bool, error) {
+	secret, err := app.decryptSecret(tf.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now(), tf.LastStep); ok {
+		return app.DB.UseTwoFactorStep(userID, step)
+	}
+
+	return app.DB.UseRecoveryCode(userID, totp.NormalizeRecoveryCode(code))
+}
+
+// twoFactorRequired tells the client the password was right, but a two factor code is needed as well
+func (app *application) twoFactorRequired(w http.ResponseWriter, message string) {
+	var payload struct {
+		Error             bool   `json:"error"`
+		Message           string `json:"message"`
+		TwoFactorRequired bool   `json:"two_factor_required"`
+	}
+
+	payload.Error = true
+	payload.Message = message
+	payload.TwoFactorRequired = true
+	app.writeJSON(w, http.StatusUnauthorized, payload)
+}
+
+// decryptSecret decrypts a two factor secret as it is stored in the database
+func (app *application) decryptSecret(secret string) (string, error) {
+	encrypter := encryption.Encryption{
+		Key: []byte(app.config.secretkey),
+	}
+	return encrypter.Decrypt(secret)
+}
+
+// TwoFactorStatus tells the signed in user whether they have two factor authentication turned on, whether everyone
+// must, and how many recovery codes they have left
+func (app *application) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	required, err := app.DB.GetSetting(models.SettingRequireTwoFactor)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	codes, err := app.DB.CountRecoveryCodes(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Enabled       bool `json:"enabled"`
+		Required      bool `json:"required"`
+		RecoveryCodes int  `json:"recovery_codes"`
+	}
+
+	resp.Enabled = tf.Enabled
+	resp.Required = required == "1"
+	resp.RecoveryCodes = codes
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// SetupTwoFactor starts setting up two factor authentication for the signed in user: it makes them a new secret and
+// sends it back with the otpauth url, and the url as a qr code image for the page to show. Nothing changes at sign in
+// until EnableTwoFactor
+func (app *application) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		app.badRequest(w, r, errors.New("two factor authentication is already turned on"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	encrypter := encryption.Encryption{
+		Key: []byte(app.config.secretkey),
+	}
+	encryptedSecret, err := encrypter.Encrypt(secret)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.SetTwoFactorSecret(user.ID, encryptedSecret)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Secret string `json:"secret"`
+		URL    string `json:"url"`
+		QRCode string `json:"qr_code"` // a data url of a png
+	}
+
+	resp.Secret = secret
+	resp.URL = totp.URL(twoFactorIssuer, user.Email, secret)
+
+	png, err := qrcode.Encode(resp.URL, qrcode.Medium, 200)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	resp.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EnableTwoFactor turns on two factor authentication for the signed in user, once they prove their app has the
+// secret by sending its current code. The response has their recovery codes, which are never shown again
+func (app *application) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		app.badRequest(w, r, errors.New("two factor authentication is already turned on"))
+		return
+	}
+	if tf.Secret == "" {
+		app.badRequest(w, r, errors.New("two factor authentication has not been set up"))
+		return
+	}
+
+	secret, err := app.decryptSecret(tf.Secret)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	step, ok := totp.Validate(secret, payload.Code, time.Now(), tf.LastStep)
+	if ok {
+		// the code that turned it on cannot sign in as well
+		ok, err = app.DB.UseTwoFactorStep(user.ID, step)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		app.badRequest(w, r, errors.New("invalid code, check the time on your device and try again"))
+		return
+	}
+
+	app.writeRecoveryCodes(w, r, user.ID, "Two factor authentication turned on")
+}
+
+// RegenerateRecoveryCodes replaces the signed in user's recovery codes, on a code from their app
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !tf.Enabled {
+		app.badRequest(w, r, errors.New("two factor authentication is not turned on"))
+		return
+	}
+
+	valid, err := app.validTwoFactorCode(user.ID, tf, payload.Code)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !valid {
+		app.badRequest(w, r, errors.New("invalid two factor code"))
+		return
+	}
+
+	app.writeRecoveryCodes(w, r, user.ID, "New recovery codes made")
+}
+
+// writeRecoveryCodes gives a user new recovery codes, turning on two factor authentication if it was not, and writes
+// them out
+func (app *application) writeRecoveryCodes(w http.ResponseWriter, r *http.Request, userID int, message string) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.EnableTwoFactor(userID, codes)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	resp.Error = false
+	resp.Message = message
+	resp.RecoveryCodes = codes
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DisableTwoFactor turns off two factor authentication for the signed in user, on a code from their app or a
+// recovery code. It cannot be turned off while it is required of everyone
+func (app *application) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	required, err := app.DB.GetSetting(models.SettingRequireTwoFactor)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if required == "1" {
+		app.badRequest(w, r, errors.New("two factor authentication is required for all users"))
+		return
+	}
+
+	tf, err := app.DB.GetTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !tf.Enabled {
+		app.badRequest(w, r, errors.New("two factor authentication is not turned on"))
+		return
+	}
+
+	valid, err := app.validTwoFactorCode(user.ID, tf, payload.Code)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if !valid {
+		app.badRequest(w, r, errors.New("invalid two factor code"))
+		return
+	}
+
+	err = app.DB.DisableTwoFactor(user.ID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Two factor authentication turned off"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// ResetTwoFactor turns off two factor authentication for a user who has lost their app and their recovery codes, so
+// they can sign in with their password and set it up again
+func (app *application) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := strconv.Atoi(id)
+
+	err := app.DB.DisableTwoFactor(userID)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	app.infoLog.Printf("user %d reset two factor authentication for user %d", app.authenticatedUser(r).ID, userID)
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Two factor authentication reset"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TwoFactorPolicy returns whether every user must use two factor authentication
+func (app *application) TwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	required, err := app.DB.GetSetting(models.SettingRequireTwoFactor)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Required bool `json:"required"`
+	}
+
+	resp.Required = required == "1"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EditTwoFactorPolicy sets whether every user must use two factor authentication. Users without it are sent to set it
+// up the next time they do anything
+func (app *application) EditTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Required bool `json:"required"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	value := "0"
+	if payload.Required {
+		value = "1"
+	}
+
+	err = app.DB.SetSetting(models.SettingRequireTwoFactor, value)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Two factor policy updated"
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/totp"
+)
+
+func TestTwoFactorCodeIsUsedOnce(t *testing.T) {
+	app := newTestApp(t)
+	app.config.secretkey = "bRWmrwNUTqNUuzckjxsFlHZjxHkjrzKP"
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter := encryption.Encryption{Key: []byte(app.config.secretkey)}
+	encrypted, err := encrypter.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.SetTwoFactorSecret(1, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.EnableTwoFactor(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		tf, err := app.DB.GetTwoFactor(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid, err := app.validTwoFactorCode(1, tf, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != want {
+			t.Errorf("use %d of the code: got %v, want %v", i+1, valid, want)
+		}
+	}
+
+	// a request that read the user before the code was used does not get to use it again either
+	valid, err := app.validTwoFactorCode(1, models.TwoFactor{Secret: encrypted, Enabled: true}, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Error("a code was used twice by requests that raced")
+	}
+}
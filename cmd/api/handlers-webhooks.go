@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookDeliveriesShown is how many of the latest deliveries of a webhook are listed
+const webhookDeliveriesShown = 100
+
+// AllWebhooks lists the registered webhooks, and the types of event they can be sent
+func (app *application) AllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.DB.GetAllWebhooks()
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Webhooks   []*models.Webhook `json:"webhooks"`
+		EventTypes []string          `json:"event_types"`
+	}
+
+	resp.Webhooks = webhooks
+	resp.EventTypes = events.Types
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateWebhook registers an endpoint to be sent the events of some types. Deliveries are signed with its secret,
+// which is made up when none is given; the secret is in the response, and only there
+func (app *application) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	u, err := url.Parse(payload.URL)
+	v := validator.New()
+	v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", "Must be an http or https URL")
+	v.Check(len(payload.URL) <= 2048, "url", "Must be at most 2048 characters")
+	v.Check(len(payload.EventTypes) > 0, "event_types", "Must have at least one type of event")
+	for _, t := range payload.EventTypes {
+		v.Check(events.IsType(t), "event_types", fmt.Sprintf("Unknown type of event %q", t))
+	}
+	v.Check(payload.Secret == "" || len(payload.Secret) >= 16, "secret", "Must be at least 16 characters")
+	v.Check(len(payload.Secret) <= 128, "secret", "Must be at most 128 characters")
+	if !v.Valid() {
+		app.failedValidation(w, r, v.Errors)
+		return
+	}
+
+	if payload.Secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+		payload.Secret = hex.EncodeToString(b)
+	}
+
+	encrypter := encryption.Encryption{
+		Key: []byte(app.config.secretkey),
+	}
+	encryptedSecret, err := encrypter.Encrypt(payload.Secret)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	id, err := app.DB.InsertWebhook(models.Webhook{
+		URL:        payload.URL,
+		EventTypes: payload.EventTypes,
+		Secret:     encryptedSecret,
+		Active:     true,
+	})
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		ID      int    `json:"id"`
+		Secret  string `json:"secret"`
+	}
+
+	resp.Error = false
+	resp.Message = "Webhook registered"
+	resp.ID = id
+	resp.Secret = payload.Secret
+	app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// EditWebhook pauses a webhook, or has it sent events again. Events that happen while it is paused are not sent
+func (app *application) EditWebhook(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	var payload struct {
+		Active bool `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	_, err = app.DB.GetWebhook(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No webhook %d", id))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.DB.SetWebhookActive(id, payload.Active)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Webhook paused"
+	if payload.Active {
+		resp.Message = "Webhook resumed"
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteWebhook deletes a webhook, and what was delivered to it
+func (app *application) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := app.DB.DeleteWebhook(id)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Webhook deleted"
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// WebhookDeliveries returns a webhook and its latest deliveries, with every attempt made at them
+func (app *application) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	webhook, err := app.DB.GetWebhook(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w, r, fmt.Sprintf("No webhook %d", id))
+		return
+	}
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	deliveries, err := app.DB.GetWebhookDeliveries(id, webhookDeliveriesShown)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var resp struct {
+		Webhook    models.Webhook            `json:"webhook"`
+		Deliveries []*models.WebhookDelivery `json:"deliveries"`
+	}
+
+	resp.Webhook = webhook
+	resp.Deliveries = deliveries
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// ReplayWebhookDelivery sends a delivery again, whether or not it got through before
+func (app *application) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := app.DB.ReplayWebhookDelivery(id)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	app.webhooks.Wake()
+
+	var resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	resp.Error = false
+	resp.Message = "Delivery queued to be sent again"
+	app.writeJSON(w, http.StatusOK, resp)
+}
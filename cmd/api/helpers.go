@@ -3,9 +3,19 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+	"github.com/go-chi/chi/v5"
 	"golang.org/x/crypto/bcrypt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // readJSON a helper that allows to read generic json, provide clean way to read any kind of json from a request, assuming
@@ -89,6 +99,37 @@ func (app *application) invalidCredentials(w http.ResponseWriter) error {
 	return nil
 }
 
+// forbidden tells the client that what it authenticated with does not allow the request
+func (app *application) forbidden(w http.ResponseWriter, message string) {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	payload.Error = true
+	payload.Message = message
+	app.writeJSON(w, http.StatusForbidden, payload)
+}
+
+// tooManyRequests tells the client to wait for retryAfter before trying again, in a Retry-After header and in the body
+func (app *application) tooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	var payload struct {
+		Error      bool   `json:"error"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after"`
+	}
+
+	payload.Error = true
+	payload.Message = fmt.Sprintf("%s, try again in %d seconds", message, seconds)
+	payload.RetryAfter = seconds
+
+	headers := make(http.Header)
+	headers.Set("Retry-After", strconv.Itoa(seconds))
+	app.writeJSON(w, http.StatusTooManyRequests, payload, headers)
+}
+
 // passwordMatches validate user password, takes 2 args that will be compared against each other, using the bcrypt pkg
 //hash is what is pulled out of the db, and the password
 // user entered on the input field,
@@ -119,3 +160,127 @@ func (app *application) failedValidation(w http.ResponseWriter, r *http.Request,
 	payload.Errors = errors
 	app.writeJSON(w, http.StatusUnprocessableEntity, payload)
 }
+
+// publish publishes an event of type typ on the bus
+func (app *application) publish(typ string, data interface{}) {
+	e, err := events.New(typ, data)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	err = app.events.Publish(e)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// orderStatusURL returns the signed link to the front end's status page of the order with orderID
+func (app *application) orderStatusURL(orderID int) string {
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	return signer.GenerateTokenFromString(fmt.Sprintf("%s/order-status?order=%d", app.config.frontend, orderID))
+}
+
+// readParams reads the parameters of a request into data: from the query string of a GET, by the json names of data's
+// fields, and from the JSON body of anything else
+func (app *application) readParams(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	if r.Method != http.MethodGet {
+		return app.readJSON(w, r, data)
+	}
+	return decodeQuery(r.URL.Query(), reflect.ValueOf(data).Elem())
+}
+
+// decodeQuery sets the fields of the struct v that are in qs. Numbers, strings, booleans and comma separated lists
+// of strings are all a query string has to give
+func decodeQuery(qs url.Values, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			err := decodeQuery(qs, v.Field(i))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || !qs.Has(name) {
+			continue
+		}
+		value := qs.Get(name)
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			v.Field(i).SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number", name)
+			}
+			v.Field(i).SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false", name)
+			}
+			v.Field(i).SetBool(b)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.String {
+				v.Field(i).Set(reflect.ValueOf(strings.Split(value, ",")))
+			}
+		}
+	}
+	return nil
+}
+
+// idParam returns the id in the path of the request, or id, which the routes from before /api/v1 take in the body
+func idParam(r *http.Request, id int) int {
+	if param := chi.URLParam(r, "id"); param != "" {
+		id, _ = strconv.Atoi(param)
+	}
+	return id
+}
+
+// pageParams returns the page and page size asked for, defaulted as the models default them, so a response can say
+// which page it holds
+func pageParams(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	return page, pageSize
+}
+
+// notFound tells the client that what it asked for does not exist
+func (app *application) notFound(w http.ResponseWriter, r *http.Request, message string) {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	payload.Error = true
+	payload.Message = message
+	app.writeJSON(w, http.StatusNotFound, payload)
+}
+
+// routeNotFound answers requests for a route the api does not have
+func (app *application) routeNotFound(w http.ResponseWriter, r *http.Request) {
+	app.notFound(w, r, fmt.Sprintf("No route %s", r.URL.Path))
+}
+
+// methodNotAllowed answers requests for a route with a method it does not take
+func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	payload.Error = true
+	payload.Message = fmt.Sprintf("%s does not take %s", r.URL.Path, r.Method)
+	app.writeJSON(w, http.StatusMethodNotAllowed, payload)
+}
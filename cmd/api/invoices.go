@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// invoiceTimeout is how long a call of the invoicing microservice may take; writing a pdf and emailing it is slow
+const invoiceTimeout = 30 * time.Second
+
+// callInvoiceMicro has the invoicing microservice write the invoice of order and email it to the customer
+func (app *application) callInvoiceMicro(order *invoicepb.Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), invoiceTimeout)
+	defer cancel()
+
+	_, err := app.invoices.CreateInvoice(ctx, &invoicepb.CreateInvoiceRequest{Order: order})
+	if err != nil {
+		return err
+	}
+
+	app.invoiceSent(order)
+	return nil
+}
+
+// invoiceSent records that the invoice of order was emailed to the customer
+func (app *application) invoiceSent(order *invoicepb.Order) {
+	email := order.GetCustomer().GetEmail()
+
+	// the microservice has no database, so the email it sent is recorded here
+	err := app.DB.InsertEmailLog(models.EmailLog{
+		Recipient: email,
+		Subject:   "Your Invoice",
+		Template:  "invoice",
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	err = app.DB.MarkOrderInvoiced(int(order.GetId()))
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+	app.publish(events.InvoiceSent, events.Order{
+		OrderID: int(order.GetId()),
+		Amount:  int(order.GetAmount()),
+		Email:   email,
+	})
+}
+
+// invoiceRef returns the invoice a request is about: that of the order with the id in the url, or, with the query
+// parameter period_start (a date like 2022-01-31), that of the renewal of a subscription for the period starting then
+func (app *application) invoiceRef(r *http.Request) (*invoicepb.InvoiceRef, error) {
+	ref := &invoicepb.InvoiceRef{
+		OrderId: int64(idParam(r, 0)),
+	}
+
+	if start := r.URL.Query().Get("period_start"); start != "" {
+		t, err := time.ParseInLocation("2006-01-02", start, time.Local)
+		if err != nil {
+			return nil, errors.New("period_start must be a date like 2022-01-31")
+		}
+		ref.PeriodStart = timestamppb.New(t)
+	}
+
+	return ref, nil
+}
+
+// InvoiceStatus says whether the invoice of an order was written, and when it was last emailed
+func (app *application) InvoiceStatus(w http.ResponseWriter, r *http.Request) {
+	ref, err := app.invoiceRef(r)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), invoiceTimeout)
+	defer cancel()
+
+	inv, err := app.invoices.GetInvoiceStatus(ctx, ref)
+	if err != nil {
+		app.invoiceError(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, invoiceStatus(ref, inv))
+}
+
+// ResendInvoice emails the invoice of an order to the customer again
+func (app *application) ResendInvoice(w http.ResponseWriter, r *http.Request) {
+	ref, err := app.invoiceRef(r)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), invoiceTimeout)
+	defer cancel()
+
+	inv, err := app.invoices.ResendInvoice(ctx, ref)
+	if err != nil {
+		app.invoiceError(w, r, err)
+		return
+	}
+
+	app.invoiceSent(inv.GetOrder())
+	app.writeJSON(w, http.StatusOK, invoiceStatus(ref, inv))
+}
+
+// invoiceError answers a request the invoicing microservice could not
+func (app *application) invoiceError(w http.ResponseWriter, r *http.Request, err error) {
+	if status.Code(err) == codes.NotFound {
+		app.notFound(w, r, status.Convert(err).Message())
+		return
+	}
+	app.badRequest(w, r, errors.New(status.Convert(err).Message()))
+}
+
+// invoiceStatusResponse is what we say of an invoice
+type invoiceStatusResponse struct {
+	OrderID   int        `json:"order_id"`
+	File      string     `json:"file"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	Sends     int        `json:"sends"`
+}
+
+// invoiceStatus returns what we say of inv, the invoice ref picks out
+func invoiceStatus(ref *invoicepb.InvoiceRef, inv *invoicepb.Invoice) invoiceStatusResponse {
+	resp := invoiceStatusResponse{
+		OrderID:   int(ref.GetOrderId()),
+		File:      inv.GetFile(),
+		Status:    "created",
+		CreatedAt: inv.GetCreatedAt().AsTime(),
+		Sends:     int(inv.GetSends()),
+	}
+	if inv.GetStatus() == invoicepb.InvoiceStatus_INVOICE_STATUS_SENT {
+		resp.Status = "sent"
+	}
+	if inv.SentAt != nil {
+		sentAt := inv.GetSentAt().AsTime()
+		resp.SentAt = &sentAt
+	}
+	return resp
+}
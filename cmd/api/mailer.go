@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
 	mail "github.com/xhit/go-simple-mail/v2"
 	"html/template"
 	"time"
@@ -73,5 +74,14 @@ func (app *application) SendMail(from, to, subject, tmpl string, data interface{
 	}
 	app.infoLog.Println("send mail")
 
+	err = app.DB.InsertEmailLog(models.EmailLog{
+		Recipient: to,
+		Subject:   subject,
+		Template:  tmpl,
+	})
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
 	return nil
 }
@@ -1,14 +1,236 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+)
+
+// contextKey is the type of the keys we store values in a request context under
+type contextKey string
+
+// userContextKey and tokenContextKey are where Auth puts the authenticated user and the token they used;
+// deprecatedContextKey is where Deprecated marks the requests of the routes from before /api/v1
+const (
+	userContextKey       = contextKey("user")
+	tokenContextKey      = contextKey("token")
+	deprecatedContextKey = contextKey("deprecated")
+)
+
+// Auth lets in requests with a token that signs a user in
 func (app *application) Auth(next http.Handler) http.Handler {
+	return app.AuthScope(models.ScopeAuthentication)(next)
+}
+
+// AuthScope lets in requests with a token that signs a user in, or with an API key that has scope; with no scope, any
+// API key will do and the handler checks the scopes of what it is asked for
+func (app *application) AuthScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, token, err := app.authenticateToken(r)
+			if err != nil {
+				app.invalidCredentials(w)
+				return
+			}
+
+			if scope != "" && !token.HasScope(scope) {
+				app.forbidden(w, fmt.Sprintf("This token does not have the %s scope", scope))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			ctx = context.WithValue(ctx, tokenContextKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireTwoFactor turns away users who must set up two factor authentication and have not yet; it goes after Auth
+func (app *application) RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required, err := app.DB.TwoFactorSetupRequired(app.authenticatedUser(r).ID)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		if required {
+			var payload struct {
+				Error                  bool   `json:"error"`
+				Message                string `json:"message"`
+				TwoFactorSetupRequired bool   `json:"two_factor_setup_required"`
+			}
+			payload.Error = true
+			payload.Message = "Two factor authentication must be set up first"
+			payload.TwoFactorSetupRequired = true
+			app.writeJSON(w, http.StatusForbidden, payload)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticatedUser returns the user Auth authenticated the request as
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// authenticatedToken returns the token Auth authenticated the request with
+func (app *application) authenticatedToken(r *http.Request) *models.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*models.Token)
+	return token
+}
+
+// RateLimit turns away with a 429 the requests of a client over rate. Clients are told apart by user when it goes after
+// Auth, and by ip address otherwise; name keeps the buckets of different limits apart
+func (app *application) RateLimit(name string, rate ratelimit.Rate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if app.limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := fmt.Sprintf("api:%s:ip:%s", name, ratelimit.ClientIP(r))
+			if user := app.authenticatedUser(r); user != nil {
+				key = fmt.Sprintf("api:%s:user:%d", name, user.ID)
+			}
+
+			wait, err := app.limiter.Take(key, rate)
+			if err != nil {
+				// let the request through rather than have the api go down with the store
+				app.errorLog.Println(err)
+			}
+
+			if wait > 0 {
+				app.tooManyRequests(w, "Too many requests", wait)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRF turns away requests from a browser that could change something unless they carry a CSRF token the front end
+// issued. Browsers send an Origin header with such requests, or at least a Referer; other clients, which a page cannot
+// make act for a user, do not need a token. A request made with a user's api token needs a CSRF token bound to the
+// same sign in, so one fetched in another session does not pass for it
+func (app *application) CSRF(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := app.authenticateToken(r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Origin") == "" && r.Header.Get("Referer") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrf.HeaderName)
+		var err error
+		if _, t, authErr := app.authenticateToken(r); authErr == nil {
+			err = app.csrf.Verify(token, t.Family)
+		} else {
+			// the request acts for no one, and a request with a token that does not work is turned away by Auth
+			err = app.csrf.VerifySignature(token)
+		}
 		if err != nil {
-			app.invalidCredentials(w)
+			app.forbidden(w, "Missing or expired CSRF token, reload the page and try again")
 			return
 		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Deprecated marks the responses of the routes from before /api/v1 as deprecated, and links to the document of the
+// routes that replace them
+func (app *application) Deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</api/v1/openapi.json>; rel="deprecation"; type="application/vnd.oai.openapi+json"`)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deprecatedContextKey, true)))
+	})
+}
+
+// deprecated reports whether the request came in on one of the routes from before /api/v1
+func (app *application) deprecated(r *http.Request) bool {
+	deprecated, _ := r.Context().Value(deprecatedContextKey).(bool)
+	return deprecated
+}
+
+// Envelope puts the JSON the handlers write into the shape every /api/v1 response has: {"data": ...} when the request
+// succeeded, and {"error": {"code", "message", "fields", "details"}} when it did not. Anything but JSON, like an
+// export, goes through as it is
+func (app *application) Envelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+
+		if ew.passThrough {
+			return
+		}
+
+		if !ew.wroteHeader {
+			// a handler that answers nothing has failed without saying so
+			ew.status = http.StatusInternalServerError
+		}
+
+		out, err := envelope(ew.status, ew.buf.Bytes())
+		if err != nil {
+			app.errorLog.Println(err)
+			ew.status = http.StatusInternalServerError
+			out, _ = envelope(ew.status, nil)
+		}
+
+		w.Header().Del("Content-Length")
+		app.writeJSON(w, ew.status, out)
+	})
+}
+
+// envelopeWriter holds back the JSON a handler writes, so Envelope can reshape it
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	passThrough bool
+	buf         bytes.Buffer
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	ew.status = status
+
+	if !strings.HasPrefix(ew.Header().Get("Content-Type"), "application/json") {
+		ew.passThrough = true
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.passThrough {
+		return ew.ResponseWriter.Write(b)
+	}
+	return ew.buf.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, as the exports do to stream for longer
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
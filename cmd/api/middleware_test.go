@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+)
+
+// signIn issues the admin an api token of a sign in of its own, and returns it with its family
+func signIn(t *testing.T, app *application) (string, string) {
+	t.Helper()
+
+	family, err := models.NewTokenFamily()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := models.GenerateToken(1, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.Family = family
+
+	err = app.DB.InsertToken(token, models.User{ID: 1, LastName: "User", Email: "admin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.PlainText, family
+}
+
+func TestCSRFTokenOfOneSessionIsRejectedOnAnother(t *testing.T) {
+	app := newTestApp(t)
+	app.csrf = csrf.Tokens{Secret: []byte("test secret"), TTL: time.Hour}
+	handler := app.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	bearerA, familyA := signIn(t, app)
+	bearerB, _ := signIn(t, app)
+
+	tokenA, err := app.csrf.Generate(familyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := app.csrf.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"token of the session", map[string]string{"Origin": "http://localhost:4000", "Authorization": "Bearer " + bearerA, csrf.HeaderName: tokenA}, http.StatusOK},
+		{"token of another session", map[string]string{"Origin": "http://localhost:4000", "Authorization": "Bearer " + bearerB, csrf.HeaderName: tokenA}, http.StatusForbidden},
+		{"token from before signing in", map[string]string{"Origin": "http://localhost:4000", "Authorization": "Bearer " + bearerA, csrf.HeaderName: anonymous}, http.StatusForbidden},
+		{"no token", map[string]string{"Origin": "http://localhost:4000", "Authorization": "Bearer " + bearerA}, http.StatusForbidden},
+		{"only a referer", map[string]string{"Referer": "http://localhost:4000/admin/all-sales", "Authorization": "Bearer " + bearerB, csrf.HeaderName: tokenA}, http.StatusForbidden},
+		{"only a referer with the token of the session", map[string]string{"Referer": "http://localhost:4000/admin/all-sales", "Authorization": "Bearer " + bearerA, csrf.HeaderName: tokenA}, http.StatusOK},
+		{"signed out with any token", map[string]string{"Origin": "http://localhost:4000", csrf.HeaderName: tokenA}, http.StatusOK},
+		{"signed out without a token", map[string]string{"Referer": "http://localhost:4000/"}, http.StatusForbidden},
+		{"not a browser", map[string]string{"Authorization": "Bearer " + bearerB}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/orders/1/refund", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
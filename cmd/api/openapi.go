@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI is the OpenAPI 3 document of /api/v1. The tests fail while it and the routes disagree
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// OpenAPI serves the OpenAPI 3 document of /api/v1
+func (app *application) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.oai.openapi+json")
+	w.Write(openAPI)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPIMethods are the operations of a path item in the document
+var openAPIMethods = []string{"get", "put", "post", "patch", "delete"}
+
+// checkOpenAPI makes sure the document has every route under /api/v1 that mux has, and nothing more
+func checkOpenAPI(mux chi.Routes) error {
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Servers []struct{ URL string }                `json:"servers"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err := json.Unmarshal(openAPI, &doc)
+	if err != nil {
+		return fmt.Errorf("openapi.json: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || len(doc.Servers) == 0 {
+		return fmt.Errorf("openapi.json: not an OpenAPI 3 document with a server")
+	}
+	base := doc.Servers[0].URL
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for _, method := range openAPIMethods {
+			if _, ok := item[method]; ok {
+				documented[strings.ToUpper(method)+" "+base+path] = true
+			}
+		}
+	}
+
+	var undocumented []string
+	err = chi.Walk(mux, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, base+"/") {
+			return nil
+		}
+		route = strings.TrimSuffix(route, "/")
+
+		op := method + " " + route
+		if documented[op] {
+			delete(documented, op)
+		} else {
+			undocumented = append(undocumented, op)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, op := range undocumented {
+		problems = append(problems, op+" is not in openapi.json")
+	}
+	for op := range documented {
+		problems = append(problems, op+" is in openapi.json but not routed")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi.json and the routes disagree: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	app := &application{}
+
+	err := checkOpenAPI(app.routes())
+	if err != nil {
+		t.Error(err)
+	}
+}
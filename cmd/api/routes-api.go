@@ -1,49 +1,234 @@
 package main
 
 import (
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
-	"net/http"
 )
 
-func (app *application) routes() http.Handler {
+func (app *application) routes() *chi.Mux {
 	mux := chi.NewRouter()
+	mux.Use(app.headers.Handler)
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Deprecation", "Link", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 
-	mux.Post("/api/payment-intent", app.GetPaymentIntent)
+	// stripe signs every webhook, and retries those we turn away, so they are not rate limited
+	mux.Post("/api/stripe-webhook", app.StripeWebhook)
 
-	mux.Get("/api/widget/{id}", app.GetWidgetByID)
+	payments := ratelimit.PerMinute(app.config.limiter.paymentRPM)
+	auth := ratelimit.PerMinute(app.config.limiter.authRPM)
 
-	mux.Post("/api/customer-subscription-plan", app.CreateCustomerAndSubscriptionPlan)
+	// scoped groups routes that take a token that signs a user in, or an API key with scope
+	scoped := func(mux chi.Router, scope string, routes func(mux chi.Router)) {
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.AuthScope(scope))
+			mux.Use(app.RequireTwoFactor)
+			mux.Use(app.RateLimit("admin", ratelimit.PerMinute(app.config.limiter.rpm)))
+			routes(mux)
+		})
+	}
 
-	mux.Post("/api/authenticate", app.CreateAuthToken)
-	mux.Post("/api/is-authenticated", app.CheckAuthentication)
-	mux.Post("/api/forgot-password", app.SendPasswordResetEmail)
-	mux.Post("/api/reset-password", app.ResetPassword)
+	// the api as it is documented in openapi.json; every response is a {"data": ...} or an {"error": ...}
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(app.Envelope)
+		mux.Use(app.RateLimit("api", ratelimit.PerMinute(app.config.limiter.rpm)))
+		mux.Use(app.CSRF)
 
-	// create a new mux and apply middleware to it, group certain routes logically into one location
-	mux.Route("/api/admin", func(mux chi.Router) {
-		mux.Use(app.Auth)
+		mux.NotFound(app.routeNotFound)
+		mux.MethodNotAllowed(app.methodNotAllowed)
 
-		mux.Post("/virtual-terminal-succeeded", app.VirtualTerminalSucceeded)
-		mux.Post("/all-sales", app.AllSales)
-		mux.Post("/all-subscriptions", app.AllSubscriptions)
-		mux.Post("/sale/{id}", app.Sale)
+		mux.Get("/openapi.json", app.OpenAPI)
 
-		mux.Post("/refund", app.RefundCharge)
-		mux.Post("/cancel-subscription", app.CancelSubscription)
+		mux.With(app.RateLimit("payment-intent", payments)).Post("/payment-intents", app.GetPaymentIntent)
+		mux.Get("/widgets/{id}", app.GetWidgetByID)
+		mux.With(app.RateLimit("customer-subscription-plan", payments)).Post("/subscriptions", app.CreateCustomerAndSubscriptionPlan)
 
-		mux.Post("/all-users", app.AllUsers)
-		mux.Post("/all-users/{id}", app.OneUser)
-		mux.Post("/all-users/edit/{id}", app.EditUser)
-		mux.Post("/all-users/delete/{id}", app.DeleteUser)
+		mux.With(app.RateLimit("authenticate", auth)).Post("/tokens", app.CreateAuthToken)
+		mux.With(app.RateLimit("refresh", auth)).Post("/tokens/refresh", app.RefreshToken)
+		mux.Get("/tokens/current", app.CheckAuthentication)
+		mux.With(app.Auth).Delete("/tokens/current", app.Logout)
+		mux.With(app.RateLimit("forgot-password", auth)).Post("/password-resets", app.SendPasswordResetEmail)
+		mux.With(app.RateLimit("reset-password", auth)).Put("/password", app.ResetPassword)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.Auth)
+			mux.Use(app.RateLimit("two-factor", auth))
+
+			mux.Get("/two-factor", app.TwoFactorStatus)
+			mux.Post("/two-factor/setup", app.SetupTwoFactor)
+			mux.Post("/two-factor/enable", app.EnableTwoFactor)
+			mux.Post("/two-factor/disable", app.DisableTwoFactor)
+			mux.Post("/two-factor/recovery-codes", app.RegenerateRecoveryCodes)
+		})
+
+		mux.With(app.RateLimit("privacy-request", auth)).Post("/privacy-requests", app.PrivacyRequest)
+		mux.Post("/privacy/export", app.PrivacyExport)
+		mux.Post("/privacy/erase", app.PrivacyErase)
+
+		scoped(mux, models.ScopeOrdersRead, func(mux chi.Router) {
+			mux.Get("/orders", app.AllSales)
+			mux.Get("/orders/{id}", app.Sale)
+			mux.Get("/orders/{id}/invoice", app.InvoiceStatus)
+			mux.Get("/subscriptions", app.AllSubscriptions)
+			mux.Get("/fulfilment-statuses", app.FulfilmentStatuses)
+			mux.Get("/analytics", app.SalesAnalytics)
+			mux.Get("/exports", app.ExportDatasets)
+			mux.Get("/exports/{dataset}", app.ExportData)
+		})
+
+		scoped(mux, models.ScopeOrdersWrite, func(mux chi.Router) {
+			mux.Post("/orders", app.VirtualTerminalSucceeded)
+			mux.Put("/orders/{id}/fulfilment", app.UpdateFulfilment)
+			mux.Post("/orders/{id}/invoice/resend", app.ResendInvoice)
+		})
+
+		scoped(mux, models.ScopeRefundsWrite, func(mux chi.Router) {
+			mux.Post("/orders/{id}/refund", app.RefundCharge)
+			mux.Post("/subscriptions/{id}/cancel", app.CancelSubscription)
+		})
+
+		scoped(mux, models.ScopeCustomersRead, func(mux chi.Router) {
+			mux.Get("/customers", app.AllCustomers)
+			mux.Get("/customers/{id}", app.OneCustomer)
+			mux.Post("/personal-data/export", app.AdminPrivacyExport)
+		})
+
+		scoped(mux, models.ScopeCustomersWrite, func(mux chi.Router) {
+			mux.Put("/customers/{id}", app.EditCustomer)
+			mux.Post("/customers/merge", app.MergeCustomers)
+			mux.Post("/personal-data/erase", app.AdminPrivacyErase)
+		})
+
+		scoped(mux, models.ScopeAuthentication, func(mux chi.Router) {
+			mux.Get("/users", app.AllUsers)
+			mux.Post("/users", app.EditUser)
+			mux.Get("/users/{id}", app.OneUser)
+			mux.Put("/users/{id}", app.EditUser)
+			mux.Delete("/users/{id}", app.DeleteUser)
+			mux.Delete("/users/{id}/two-factor", app.ResetTwoFactor)
+			mux.Delete("/users/{id}/lockout", app.UnlockUser)
+
+			mux.Get("/settings/two-factor", app.TwoFactorPolicy)
+			mux.Put("/settings/two-factor", app.EditTwoFactorPolicy)
+
+			mux.Get("/tokens", app.AllTokens)
+			mux.Delete("/tokens/{id}", app.RevokeToken)
+			mux.Post("/api-keys", app.CreateAPIKey)
+
+			mux.Get("/webhooks", app.AllWebhooks)
+			mux.Post("/webhooks", app.CreateWebhook)
+			mux.Patch("/webhooks/{id}", app.EditWebhook)
+			mux.Delete("/webhooks/{id}", app.DeleteWebhook)
+			mux.Get("/webhooks/{id}/deliveries", app.WebhookDeliveries)
+			mux.Post("/webhook-deliveries/{id}/replay", app.ReplayWebhookDelivery)
+		})
+
+		// each field of a query checks the scope it needs, so any key can ask for what it may read
+		scoped(mux, "", func(mux chi.Router) {
+			mux.Post("/graphql", app.GraphQL)
+		})
+	})
+
+	// the routes from before /api/v1, which the front end still uses; they keep their response shapes
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.Deprecated)
+		mux.Use(app.RateLimit("api", ratelimit.PerMinute(app.config.limiter.rpm)))
+		mux.Use(app.CSRF)
+
+		mux.With(app.RateLimit("payment-intent", payments)).Post("/api/payment-intent", app.GetPaymentIntent)
+
+		mux.Get("/api/widget/{id}", app.GetWidgetByID)
+
+		mux.With(app.RateLimit("customer-subscription-plan", payments)).Post("/api/customer-subscription-plan", app.CreateCustomerAndSubscriptionPlan)
+
+		mux.With(app.RateLimit("authenticate", auth)).Post("/api/authenticate", app.CreateAuthToken)
+		mux.Post("/api/is-authenticated", app.CheckAuthentication)
+		mux.With(app.RateLimit("refresh", auth)).Post("/api/refresh", app.RefreshToken)
+		mux.With(app.Auth).Post("/api/logout", app.Logout)
+		mux.With(app.RateLimit("forgot-password", auth)).Post("/api/forgot-password", app.SendPasswordResetEmail)
+		mux.With(app.RateLimit("reset-password", auth)).Post("/api/reset-password", app.ResetPassword)
+
+		// two factor authentication of the signed in user; reachable while it still has to be set up
+		mux.Route("/api/two-factor", func(mux chi.Router) {
+			mux.Use(app.Auth)
+			mux.Use(app.RateLimit("two-factor", auth))
+
+			mux.Post("/", app.TwoFactorStatus)
+			mux.Post("/setup", app.SetupTwoFactor)
+			mux.Post("/enable", app.EnableTwoFactor)
+			mux.Post("/disable", app.DisableTwoFactor)
+			mux.Post("/recovery-codes", app.RegenerateRecoveryCodes)
+		})
+
+		mux.With(app.RateLimit("privacy-request", auth)).Post("/api/privacy-request", app.PrivacyRequest)
+		mux.Post("/api/privacy/export", app.PrivacyExport)
+		mux.Post("/api/privacy/erase", app.PrivacyErase)
+
+		// create a new mux and apply middleware to it, group certain routes logically into one location
+		mux.Route("/api/admin", func(mux chi.Router) {
+			scoped(mux, models.ScopeOrdersRead, func(mux chi.Router) {
+				mux.Post("/all-sales", app.AllSales)
+				mux.Post("/all-subscriptions", app.AllSubscriptions)
+				mux.Post("/sale/{id}", app.Sale)
+				mux.Post("/fulfilment-statuses", app.FulfilmentStatuses)
+				mux.Post("/analytics", app.SalesAnalytics)
+				mux.Get("/export", app.ExportDatasets)
+				mux.Get("/export/{dataset}", app.ExportData)
+			})
+
+			scoped(mux, models.ScopeOrdersWrite, func(mux chi.Router) {
+				mux.Post("/virtual-terminal-succeeded", app.VirtualTerminalSucceeded)
+				mux.Post("/fulfilment", app.UpdateFulfilment)
+			})
+
+			scoped(mux, models.ScopeRefundsWrite, func(mux chi.Router) {
+				mux.Post("/refund", app.RefundCharge)
+				mux.Post("/cancel-subscription", app.CancelSubscription)
+			})
+
+			scoped(mux, models.ScopeCustomersRead, func(mux chi.Router) {
+				mux.Post("/customers", app.AllCustomers)
+				mux.Post("/customers/{id}", app.OneCustomer)
+				mux.Post("/privacy/export", app.AdminPrivacyExport)
+			})
+
+			scoped(mux, models.ScopeCustomersWrite, func(mux chi.Router) {
+				mux.Post("/customers/merge", app.MergeCustomers)
+				mux.Post("/customers/edit/{id}", app.EditCustomer)
+				mux.Post("/privacy/erase", app.AdminPrivacyErase)
+			})
+
+			scoped(mux, models.ScopeAuthentication, func(mux chi.Router) {
+				mux.Post("/all-users", app.AllUsers)
+				mux.Post("/all-users/{id}", app.OneUser)
+				mux.Post("/all-users/edit/{id}", app.EditUser)
+				mux.Post("/all-users/delete/{id}", app.DeleteUser)
+				mux.Post("/all-users/two-factor/reset/{id}", app.ResetTwoFactor)
+				mux.Post("/all-users/unlock/{id}", app.UnlockUser)
+
+				mux.Post("/settings/two-factor", app.TwoFactorPolicy)
+				mux.Post("/settings/two-factor/edit", app.EditTwoFactorPolicy)
+
+				mux.Post("/tokens", app.AllTokens)
+				mux.Post("/tokens/create", app.CreateAPIKey)
+				mux.Post("/tokens/revoke/{id}", app.RevokeToken)
+
+				mux.Post("/webhooks", app.AllWebhooks)
+				mux.Post("/webhooks/create", app.CreateWebhook)
+				mux.Post("/webhooks/edit/{id}", app.EditWebhook)
+				mux.Post("/webhooks/delete/{id}", app.DeleteWebhook)
+				mux.Post("/webhooks/{id}/deliveries", app.WebhookDeliveries)
+				mux.Post("/webhooks/deliveries/replay/{id}", app.ReplayWebhookDelivery)
+			})
+		})
 	})
 	return mux
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/secureheaders"
+)
+
+// securityHeaders returns the headers the api sends in the environment it runs in. It only answers with json, so its
+// CSP allows nothing at all; violations are reported to the front end, which collects them
+func securityHeaders(cfg config) (secureheaders.Policy, error) {
+	p := secureheaders.Policy{
+		CSP: []secureheaders.Directive{
+			{Name: "default-src", Sources: []string{"'none'"}},
+			{Name: "frame-ancestors", Sources: []string{"'none'"}},
+		},
+		ReportURI:      cfg.frontend + "/csp-report",
+		FrameOptions:   "DENY",
+		ReferrerPolicy: "no-referrer",
+	}
+
+	mode := cfg.csp
+	if mode == "" {
+		mode = "enforce"
+		if cfg.env == "development" {
+			mode = "report-only"
+		}
+	}
+
+	switch mode {
+	case "enforce":
+	case "report-only":
+		p.ReportOnly = true
+	case "off":
+		p.CSP = nil
+	default:
+		return p, fmt.Errorf("unknown csp mode %q", mode)
+	}
+
+	if cfg.env == "production" {
+		p.HSTS = 365 * 24 * time.Hour
+	}
+
+	return p, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+)
+
+// handleEvent acts on what happens elsewhere in the shop: a customer whose order is refunded is emailed to say so,
+// with the invoice of the order when we wrote one
+func (app *application) handleEvent(e events.Event) {
+	if e.Type != events.RefundIssued {
+		return
+	}
+
+	var refund events.Order
+	err := json.Unmarshal(e.Data, &refund)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	if refund.Email == "" {
+		return
+	}
+
+	var data struct {
+		FirstName string
+		OrderID   int
+		Product   string
+		Amount    string
+		Attached  bool
+	}
+	data.FirstName = refund.FirstName
+	data.OrderID = refund.OrderID
+	data.Product = refund.Product
+	data.Amount = fmt.Sprintf("$%.2f", float64(refund.Amount)/100)
+
+	var attachments []string
+	invoice := invoicePath(int64(refund.OrderID), nil)
+	if _, err := os.Stat(invoice); err == nil {
+		attachments = append(attachments, invoice)
+		data.Attached = true
+	}
+
+	err = app.SendMail("info@widget.com", refund.Email, "Your refund", "refund", attachments, data)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
@@ -1,65 +1,194 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
 	"github.com/phpdave11/gofpdf"
 	"github.com/phpdave11/gofpdf/contrib/gofpdi"
-	"net/http"
-	"time"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type Order struct {
-	ID        int       `json:"id"`
-	Quantity  int       `json:"quantity"`
-	Amount    int       `json:"amount"`
-	Product   string    `json:"product"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
+// invoicePath returns where the pdf invoice of an order is written; each billing period of a subscription gets its
+// own
+func invoicePath(orderID int64, periodStart *timestamppb.Timestamp) string {
+	if periodStart != nil {
+		return fmt.Sprintf("./invoices/%d-%s.pdf", orderID, periodStart.AsTime().Local().Format("20060102"))
+	}
+	return fmt.Sprintf("./invoices/%d.pdf", orderID)
 }
 
-func (app *application) CreatAndSendInvoice(w http.ResponseWriter, r *http.Request) {
+// orderInvoicePath returns where the pdf invoice of order is written
+func orderInvoicePath(order *invoicepb.Order) string {
+	return invoicePath(order.GetId(), order.GetPeriod().GetStart())
+}
 
-	// receive json
-	var order Order
+// recordPath returns where the record of the invoice at path is kept: what it was written for, and when it was sent
+func recordPath(path string) string {
+	return strings.TrimSuffix(path, ".pdf") + ".json"
+}
 
-	err := app.readJSON(w, r, &order)
-	if err != nil {
-		app.badRequest(w, r, err)
-		return
+// CreateInvoice writes the invoice of an order and emails it to the customer. An invoice that is written but could
+// not be emailed is kept, so it can be resent
+func (app *application) CreateInvoice(ctx context.Context, req *invoicepb.CreateInvoiceRequest) (*invoicepb.Invoice, error) {
+	order := req.GetOrder()
+	if order.GetId() == 0 || order.GetCustomer().GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "an invoice needs an order with an id and a customer email")
 	}
 
 	// generate a pdf invoice
-	err = app.createInvoicePDF(order)
+	path := orderInvoicePath(order)
+	err := app.createInvoicePDF(order)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	inv := &invoicepb.Invoice{
+		Order:     order,
+		File:      filepath.Base(path),
+		Status:    invoicepb.InvoiceStatus_INVOICE_STATUS_CREATED,
+		CreatedAt: timestamppb.Now(),
+	}
+	err = app.saveInvoice(path, inv)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return app.sendInvoice(path, inv)
+}
+
+// GetInvoiceStatus says whether the invoice of an order was written and when it was last emailed
+func (app *application) GetInvoiceStatus(ctx context.Context, ref *invoicepb.InvoiceRef) (*invoicepb.Invoice, error) {
+	return app.loadInvoice(invoicePath(ref.GetOrderId(), ref.GetPeriodStart()))
+}
+
+// ResendInvoice emails an invoice that was written before to its customer again
+func (app *application) ResendInvoice(ctx context.Context, ref *invoicepb.InvoiceRef) (*invoicepb.Invoice, error) {
+	path := invoicePath(ref.GetOrderId(), ref.GetPeriodStart())
+	inv, err := app.loadInvoice(path)
 	if err != nil {
-		app.badRequest(w, r, err)
-		return
+		return nil, err
+	}
+
+	if inv.GetOrder().GetCustomer().GetEmail() == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "there is no record of who invoice %s is for", inv.File)
 	}
 
-	// create mail attachment
-	attachments := []string{
-		fmt.Sprintf("./invoices/%d.pdf", order.ID),
+	return app.sendInvoice(path, inv)
+}
+
+// RedactInvoices writes again the pdf invoices of orders whose customer has had their personal data erased, with the
+// pseudonymised details it is sent. Only invoices that were written before are replaced, and nothing is emailed
+func (app *application) RedactInvoices(ctx context.Context, req *invoicepb.RedactInvoicesRequest) (*invoicepb.RedactInvoicesResponse, error) {
+	var redacted int32
+	for _, order := range req.GetOrders() {
+		path := orderInvoicePath(order)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		err := app.createInvoicePDF(order)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		// the record names the customer too
+		inv, err := app.loadInvoice(path)
+		if err != nil {
+			return nil, err
+		}
+		inv.Order = order
+		err = app.saveInvoice(path, inv)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		redacted++
+	}
+
+	return &invoicepb.RedactInvoicesResponse{Redacted: redacted}, nil
+}
+
+// sendInvoice emails the invoice at path to its customer, and records that it was sent
+func (app *application) sendInvoice(path string, inv *invoicepb.Invoice) (*invoicepb.Invoice, error) {
+	var data struct {
+		StatusURL string
 	}
+	data.StatusURL = inv.GetOrder().GetStatusUrl()
 
 	// send mail with attachment
-	err = app.SendMail("info@widget.com", order.Email, "Your Invoice", "invoice", attachments, nil)
+	err := app.SendMail("info@widget.com", inv.GetOrder().GetCustomer().GetEmail(), "Your Invoice", "invoice", []string{path}, data)
 	if err != nil {
-		app.badRequest(w, r, err)
-		return
+		return nil, status.Errorf(codes.Unavailable, "invoice %s was written but not sent: %s", inv.File, err)
 	}
 
-	// send response
-	var resp struct {
-		Error   bool   `json:"error"`
-		Message string `json:"message"`
+	inv.Status = invoicepb.InvoiceStatus_INVOICE_STATUS_SENT
+	inv.SentAt = timestamppb.Now()
+	inv.Sends++
+	err = app.saveInvoice(path, inv)
+	if err != nil {
+		// the customer has it; that the record is out of date is not worth failing the call over
+		app.errorLog.Println(err)
+	}
+
+	app.infoLog.Printf("Invoice %s sent to %s", inv.File, inv.GetOrder().GetCustomer().GetEmail())
+	return inv, nil
+}
+
+// loadInvoice returns what we know of the invoice at path. Invoices written before they were recorded are only known
+// to exist
+func (app *application) loadInvoice(path string) (*invoicepb.Invoice, error) {
+	app.records.Lock()
+	defer app.records.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "there is no invoice %s", filepath.Base(path))
+	}
+
+	inv := &invoicepb.Invoice{
+		File:      filepath.Base(path),
+		Status:    invoicepb.InvoiceStatus_INVOICE_STATUS_CREATED,
+		CreatedAt: timestamppb.New(info.ModTime()),
+	}
+
+	record, err := os.ReadFile(recordPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return inv, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-	resp.Error = false
-	resp.Message = fmt.Sprintf("Invoice %d.pdf created and sent to %s", order.ID, order.Email)
-	app.writeJSON(w, http.StatusCreated, resp)
+
+	err = protojson.Unmarshal(record, inv)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return inv, nil
 }
 
-func (app *application) createInvoicePDF(order Order) error {
+// saveInvoice records inv as what we know of the invoice at path
+func (app *application) saveInvoice(path string, inv *invoicepb.Invoice) error {
+	app.records.Lock()
+	defer app.records.Unlock()
+
+	out, err := protojson.MarshalOptions{Indent: "\t"}.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recordPath(path), out, 0644)
+}
+
+func (app *application) createInvoicePDF(order *invoicepb.Order) error {
 	pdf := gofpdf.New("P", "mm", "Letter", "")
 	pdf.SetMargins(10, 13, 10)
 	pdf.SetAutoPageBreak(true, 0)
@@ -71,30 +200,41 @@ func (app *application) createInvoicePDF(order Order) error {
 	pdf.AddPage()
 	importer.UseImportedTemplate(pdf, t, 0, 0, 215.9, 0)
 
+	customer := order.GetCustomer()
+
 	// write info
 	pdf.SetY(50)
 	pdf.SetX(10)
 	pdf.SetFont("Times", "", 11)
-	pdf.CellFormat(97, 8, fmt.Sprintf("Attention: %s %s", order.FirstName, order.LastName), "", 0, "L", false, 0, "")
+	pdf.CellFormat(97, 8, fmt.Sprintf("Attention: %s %s", customer.GetFirstName(), customer.GetLastName()), "", 0, "L", false, 0, "")
 	pdf.Ln(5)
-	pdf.CellFormat(97, 8, order.Email, "", 0, "L", false, 0, "")
+	pdf.CellFormat(97, 8, customer.GetEmail(), "", 0, "L", false, 0, "")
 	pdf.Ln(5)
-	pdf.CellFormat(97, 8, order.CreatedAt.Format("2006-01-02"), "", 0, "L", false, 0, "")
+	pdf.CellFormat(97, 8, localDate(order.GetCreatedAt()), "", 0, "L", false, 0, "")
+	if period := order.GetPeriod(); period != nil {
+		pdf.Ln(5)
+		pdf.CellFormat(97, 8, fmt.Sprintf("Billing period: %s to %s",
+			localDate(period.GetStart()), localDate(period.GetEnd())), "", 0, "L", false, 0, "")
+	}
 
 	pdf.SetX(58)
 	pdf.SetY(93)
-	pdf.CellFormat(155, 8, order.Product, "", 0, "L", false, 0, "")
+	pdf.CellFormat(155, 8, order.GetProduct(), "", 0, "L", false, 0, "")
 	pdf.SetX(166)
-	pdf.CellFormat(20, 8, fmt.Sprintf("%d", order.Quantity), "", 0, "C", false, 0, "")
+	pdf.CellFormat(20, 8, fmt.Sprintf("%d", order.GetQuantity()), "", 0, "C", false, 0, "")
 
 	pdf.SetX(185)
-	pdf.CellFormat(20, 8, fmt.Sprintf("$%.2f", float32(order.Amount/100.0)), "", 0, "R", false, 0, "")
+	pdf.CellFormat(20, 8, fmt.Sprintf("$%.2f", float32(order.GetAmount()/100.0)), "", 0, "R", false, 0, "")
 
-	invoicePath := fmt.Sprintf("./invoices/%d.pdf", order.ID)
-	err := pdf.OutputFileAndClose(invoicePath)
+	err := pdf.OutputFileAndClose(orderInvoicePath(order))
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// localDate returns the day t falls on here, like 2022-01-31
+func localDate(t *timestamppb.Timestamp) string {
+	return t.AsTime().In(time.Local).Format("2006-01-02")
+}
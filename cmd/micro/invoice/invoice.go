@@ -3,10 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+	"google.golang.org/grpc"
 	"log"
-	"net/http"
+	"net"
 	"os"
-	"time"
+	"sync"
 )
 
 const version = "1.0.0"
@@ -19,14 +24,23 @@ type config struct {
 		username string
 		password string
 	}
-	frontend string
+	db struct {
+		driver string
+		dsn    string // the database of the shop; we only hear about its events with one
+	}
+	frontend  string
+	secretkey string // the key shared with the services that call us, to sign requests
 }
 
 type application struct {
+	invoicepb.UnimplementedInvoiceServiceServer
+
 	config   config
 	infoLog  *log.Logger
 	errorLog *log.Logger
 	version  string
+	nonces   *urlsigner.NonceCache
+	records  sync.Mutex // held while the record of an invoice is read and written again
 }
 
 func main() {
@@ -38,6 +52,9 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtppass", "d8a12c98092796", "smtp password")
 	flag.IntVar(&cfg.smtp.port, "smtpport", 587, "smtp port")
 	flag.StringVar(&cfg.frontend, "frontend", "http://localhost:4000", "url to front end")
+	flag.StringVar(&cfg.secretkey, "secret", "bRWmrwNUTqNUuzckjxsFlHZjxHkjrzKP", "secret key")
+	flag.StringVar(&cfg.db.driver, "dbdriver", "mysql", "Database driver {mysql | postgres | sqlite3}")
+	flag.StringVar(&cfg.db.dsn, "dsn", "", "DSN of the shop's database, to hear about its events")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -48,10 +65,25 @@ func main() {
 		infoLog:  infoLog,
 		errorLog: errorLog,
 		version:  version,
+		nonces:   &urlsigner.NonceCache{TTL: signatureMaxAge},
 	}
 
 	app.CreateDirIfNotExist("./invoices")
 
+	if cfg.db.dsn != "" {
+		conn, err := driver.OpenDB(driver.Dialect(cfg.db.driver), cfg.db.dsn)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer conn.Close()
+
+		bus, err := events.NewDBBus(conn, errorLog)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		bus.Subscribe(app.handleEvent)
+	}
+
 	err := app.serve()
 	if err != nil {
 		log.Fatal(err)
@@ -59,17 +91,16 @@ func main() {
 }
 
 func (app *application) serve() error {
-	srv := &http.Server{
-		Addr:              fmt.Sprintf(":%d", app.config.port),
-		Handler:           app.routes(),
-		IdleTimeout:       30 * time.Second,
-		ReadTimeout:       10 * time.Second,
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      5 * time.Second,
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.port))
+	if err != nil {
+		return err
 	}
 
-	app.infoLog.Println(fmt.Sprintf("Starting invoice micro service on port %d", app.config.port))
+	// only our own services call us; every call must be signed with the shared secret
+	srv := grpc.NewServer(grpc.UnaryInterceptor(app.VerifySignature))
+	invoicepb.RegisterInvoiceServiceServer(srv, app)
 
-	return srv.ListenAndServe()
+	app.infoLog.Println(fmt.Sprintf("Starting invoice micro service on port %d", app.config.port))
 
+	return srv.Serve(lis)
 }
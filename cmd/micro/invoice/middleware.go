@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// signatureMaxAge is how old a signed request may be before it is rejected
+const signatureMaxAge = 5 * time.Minute
+
+// VerifySignature rejects any call that was not signed with our secret key, is too old, or has been seen before
+func (app *application) VerifySignature(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	nonce, err := invoicepb.VerifyCall(ctx, &signer, info.FullMethod, req, signatureMaxAge)
+	if err == nil && app.nonces.Seen(nonce) {
+		err = urlsigner.ErrReplayedRequest
+	}
+	if err != nil {
+		from := "unknown"
+		if p, ok := peer.FromContext(ctx); ok {
+			from = p.Addr.String()
+		}
+		app.errorLog.Printf("rejected call of %s from %s: %s", info.FullMethod, from, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return handler(ctx, req)
+}
@@ -0,0 +1,141 @@
+// Command migrate applies and rolls back the migrations of the shop's database, which it carries with it, recording
+// the versions applied in schema_migration as soda does.
+//
+//	migrate [flags] status         lists the migrations, and whether each has been applied
+//	migrate [flags] up             applies those that have not been
+//	migrate [flags] down [N]       rolls back the N applied last, 1 by default
+//	migrate [flags] create NAME    writes the empty up and down files of a new migration to -dir
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+)
+
+type config struct {
+	db struct {
+		driver string
+		dsn    string
+	}
+	dir string // where create writes new migrations
+	ext string // what create writes new migrations in
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.db.driver, "dbdriver", "mysql", "Database driver {mysql | postgres | sqlite3}")
+	flag.StringVar(&cfg.db.dsn, "dsn", "ahmed:secret@tcp(localhost:3306)/widgets?parseTime=true&tls=false", "DSN")
+	flag.StringVar(&cfg.dir, "dir", "./migrations", "Directory create writes new migrations to")
+	flag.StringVar(&cfg.ext, "type", "fizz", "What create writes new migrations in {fizz | sql}")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] status | up | down [N] | create NAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// create only writes files, so needs no database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		paths, err := migrate.Create(cfg.dir, args[1], cfg.ext)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return
+	}
+
+	conn, err := driver.OpenDB(driver.Dialect(cfg.db.driver), cfg.db.dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer conn.Close()
+
+	m := &migrate.Migrator{
+		DB:      conn,
+		Dialect: driver.Dialect(cfg.db.driver),
+		FS:      migrations.FS,
+	}
+
+	if args[0] == "status" {
+		err = status(m)
+		if err != nil {
+			conn.Close()
+			errorLog.Fatal(err)
+		}
+		return
+	}
+
+	var done []migrate.Migration
+	var what string
+	switch args[0] {
+	case "up":
+		what = "Applied"
+		done, err = m.Up()
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				errorLog.Fatalf("%q is not a number of migrations to roll back", args[1])
+			}
+		}
+		what = "Rolled back"
+		done, err = m.Down(n)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// what was done before a migration failed stays done
+	for _, mig := range done {
+		fmt.Printf("%s %s_%s\n", what, mig.Version, mig.Name)
+	}
+	if err != nil {
+		conn.Close()
+		errorLog.Fatal(err)
+	}
+	if len(done) == 0 {
+		fmt.Println("Nothing to do")
+	}
+}
+
+// status prints every migration, and whether it has been applied
+func status(m *migrate.Migrator) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Version\tName\tStatus")
+	for _, s := range statuses {
+		applied := "Pending"
+		if s.Applied {
+			applied = "Applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
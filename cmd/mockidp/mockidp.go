@@ -0,0 +1,80 @@
+// Command mockidp is an OpenID Connect identity provider to sign in to the front end with single sign on, locally and
+// in tests. It signs in whoever the sign in form says, or, with -auto, the user its flags describe without asking
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/mockidp"
+)
+
+const version = "1.0.0"
+
+type config struct {
+	port int
+	idp  mockidp.Config
+}
+
+type application struct {
+	config   config
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	version  string
+	idp      *mockidp.Provider
+}
+
+func main() {
+	var cfg config
+
+	flag.IntVar(&cfg.port, "port", 4003, "Server port to listen on")
+	flag.StringVar(&cfg.idp.Issuer, "issuer", "http://localhost:4003", "Issuer url, as the front end reaches it")
+	flag.StringVar(&cfg.idp.ClientID, "client-id", "widgets", "Client id of the front end")
+	flag.StringVar(&cfg.idp.ClientSecret, "client-secret", "", "Client secret of the front end, any when empty")
+	flag.BoolVar(&cfg.idp.Auto, "auto", false, "Sign in the user below without showing the sign in form")
+	flag.StringVar(&cfg.idp.User.Email, "email", "admin@example.com", "Email of the user to sign in")
+	flag.StringVar(&cfg.idp.User.Name, "name", "Admin User", "Name of the user to sign in")
+	flag.StringVar(&cfg.idp.User.Groups, "groups", "admins", "Comma separated groups of the user to sign in")
+	flag.BoolVar(&cfg.idp.User.EmailVerified, "email-verified", true, "Say in the id token that the email is verified")
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	idp, err := mockidp.New(cfg.idp, infoLog, errorLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	app := &application{
+		config:   cfg,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		version:  version,
+		idp:      idp,
+	}
+
+	err = app.serve()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.idp.Routes(),
+		IdleTimeout:       30 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+	}
+
+	app.infoLog.Println(fmt.Sprintf("Starting mock identity provider %s on port %d", app.config.idp.Issuer, app.config.port))
+
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+)
+
+// publish publishes an event of type typ on the bus
+func (app *application) publish(typ string, data interface{}) {
+	e, err := events.New(typ, data)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	err = app.events.Publish(e)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// pushToAdmins sends an event to the admin pages subscribed to its type. A deleted user is signed out of every page
+// they have open
+func (app *application) pushToAdmins(e events.Event) {
+	if e.Type == events.UserDeleted {
+		var u events.User
+		err := json.Unmarshal(e.Data, &u)
+		if err != nil {
+			app.errorLog.Println(err)
+			return
+		}
+
+		app.hub.SendToUser(u.UserID, WsJsonResponse{
+			Action:  "logout",
+			Message: "Your account has been deleted",
+			UserID:  u.UserID,
+		})
+		return
+	}
+
+	app.hub.Publish(e.Type, WsJsonResponse{
+		Action: "event",
+		Topic:  e.Type,
+		Data:   e.Data,
+	})
+}
+
+// orderPlaced takes what was sold out of stock, and tells the admins about the order, and about the widget when it is
+// running low
+func (app *application) orderPlaced(orderID int, order models.Order, email string) {
+	widget, err := app.DB.ReduceInventory(order.WidgetID, order.Quantity)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+
+	app.publish(events.OrderCreated, events.Order{
+		OrderID:  orderID,
+		Product:  widget.Name,
+		Quantity: order.Quantity,
+		Amount:   order.Amount,
+		Email:    email,
+	})
+
+	if err == nil && !widget.IsRecurring && widget.InventoryLevel <= models.LowInventoryLevel {
+		app.publish(events.LowInventory, events.Inventory{
+			WidgetID: widget.ID,
+			Name:     widget.Name,
+			Level:    widget.InventoryLevel,
+		})
+	}
+}
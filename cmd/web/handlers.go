@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/ahmedkhaeld/ecommerce/internal/cards"
 	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
 	"github.com/ahmedkhaeld/ecommerce/internal/models"
 	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
 	"github.com/go-chi/chi/v5"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"net/http"
 	"strconv"
 	"time"
@@ -195,16 +197,51 @@ func (app *application) VirtualTerminalReceipt(w http.ResponseWriter, r *http.Re
 }
 
 func (app *application) AllSales(w http.ResponseWriter, r *http.Request) {
-	if err := app.renderTemplate(w, r, "all-sales", nil); err != nil {
+	widgets, err := app.DB.GetAllWidgets()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["widgets"] = widgets
+	if err := app.renderTemplate(w, r, "all-sales", &templateData{
+		Data: data,
+	}, "order-filters"); err != nil {
 		app.errorLog.Println(err)
 	}
 }
 
 func (app *application) AllSubscriptions(w http.ResponseWriter, r *http.Request) {
-	if err := app.renderTemplate(w, r, "all-subscriptions", nil); err != nil {
+	widgets, err := app.DB.GetAllWidgets()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["widgets"] = widgets
+	if err := app.renderTemplate(w, r, "all-subscriptions", &templateData{
+		Data: data,
+	}, "order-filters"); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// Analytics displays the sales reporting dashboard
+func (app *application) Analytics(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "analytics", &templateData{}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// Export displays the page to download sales, subscriptions, customers and users as spreadsheets
+func (app *application) Export(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "export", &templateData{}); err != nil {
 		app.errorLog.Println(err)
 	}
 }
+
 func (app *application) ShowSale(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 	stringMap["title"] = "Sale"
@@ -213,8 +250,18 @@ func (app *application) ShowSale(w http.ResponseWriter, r *http.Request) {
 	stringMap["btn"] = "Refund Order"
 	stringMap["badge"] = "Refunded"
 	stringMap["msg"] = "Charge refunded"
+
+	// a widget sold is shipped; subscriptions have nothing to fulfil
+	statuses, err := app.DB.GetAllFulfilmentStatuses()
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+	data := make(map[string]interface{})
+	data["fulfilment"] = statuses
+
 	if err := app.renderTemplate(w, r, "sale", &templateData{
 		StringMap: stringMap,
+		Data:      data,
 	}); err != nil {
 		app.errorLog.Println(err)
 	}
@@ -234,17 +281,6 @@ func (app *application) ShowSubscription(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-type Invoice struct {
-	ID        int       `json:"id"`
-	Quantity  int       `json:"quantity"`
-	Amount    int       `json:"amount"`
-	Product   string    `json:"product"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 // PaymentSucceeded read submitted fields, write it to map, render map fields to receipt template
 func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
@@ -302,47 +338,74 @@ func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// call the microservice when a widget is sold
-	inv := Invoice{
-		ID:        orderID,
-		Amount:    order.Amount,
-		Product:   "Widget",
-		Quantity:  order.Quantity,
-		FirstName: txnData.FirstName,
-		LastName:  txnData.LastName,
-		Email:     txnData.Email,
-		CreatedAt: time.Now(),
-	}
-	err = app.callInvoiceMicro(inv)
-	if err != nil {
-		app.errorLog.Println(err)
-	}
+	app.orderPlaced(orderID, order, txnData.Email)
+
+	statusURL := app.orderStatusURL(orderID)
+
+	// call the microservice when a widget is sold. The receipt does not wait for it; the order status page shows
+	// the customer when their invoice is on its way
+	inv := &invoicepb.Order{
+		Id:       int64(orderID),
+		WidgetId: int64(widgetID),
+		Amount:   int64(order.Amount),
+		Product:  "Widget",
+		Quantity: int64(order.Quantity),
+		Customer: &invoicepb.Customer{
+			FirstName: txnData.FirstName,
+			LastName:  txnData.LastName,
+			Email:     txnData.Email,
+		},
+		CreatedAt: timestamppb.Now(),
+		StatusUrl: statusURL,
+	}
+	go func() {
+		err := app.callInvoiceMicro(inv)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}()
 
 	// write the data to session, and then redirect user to new page
 	app.Session.Put(r.Context(), "receipt", txnData)
+	app.Session.Put(r.Context(), "orderStatusURL", statusURL)
 	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
 
 }
-func (app *application) callInvoiceMicro(inv Invoice) error {
-	url := "http://localhost:5000/invoice/create-and-send"
-	out, err := json.MarshalIndent(inv, "", "\t")
+
+// invoiceTimeout is how long a call of the invoicing microservice may take; writing a pdf and emailing it is slow
+const invoiceTimeout = 30 * time.Second
+
+// callInvoiceMicro has the invoicing microservice write the invoice of order and email it to the customer
+func (app *application) callInvoiceMicro(order *invoicepb.Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), invoiceTimeout)
+	defer cancel()
+
+	inv, err := app.invoices.CreateInvoice(ctx, &invoicepb.CreateInvoiceRequest{Order: order})
 	if err != nil {
 		return err
 	}
+	app.infoLog.Printf("Invoice %s sent", inv.GetFile())
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(out))
+	// the microservice has no database, so the email it sent is recorded here
+	err = app.DB.InsertEmailLog(models.EmailLog{
+		Recipient: order.GetCustomer().GetEmail(),
+		Subject:   "Your Invoice",
+		Template:  "invoice",
+	})
 	if err != nil {
-		return err
+		app.errorLog.Println(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	err = app.DB.MarkOrderInvoiced(int(order.GetId()))
 	if err != nil {
-		return err
+		app.errorLog.Println(err)
 	}
-	defer resp.Body.Close()
-	app.infoLog.Println(resp.Body)
+	app.publish(events.InvoiceSent, events.Order{
+		OrderID: int(order.GetId()),
+		Amount:  int(order.GetAmount()),
+		Email:   order.GetCustomer().GetEmail(),
+	})
+
 	return nil
 }
 
@@ -351,12 +414,15 @@ func (app *application) Receipt(w http.ResponseWriter, r *http.Request) {
 	txn := app.Session.Get(r.Context(), "receipt").(TransactionData)
 	data := make(map[string]interface{})
 	data["txn"] = txn
+	stringMap := make(map[string]string)
+	stringMap["statusURL"] = app.Session.PopString(r.Context(), "orderStatusURL")
 	// 2. remove the data from the session
 	app.Session.Remove(r.Context(), "receipt")
 
 	// render a template displaying the receipt
 	if err := app.renderTemplate(w, r, "receipt", &templateData{
-		Data: data,
+		StringMap: stringMap,
+		Data:      data,
 	}); err != nil {
 		app.errorLog.Println(err)
 	}
@@ -409,7 +475,15 @@ func (app *application) BronzePlanReceipt(w http.ResponseWriter, r *http.Request
 
 // LoginPage log user in
 func (app *application) LoginPage(w http.ResponseWriter, r *http.Request) {
-	if err := app.renderTemplate(w, r, "login", &templateData{}); err != nil {
+	data := make(map[string]interface{})
+	data["SSO"] = app.sso != nil
+
+	td := &templateData{
+		Data:  data,
+		Error: app.Session.PopString(r.Context(), "error"),
+	}
+
+	if err := app.renderTemplate(w, r, "login", td); err != nil {
 		app.errorLog.Print(err)
 	}
 }
@@ -426,17 +500,74 @@ func (app *application) PostLoginPage(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
+	// failed attempts are counted, and the second factor checked, by the api when the login page asks it for a token,
+	// so a session is only started for a user who hands over a token the api issued to them
+	user, token, err := app.DB.GetToken(r.Form.Get("token"))
+	if err != nil || !token.HasScope(models.ScopeAuthentication) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
 	id, err := app.DB.Authenticate(email, password)
-	if err != nil {
+	if err != nil || id != user.ID {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
 	app.Session.Put(r.Context(), "userID", id)
+	// logging out of the session logs the api tokens of the sign in out too
+	app.Session.Put(r.Context(), "tokenFamily", token.Family)
+	// a new session gets a new CSRF token
+	app.Session.Remove(r.Context(), "csrfToken")
+
+	setupRequired, err := app.DB.TwoFactorSetupRequired(id)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+	if setupRequired {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// TwoFactor is where the signed in user sets up, or turns off, two factor authentication
+func (app *application) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "two-factor", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// Tokens shows the signed in user's sessions and API keys
+func (app *application) Tokens(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "tokens", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// Webhooks displays the endpoints of other systems that are sent our events, and where new ones are registered
+func (app *application) Webhooks(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "webhooks", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// ShowWebhook displays what was delivered to a webhook, and how each attempt went
+func (app *application) ShowWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "webhook", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
 func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	if family := app.Session.GetString(r.Context(), "tokenFamily"); family != "" {
+		err := app.DB.RevokeTokenFamily(family)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}
+
 	app.Session.Destroy(r.Context())
 	app.Session.RenewToken(r.Context())
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -487,6 +618,46 @@ func (app *application) ShowResetPassword(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// PrivacyRequest shows the form where customers ask for a copy of their personal data, or for it to be erased
+func (app *application) PrivacyRequest(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "privacy-request", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// PrivacyConfirm is where the signed link emailed for a personal data request leads. The page passes the link on to
+// the api, which checks it again before it exports or erases anything
+func (app *application) PrivacyConfirm(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("action")
+
+	theURL := r.RequestURI
+	testURL := fmt.Sprintf("%s%s", app.config.frontend, theURL)
+
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	valid := signer.VerifyToken(testURL)
+	if !valid {
+		app.errorLog.Println("invalid url - tampering detected")
+		return
+	}
+	expired := signer.Expired(testURL, 60)
+	if expired {
+		app.errorLog.Println("Link expired")
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["link"] = testURL
+	data["action"] = action
+
+	if err := app.renderTemplate(w, r, "privacy-confirm", &templateData{
+		Data: data,
+	}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
 func (app *application) AllUsers(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "all-users", &templateData{}); err != nil {
 		app.errorLog.Print(err)
@@ -498,3 +669,15 @@ func (app *application) OneUser(w http.ResponseWriter, r *http.Request) {
 		app.errorLog.Print(err)
 	}
 }
+
+func (app *application) AllCustomers(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "all-customers", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+func (app *application) OneCustomer(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "one-customer", &templateData{}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
 	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/invoicepb"
 	"github.com/ahmedkhaeld/ecommerce/internal/models"
-	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+	"github.com/ahmedkhaeld/ecommerce/internal/secureheaders"
+	"github.com/ahmedkhaeld/ecommerce/internal/sessionstore"
 	"github.com/alexedwards/scs/v2"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -25,14 +34,38 @@ type config struct {
 	env  string
 	api  string
 	db   struct {
-		dsn string
+		driver string
+		dsn    string
 	}
 	stripe struct {
 		secret string
 		key    string
 	}
+	auth struct {
+		accessTTL  time.Duration // how long an api access token lasts
+		refreshTTL time.Duration // how long a sign in lasts without being refreshed
+	}
+	oidc struct {
+		issuer       string // the identity provider; single sign on is off without one
+		clientID     string
+		clientSecret string
+		redirectURL  string
+		groupsClaim  string // the claim of the id token that lists the user's groups
+		roles        string // the token scopes each group gives, like "admins=authentication,support=orders:read"
+		createUsers  bool   // whether a user is created the first time someone signs in with an unknown email
+	}
+	limiter struct {
+		enabled    bool
+		store      string // memory, or db to share the limits between instances
+		rpm        int    // requests a minute a client may make
+		authRPM    int    // sign in attempts a minute
+		paymentRPM int    // payment attempts a minute
+	}
+	csp       string // enforce, report-only or off; by default it depends on env
+	events    string // the event bus: local, or db to hear about what happens in the api
 	secretkey string
 	frontend  string
+	invoice   string // the address of the invoicing microservice
 }
 
 type application struct {
@@ -43,9 +76,18 @@ type application struct {
 	version       string
 	DB            models.DBModel
 	Session       *scs.SessionManager
+	limiter       ratelimit.Store
+	sso           *singleSignOn
+	csrf          csrf.Tokens
+	headers       secureheaders.Policy
+	hub           *Hub
+	events        events.Bus
+	orderStreams  *orderStreams
+	invoices      invoicepb.InvoiceServiceClient
 }
 
-func (app *application) serve() error {
+// serve runs the front end until it fails, or ctx is done and it has finished the requests it was serving
+func (app *application) serve(ctx context.Context) error {
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", app.config.port),
 		Handler:           app.routes(),
@@ -57,7 +99,19 @@ func (app *application) serve() error {
 
 	app.infoLog.Println(fmt.Sprintf("Starting HTTP server in %s mode on port %d", app.config.env, app.config.port))
 
-	return srv.ListenAndServe()
+	shutdown := make(chan error)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(shutdownCtx)
+	}()
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdown
 
 }
 
@@ -69,20 +123,38 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "Server port to listen on")
 	flag.StringVar(&cfg.env, "env", "development", "Application environment {development | production")
+	flag.StringVar(&cfg.db.driver, "dbdriver", "mysql", "Database driver {mysql | postgres | sqlite3}")
 	flag.StringVar(&cfg.db.dsn, "dsn", "ahmed:secret@tcp(localhost:3306)/widgets?parseTime=true&tls=false", "DSN")
 	flag.StringVar(&cfg.api, "api", "http://localhost:4001", "URL to api")
 	flag.StringVar(&cfg.secretkey, "secret", "bRWmrwNUTqNUuzckjxsFlHZjxHkjrzKP", "secret key")
 	flag.StringVar(&cfg.frontend, "frontend", "http://localhost:4000", "url to front end")
+	flag.StringVar(&cfg.invoice, "invoice", "localhost:5000", "address of the invoice micro service")
+	flag.DurationVar(&cfg.auth.accessTTL, "access-ttl", 15*time.Minute, "How long an api access token lasts")
+	flag.DurationVar(&cfg.auth.refreshTTL, "refresh-ttl", 7*24*time.Hour, "How long a sign in lasts without being refreshed")
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "URL of the OpenID Connect identity provider for single sign on")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "widgets", "Client id registered with the identity provider")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect", "", "Callback URL registered with the identity provider, defaults to the front end's")
+	flag.StringVar(&cfg.oidc.groupsClaim, "oidc-groups-claim", "groups", "Id token claim listing the user's groups")
+	flag.StringVar(&cfg.oidc.roles, "oidc-roles", "admins=authentication", "Token scopes each group gives {group=scope scope,group=scope}")
+	flag.BoolVar(&cfg.oidc.createUsers, "oidc-create-users", true, "Create a user the first time someone signs in with an unknown email")
+	flag.StringVar(&cfg.csp, "csp", "", "Content Security Policy {enforce | report-only | off}, report-only in development and enforce otherwise by default")
+	flag.StringVar(&cfg.events, "events", "db", "Event bus {local | db}")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting")
+	flag.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limit store {memory | db}")
+	flag.IntVar(&cfg.limiter.rpm, "limiter-rpm", 300, "Requests a minute a client may make")
+	flag.IntVar(&cfg.limiter.authRPM, "limiter-auth-rpm", 10, "Sign in attempts a minute a client may make")
+	flag.IntVar(&cfg.limiter.paymentRPM, "limiter-payment-rpm", 5, "Payment attempts a minute a client may make")
 
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
 	cfg.stripe.secret = os.Getenv("STRIPE_SECRET")
+	cfg.oidc.clientSecret = os.Getenv("OIDC_CLIENT_SECRET")
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	conn, err := driver.OpenDB(cfg.db.dsn)
+	conn, err := driver.OpenDB(driver.Dialect(cfg.db.driver), cfg.db.dsn)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
@@ -91,7 +163,7 @@ func main() {
 	// 1. set up session
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
-	session.Store = mysqlstore.New(conn)
+	session.Store = sessionstore.New(conn, driver.Dialect(cfg.db.driver))
 
 	tc := make(map[string]*template.Template)
 
@@ -101,16 +173,61 @@ func main() {
 		errorLog:      errorLog,
 		templateCache: tc,
 		version:       version,
-		DB:            models.DBModel{DB: conn},
+		DB:            models.DBModel{DB: conn, Dialect: driver.Dialect(cfg.db.driver)},
 		Session:       session,
+		hub:           newHub(),
+		orderStreams:  newOrderStreams(),
+		csrf: csrf.Tokens{
+			Secret: []byte(cfg.secretkey),
+			TTL:    session.Lifetime,
+		},
 	}
 
+	app.events, err = events.NewBus(cfg.events, conn, errorLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	invoices, invoiceConn, err := invoicepb.Dial(cfg.invoice, []byte(cfg.secretkey))
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer invoiceConn.Close()
+	app.invoices = invoices
+
+	app.headers, err = securityHeaders(cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	if cfg.oidc.issuer != "" {
+		app.sso, err = setupSingleSignOn(cfg)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	if cfg.limiter.enabled {
+		app.limiter, err = ratelimit.New(cfg.limiter.store, conn, driver.Dialect(cfg.db.driver))
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	// stopping the server hangs up the websockets, which it no longer sees once they are upgraded, as well
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go app.hub.run(ctx)
+	app.events.Subscribe(app.pushToAdmins)
+	app.events.Subscribe(app.orderStreams.notify)
 	go app.ListenToWsChannel()
 
-	err = app.serve()
+	err = app.serve(ctx)
 	if err != nil {
 		app.errorLog.Println(err)
 		log.Fatal(err)
 	}
+	<-app.hub.done
 
 }
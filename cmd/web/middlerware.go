@@ -1,6 +1,14 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
+)
 
 // SessionLoad keep track of sessions
 func SessionLoad(next http.Handler) http.Handler {
@@ -14,6 +22,100 @@ func (app *application) Auth(next http.Handler) http.Handler {
 			return
 		}
 
+		// when everyone must use two factor authentication, a user without it can only go to where they set it up
+		if r.URL.Path != "/admin/two-factor" {
+			required, err := app.DB.TwoFactorSetupRequired(app.Session.GetInt(r.Context(), "userID"))
+			if err != nil {
+				app.errorLog.Println(err)
+			}
+			if required {
+				http.Redirect(w, r, "/admin/two-factor", http.StatusTemporaryRedirect)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit turns away with a 429 the requests of a client over rate. Clients are told apart by the user signed in to
+// the session, and by ip address when there is none; name keeps the buckets of different limits apart
+func (app *application) RateLimit(name string, rate ratelimit.Rate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if app.limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := fmt.Sprintf("web:%s:ip:%s", name, ratelimit.ClientIP(r))
+			if userID := app.Session.GetInt(r.Context(), "userID"); userID > 0 {
+				key = fmt.Sprintf("web:%s:user:%d", name, userID)
+			}
+
+			wait, err := app.limiter.Take(key, rate)
+			if err != nil {
+				// let the request through rather than have the site go down with the store
+				app.errorLog.Println(err)
+			}
+
+			if wait > 0 {
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, fmt.Sprintf("Too many requests, try again in %d seconds", seconds), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRF turns away requests that could change something unless they carry the session's CSRF token, in the csrf_token
+// form field or the X-CSRF-Token header, bound to its sign in
+func (app *application) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrf.HeaderName)
+		if token == "" {
+			token = r.PostFormValue(csrf.FieldName)
+		}
+
+		expected := app.Session.GetString(r.Context(), "csrfToken")
+		if expected == "" || !csrf.Equal(token, expected) || app.csrf.Verify(token, app.csrfBinding(r)) != nil {
+			app.errorLog.Printf("missing or wrong CSRF token for %s %s from %s", r.Method, r.URL.Path, ratelimit.ClientIP(r))
+			http.Error(w, "This form has expired, please go back, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// csrfToken returns the session's CSRF token, issuing it a new one when it has none, it has expired or it is bound
+// to another sign in
+func (app *application) csrfToken(r *http.Request) string {
+	binding := app.csrfBinding(r)
+	token := app.Session.GetString(r.Context(), "csrfToken")
+	if token != "" && app.csrf.Verify(token, binding) == nil {
+		return token
+	}
+
+	token, err := app.csrf.Generate(binding)
+	if err != nil {
+		app.errorLog.Println(err)
+		return ""
+	}
+	app.Session.Put(r.Context(), "csrfToken", token)
+	return token
+}
+
+// csrfBinding returns what the session's CSRF token is bound to, the family of the api tokens of its sign in, so the
+// api can tell a token of this session from one of another
+func (app *application) csrfBinding(r *http.Request) string {
+	return app.Session.GetString(r.Context(), "tokenFamily")
+}
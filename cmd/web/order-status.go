@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+)
+
+const (
+	// orderLinkTTL is how long the signed link to an order's status page keeps working
+	orderLinkTTL = 90 * 24 * time.Hour
+
+	// orderStreamPath is where the status page of an order follows it; the signed link of the page comes along in
+	// the query
+	orderStreamPath = "/order-status/stream"
+
+	// maxOrderStreams is how many pages may follow one order at once
+	maxOrderStreams = 5
+
+	// streamHeartbeat is how often a quiet stream is written to, so proxies do not hang up on it
+	streamHeartbeat = 15 * time.Second
+)
+
+// orderStreams keeps track of the pages following the status of each order, and lets them know when it changes
+type orderStreams struct {
+	mu      sync.Mutex
+	streams map[int]map[chan struct{}]bool
+}
+
+// newOrderStreams returns an orderStreams with no pages following any order
+func newOrderStreams() *orderStreams {
+	return &orderStreams{
+		streams: make(map[int]map[chan struct{}]bool),
+	}
+}
+
+// add returns the channel a page following orderID is told on that the order changed, or false when as many pages
+// as allowed already follow it
+func (s *orderStreams) add(orderID int) (chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.streams[orderID]) >= maxOrderStreams {
+		return nil, false
+	}
+
+	if s.streams[orderID] == nil {
+		s.streams[orderID] = make(map[chan struct{}]bool)
+	}
+
+	// a page that has not caught up with one change only needs to hear about the next once
+	ch := make(chan struct{}, 1)
+	s.streams[orderID][ch] = true
+	return ch, true
+}
+
+// remove stops telling ch about orderID
+func (s *orderStreams) remove(orderID int, ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.streams[orderID], ch)
+	if len(s.streams[orderID]) == 0 {
+		delete(s.streams, orderID)
+	}
+}
+
+// notify lets the pages following the order an event is about know that it changed
+func (s *orderStreams) notify(e events.Event) {
+	switch e.Type {
+	case events.OrderCreated, events.PaymentFailed, events.RefundIssued, events.SubscriptionCancelled,
+		events.InvoiceSent, events.FulfilmentUpdated:
+	default:
+		return
+	}
+
+	var data struct {
+		OrderID int `json:"order_id"`
+	}
+	err := json.Unmarshal(e.Data, &data)
+	if err != nil || data.OrderID == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.streams[data.OrderID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// orderStatusURL returns the signed link to the status page of the order with orderID
+func (app *application) orderStatusURL(orderID int) string {
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	return signer.GenerateTokenFromString(fmt.Sprintf("%s/order-status?order=%d", app.config.frontend, orderID))
+}
+
+// verifyOrderLink returns the order the signed order status link in the query of r is for, and whether the link is
+// ours and has not expired. The stream is opened with the query of the page, so it is checked as the page's link
+func (app *application) verifyOrderLink(r *http.Request) (int, bool) {
+	link := fmt.Sprintf("%s/order-status?%s", app.config.frontend, r.URL.RawQuery)
+
+	signer := urlsigner.Signer{
+		Secret: []byte(app.config.secretkey),
+	}
+	if !signer.VerifyToken(link) || signer.Expired(link, int(orderLinkTTL.Minutes())) {
+		return 0, false
+	}
+
+	orderID, err := strconv.Atoi(r.URL.Query().Get("order"))
+	if err != nil {
+		return 0, false
+	}
+
+	return orderID, true
+}
+
+// OrderStatus shows a customer where their order stands, and keeps it up to date as it changes. Customers have no
+// account, so the signed link they were given with their receipt is what lets them see it
+func (app *application) OrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := app.verifyOrderLink(r)
+	if !ok {
+		http.Error(w, "This link is not valid, or has expired", http.StatusForbidden)
+		return
+	}
+
+	status, err := app.DB.GetOrderStatus(orderID)
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["stream"] = fmt.Sprintf("%s?%s", orderStreamPath, r.URL.RawQuery)
+
+	data := make(map[string]interface{})
+	data["status"] = status
+
+	if err := app.renderTemplate(w, r, "order-status", &templateData{
+		StringMap: stringMap,
+		Data:      data,
+	}); err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// OrderStatusStream sends the status page of an order, as server-sent events, where the order stands, and again
+// every time it changes, until the page goes away
+func (app *application) OrderStatusStream(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := app.verifyOrderLink(r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	changed, ok := app.orderStreams.add(orderID)
+	if !ok {
+		http.Error(w, "This order is open in too many pages", http.StatusTooManyRequests)
+		return
+	}
+	defer app.orderStreams.remove(orderID, changed)
+
+	// the stream lasts longer than the server lets a response take
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.errorLog.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func() error {
+		status, err := app.DB.GetOrderStatus(orderID)
+		if err != nil {
+			return err
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", out)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// have the browser wait a little before it opens the stream again when it drops
+	_, err = fmt.Fprint(w, "retry: 5000\n\n")
+	if err == nil {
+		err = send()
+	}
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-changed:
+			err = send()
+			if err != nil {
+				app.errorLog.Println(err)
+				return
+			}
+
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/secureheaders"
 )
 
 // templateData holds everything that being passed to a template
@@ -15,6 +17,7 @@ type templateData struct {
 	FloatMap             map[string]float32
 	Data                 map[string]interface{}
 	CSRFToken            string
+	CSPNonce             string
 	Flash                string
 	Warning              string
 	Error                string
@@ -44,6 +47,8 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	td.API = app.config.api
 	td.StripePublishableKey = app.config.stripe.key
 	td.StripeSecretKey = app.config.stripe.secret
+	td.CSRFToken = app.csrfToken(r)
+	td.CSPNonce = secureheaders.Nonce(r)
 
 	if app.Session.Exists(r.Context(), "userID") {
 		td.IsAuthenticated = 1
@@ -1,44 +1,76 @@
 package main
 
 import (
+	"github.com/ahmedkhaeld/ecommerce/internal/ratelimit"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
-	mux.Use(SessionLoad)
+	mux.Use(app.headers.Handler)
 
-	mux.Get("/", app.Home)
-	mux.Get("/ws", app.WsEndPoint)
+	// the session holds back what a handler writes until it returns, which a stream never does. The signed link it
+	// is opened with stands in for a session, and how many can follow an order is limited
+	mux.Get(orderStreamPath, app.OrderStatusStream)
 
-	mux.Route("/admin", func(mux chi.Router) {
-		mux.Use(app.Auth)
-		mux.Get("/virtual-terminal", app.VirtualTerminal)
-		mux.Get("/all-sales", app.AllSales)
-		mux.Get("/all-subscriptions", app.AllSubscriptions)
-		mux.Get("/sales/{id}", app.ShowSale)
-		mux.Get("/subscriptions/{id}", app.ShowSubscription)
-		mux.Get("/all-users", app.AllUsers)
-		mux.Get("/all-users/{id}", app.OneUser)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(SessionLoad)
+		mux.Use(app.RateLimit("web", ratelimit.PerMinute(app.config.limiter.rpm)))
 
-	})
+		// browsers post reports of what the CSP blocked without a CSRF token
+		mux.Post(cspReportPath, app.CSPReport)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.CSRF)
+
+			mux.Get("/", app.Home)
+			mux.Get("/ws", app.WsEndPoint)
+
+			mux.Route("/admin", func(mux chi.Router) {
+				mux.Use(app.Auth)
+				mux.Get("/virtual-terminal", app.VirtualTerminal)
+				mux.Get("/all-sales", app.AllSales)
+				mux.Get("/all-subscriptions", app.AllSubscriptions)
+				mux.Get("/sales/{id}", app.ShowSale)
+				mux.Get("/subscriptions/{id}", app.ShowSubscription)
+				mux.Get("/analytics", app.Analytics)
+				mux.Get("/export", app.Export)
+				mux.Get("/customers", app.AllCustomers)
+				mux.Get("/customers/{id}", app.OneCustomer)
+				mux.Get("/all-users", app.AllUsers)
+				mux.Get("/all-users/{id}", app.OneUser)
+				mux.Get("/two-factor", app.TwoFactor)
+				mux.Get("/tokens", app.Tokens)
+				mux.Get("/webhooks", app.Webhooks)
+				mux.Get("/webhooks/{id}", app.ShowWebhook)
 
-	mux.Post("/payment-succeeded", app.PaymentSucceeded)
-	mux.Get("/receipt", app.Receipt)
-	mux.Get("/widget/{id}", app.ChargeOnce)
+			})
 
-	mux.Get("/plans/bronze", app.BronzePlan)
-	mux.Get("/receipt/bronze", app.BronzePlanReceipt)
+			mux.With(app.RateLimit("payment-succeeded", ratelimit.PerMinute(app.config.limiter.paymentRPM))).Post("/payment-succeeded", app.PaymentSucceeded)
+			mux.Get("/receipt", app.Receipt)
+			mux.Get("/order-status", app.OrderStatus)
+			mux.Get("/widget/{id}", app.ChargeOnce)
 
-	// auth routes
-	mux.Get("/login", app.LoginPage)
-	mux.Post("/login", app.PostLoginPage)
-	mux.Get("/logout", app.Logout)
-	mux.Get("/forgot-password", app.ForgotPassword)
-	mux.Get("/reset-password", app.ShowResetPassword)
+			mux.Get("/plans/bronze", app.BronzePlan)
+			mux.Get("/receipt/bronze", app.BronzePlanReceipt)
+
+			// auth routes
+			mux.Get("/login", app.LoginPage)
+			mux.With(app.RateLimit("login", ratelimit.PerMinute(app.config.limiter.authRPM))).Post("/login", app.PostLoginPage)
+			mux.Get("/logout", app.Logout)
+			mux.Get("/login/sso", app.SSOLogin)
+			mux.Get("/auth/oidc/callback", app.SSOCallback)
+			mux.Get("/forgot-password", app.ForgotPassword)
+			mux.Get("/reset-password", app.ShowResetPassword)
+
+			mux.Get("/privacy", app.PrivacyRequest)
+			mux.Get("/privacy/confirm", app.PrivacyConfirm)
+
+			fileServer := http.FileServer(http.Dir("./static"))
+			mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+		})
+	})
 
-	fileServer := http.FileServer(http.Dir("./static"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	return mux
 }
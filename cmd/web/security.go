@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/secureheaders"
+)
+
+// cspReportPath is where browsers send reports of what the Content Security Policy blocked, or would have
+const cspReportPath = "/csp-report"
+
+// securityHeaders returns the headers the front end sends in the environment it runs in. Unless mode says otherwise,
+// the CSP only reports what it would block in development, so a page that breaks it keeps working while it is fixed,
+// and browsers are only told to stick to https in production
+func securityHeaders(cfg config) (secureheaders.Policy, error) {
+	p := secureheaders.Policy{
+		CSP: []secureheaders.Directive{
+			{Name: "default-src", Sources: []string{"'self'"}},
+			// bootstrap's script comes from the CDN, pinned and with its integrity hash, and is let in by its nonce; the
+			// CDN is not trusted with scripts, as anyone can publish to it
+			{Name: "script-src", Sources: []string{"'self'", secureheaders.NonceSource, "https://js.stripe.com"}},
+			// stripe.js styles the elements it adds inline
+			{Name: "style-src", Sources: []string{"'self'", "'unsafe-inline'", "https://cdn.jsdelivr.net"}},
+			{Name: "img-src", Sources: []string{"'self'", "data:", "https://*.stripe.com"}},
+			{Name: "connect-src", Sources: []string{"'self'", cfg.api, websocketOrigin(cfg.frontend), "https://api.stripe.com"}},
+			{Name: "frame-src", Sources: []string{"https://js.stripe.com", "https://hooks.stripe.com"}},
+			{Name: "frame-ancestors", Sources: []string{"'none'"}},
+			{Name: "form-action", Sources: []string{"'self'"}},
+			{Name: "base-uri", Sources: []string{"'self'"}},
+			{Name: "object-src", Sources: []string{"'none'"}},
+		},
+		ReportURI:         cspReportPath,
+		FrameOptions:      "DENY",
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=()",
+	}
+
+	mode := cfg.csp
+	if mode == "" {
+		mode = "enforce"
+		if cfg.env == "development" {
+			mode = "report-only"
+		}
+	}
+
+	switch mode {
+	case "enforce":
+	case "report-only":
+		p.ReportOnly = true
+	case "off":
+		p.CSP = nil
+	default:
+		return p, fmt.Errorf("unknown csp mode %q", mode)
+	}
+
+	if cfg.env == "production" {
+		p.HSTS = 365 * 24 * time.Hour
+	}
+
+	return p, nil
+}
+
+// websocketOrigin returns the origin websockets to the front end at url connect to
+func websocketOrigin(url string) string {
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
+
+// CSPReport logs the violations of the Content Security Policy that browsers report
+func (app *application) CSPReport(w http.ResponseWriter, r *http.Request) {
+	reports, err := secureheaders.ReadReports(w, r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	for _, rep := range reports {
+		app.infoLog.Printf("csp %s: %s blocked %q on %s (%s:%d) %s",
+			rep.Disposition, rep.ViolatedDirective, rep.BlockedURI, rep.DocumentURI, rep.SourceFile, rep.LineNumber, rep.Sample)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// singleSignOn signs admins in through an OpenID Connect identity provider
+type singleSignOn struct {
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	roles       map[string][]string // the token scopes that membership of each group of the identity provider gives
+	groupsClaim string
+	createUsers bool
+}
+
+// setupSingleSignOn discovers the identity provider at cfg.oidc.issuer
+func setupSingleSignOn(cfg config) (*singleSignOn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, cfg.oidc.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := parseRoles(cfg.oidc.roles)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURL := cfg.oidc.redirectURL
+	if redirectURL == "" {
+		redirectURL = cfg.frontend + "/auth/oidc/callback"
+	}
+
+	return &singleSignOn{
+		oauth: oauth2.Config{
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile", cfg.oidc.groupsClaim},
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: cfg.oidc.clientID}),
+		roles:       roles,
+		groupsClaim: cfg.oidc.groupsClaim,
+		createUsers: cfg.oidc.createUsers,
+	}, nil
+}
+
+// parseRoles parses the scopes each group gives, written like "admins=authentication,support=orders:read
+// customers:read"
+func parseRoles(s string) (map[string][]string, error) {
+	roles := make(map[string][]string)
+
+	for _, role := range strings.Split(s, ",") {
+		if strings.TrimSpace(role) == "" {
+			continue
+		}
+
+		group, scopes, ok := strings.Cut(role, "=")
+		if !ok {
+			return nil, fmt.Errorf("role %q is not like group=scope", role)
+		}
+
+		for _, scope := range strings.Fields(scopes) {
+			if scope != models.ScopeAuthentication && !models.IsAPIKeyScope(scope) {
+				return nil, fmt.Errorf("role %q has unknown scope %q", role, scope)
+			}
+			roles[strings.TrimSpace(group)] = append(roles[strings.TrimSpace(group)], scope)
+		}
+	}
+
+	return roles, nil
+}
+
+// scopesFor returns the scopes the groups give together
+func (s *singleSignOn) scopesFor(groups []string) []string {
+	var scopes []string
+	seen := make(map[string]bool)
+
+	for _, g := range groups {
+		for _, scope := range s.roles[g] {
+			if scope == models.ScopeAuthentication {
+				return []string{models.ScopeAuthentication}
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes
+}
+
+// randomString returns n random bytes, hex encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SSOLogin sends the browser to the identity provider to sign in, with the authorization code flow and PKCE
+func (app *application) SSOLogin(w http.ResponseWriter, r *http.Request) {
+	if app.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, err := randomString(16)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	app.Session.Put(r.Context(), "oidcState", state)
+	app.Session.Put(r.Context(), "oidcNonce", nonce)
+	app.Session.Put(r.Context(), "oidcVerifier", verifier)
+
+	url := app.sso.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// SSOCallback is where the identity provider sends the browser back to. The user it signed in gets a session, and
+// api tokens with the scopes their groups give
+func (app *application) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if app.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := app.Session.PopString(r.Context(), "oidcState")
+	nonce := app.Session.PopString(r.Context(), "oidcNonce")
+	verifier := app.Session.PopString(r.Context(), "oidcVerifier")
+
+	qs := r.URL.Query()
+	if qs.Get("error") != "" {
+		app.ssoFailed(w, r, fmt.Errorf("identity provider returned %s: %s", qs.Get("error"), qs.Get("error_description")))
+		return
+	}
+	if state == "" || qs.Get("state") != state {
+		app.ssoFailed(w, r, errors.New("state does not match"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	oauthToken, err := app.sso.oauth.Exchange(ctx, qs.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		app.ssoFailed(w, r, errors.New("no id token returned"))
+		return
+	}
+
+	idToken, err := app.sso.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+	if idToken.Nonce != nonce {
+		app.ssoFailed(w, r, errors.New("nonce does not match"))
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+		Name          string `json:"name"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+	}
+	var allClaims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+	if err := idToken.Claims(&allClaims); err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+		app.ssoFailed(w, r, errors.New("no verified email"))
+		return
+	}
+
+	scopes := app.sso.scopesFor(groupsClaim(allClaims[app.sso.groupsClaim]))
+	if len(scopes) == 0 {
+		app.infoLog.Printf("single sign on refused for %s: no group gives access", claims.Email)
+		app.Session.Put(r.Context(), "error", "Your account is not allowed to sign in here")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	identity := models.OIDCIdentity{
+		Issuer:    idToken.Issuer,
+		Subject:   idToken.Subject,
+		Email:     claims.Email,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+	}
+	if identity.FirstName == "" && identity.LastName == "" {
+		identity.FirstName, identity.LastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	user, err := app.DB.GetUserForOIDC(identity, app.sso.createUsers)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.infoLog.Printf("single sign on refused for %s: no such user", claims.Email)
+		app.Session.Put(r.Context(), "error", "Your account is not allowed to sign in here")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+
+	family, err := models.NewTokenFamily()
+	if err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.issueTokens(r, user, family, scopes)
+	if err != nil {
+		app.ssoFailed(w, r, err)
+		return
+	}
+
+	app.Session.RenewToken(r.Context())
+	app.Session.Put(r.Context(), "userID", user.ID)
+	app.Session.Put(r.Context(), "tokenFamily", family)
+	app.Session.Remove(r.Context(), "csrfToken")
+
+	// the page hands the tokens to the browser's local storage, like the login page does, so it must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token.PlainText
+	stringMap["token_expiry"] = token.Expiry.Format(time.RFC3339)
+	stringMap["refresh_token"] = refreshToken.PlainText
+
+	if err := app.renderTemplate(w, r, "sso", &templateData{StringMap: stringMap}); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// groupsClaim reads the groups claim, which identity providers send as a list or, for one group, a string
+func groupsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var groups []string
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
+// ssoFailed logs why single sign on failed, and sends the browser back to the login page to say it did
+func (app *application) ssoFailed(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorLog.Println("single sign on:", err)
+	app.Session.Put(r.Context(), "error", "Single sign on failed, please try again")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// issueTokens issues user an api access token with scopes, and a refresh token for more like it, in family
+func (app *application) issueTokens(r *http.Request, user models.User, family string, scopes []string) (*models.Token, *models.RefreshToken, error) {
+	token, err := models.GenerateToken(user.ID, app.config.auth.accessTTL, scopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.Name = "Single sign on from " + r.UserAgent()
+	if utf8.RuneCountInString(token.Name) > 255 {
+		token.Name = string([]rune(token.Name)[:255])
+	}
+	token.Family = family
+
+	err = app.DB.InsertToken(token, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := models.GenerateRefreshToken(user.ID, family, app.config.auth.refreshTTL, scopes...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = app.DB.InsertRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/csrf"
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
+	"github.com/ahmedkhaeld/ecommerce/internal/mockidp"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+)
+
+// testRoles are the scopes the groups of the test users give
+const testRoles = "admins=authentication,support=orders:read customers:read"
+
+// ssoTest is a front end signing in through a mock identity provider, with a database of its own
+type ssoTest struct {
+	app    *application
+	server *httptest.Server
+	client *http.Client
+}
+
+// newSSOTest starts a front end and a mock identity provider signing in user, without asking
+func newSSOTest(t *testing.T, user mockidp.User) *ssoTest {
+	t.Helper()
+
+	conn, err := driver.OpenDB(driver.SQLite, t.TempDir()+"/widgets.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = (&migrate.Migrator{DB: conn, Dialect: driver.SQLite, FS: migrations.FS}).Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discard := log.New(io.Discard, "", 0)
+
+	// the provider's url is only known once it listens
+	var idpHandler http.Handler
+	idpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idpHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(idpServer.Close)
+
+	idp, err := mockidp.New(mockidp.Config{
+		Issuer:   idpServer.URL,
+		ClientID: "widgets",
+		Auto:     true,
+		User:     user,
+	}, discard, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpHandler = idp.Routes()
+
+	session := scs.New()
+	app := &application{
+		infoLog:       discard,
+		errorLog:      discard,
+		templateCache: make(map[string]*template.Template),
+		DB:            models.DBModel{DB: conn, Dialect: driver.SQLite},
+		Session:       session,
+		csrf:          csrf.Tokens{Secret: []byte("test secret"), TTL: time.Hour},
+	}
+
+	mux := chi.NewRouter()
+	mux.Get("/login/sso", app.SSOLogin)
+	mux.Get("/auth/oidc/callback", app.SSOCallback)
+	mux.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, session.PopString(r.Context(), "error"))
+	})
+	server := httptest.NewServer(session.LoadAndSave(mux))
+	t.Cleanup(server.Close)
+
+	app.config.frontend = server.URL
+	app.config.auth.accessTTL = 15 * time.Minute
+	app.config.auth.refreshTTL = time.Hour
+	app.config.oidc.issuer = idpServer.URL
+	app.config.oidc.clientID = "widgets"
+	app.config.oidc.groupsClaim = "groups"
+	app.config.oidc.roles = testRoles
+	app.config.oidc.createUsers = true
+
+	app.sso, err = setupSingleSignOn(app.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ssoTest{
+		app:    app,
+		server: server,
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// get gets u, without following redirects
+func (st *ssoTest) get(t *testing.T, u string) *http.Response {
+	t.Helper()
+
+	resp, err := st.client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// redirect returns where resp sends the browser, with its query changed by edit when it is not nil
+func redirect(t *testing.T, resp *http.Response, edit func(url.Values)) string {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d, want a redirect", resp.StatusCode)
+	}
+	u, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		q := u.Query()
+		edit(q)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// signIn goes through single sign on, changing the authorization request and the callback with the edit functions
+// that are not nil, and returns the response of the callback
+func (st *ssoTest) signIn(t *testing.T, editAuthorize, editCallback func(url.Values)) *http.Response {
+	t.Helper()
+
+	resp := st.get(t, st.server.URL+"/login/sso")
+	resp = st.get(t, redirect(t, resp, editAuthorize))
+	return st.get(t, redirect(t, resp, editCallback))
+}
+
+// refused checks that resp sends the browser back to the login page saying message, and that no token was issued
+func (st *ssoTest) refused(t *testing.T, resp *http.Response, message string) {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Fatalf("got status %d to %q, want a redirect to /login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	login, err := st.client.Get(st.server.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer login.Body.Close()
+	body, _ := io.ReadAll(login.Body)
+	if string(body) != message {
+		t.Errorf("got error %q, want %q", body, message)
+	}
+
+	if scopes := st.tokenScopes(t); len(scopes) != 0 {
+		t.Errorf("got tokens with scopes %q, want none", scopes)
+	}
+}
+
+// tokenScopes returns the scopes of the tokens issued
+func (st *ssoTest) tokenScopes(t *testing.T) []string {
+	t.Helper()
+
+	rows, err := st.app.DB.DB.Query("select scopes from tokens order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var scopes []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		scopes = append(scopes, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return scopes
+}
+
+func TestSSOCallbackScopesFromGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups string
+		want   string
+	}{
+		{"admin", "admins", "authentication"},
+		{"support", "support,sales", "orders:read customers:read"},
+		{"admin and support", "support,admins", "authentication"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSSOTest(t, mockidp.User{
+				Email:         "jane@example.com",
+				Name:          "Jane Doe",
+				Groups:        tt.groups,
+				EmailVerified: true,
+			})
+
+			resp := st.signIn(t, nil, nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d to %q, want the signing in page", resp.StatusCode, resp.Header.Get("Location"))
+			}
+
+			scopes := st.tokenScopes(t)
+			if len(scopes) != 1 || scopes[0] != tt.want {
+				t.Errorf("got tokens with scopes %q, want one with %q", scopes, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSOCallbackNoGroupGivesAccess(t *testing.T) {
+	st := newSSOTest(t, mockidp.User{Email: "jane@example.com", Groups: "sales", EmailVerified: true})
+
+	st.refused(t, st.signIn(t, nil, nil), "Your account is not allowed to sign in here")
+}
+
+func TestSSOCallbackStateMismatch(t *testing.T) {
+	st := newSSOTest(t, mockidp.User{Email: "jane@example.com", Groups: "admins", EmailVerified: true})
+
+	resp := st.signIn(t, nil, func(q url.Values) {
+		q.Set("state", strings.Repeat("0", 32))
+	})
+	st.refused(t, resp, "Single sign on failed, please try again")
+}
+
+func TestSSOCallbackNonceMismatch(t *testing.T) {
+	st := newSSOTest(t, mockidp.User{Email: "jane@example.com", Groups: "admins", EmailVerified: true})
+
+	resp := st.signIn(t, func(q url.Values) {
+		q.Set("nonce", strings.Repeat("0", 32))
+	}, nil)
+	st.refused(t, resp, "Single sign on failed, please try again")
+}
+
+func TestSSOCallbackEmailNotVerified(t *testing.T) {
+	st := newSSOTest(t, mockidp.User{Email: "jane@example.com", Groups: "admins", EmailVerified: false})
+
+	st.refused(t, st.signIn(t, nil, nil), "Single sign on failed, please try again")
+}
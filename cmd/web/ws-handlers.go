@@ -1,119 +1,85 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"net/http"
 )
 
-// WebSocketConnection to communicate between the front end, need to send information off to the connected client
-type WebSocketConnection struct {
-	*websocket.Conn
-}
-
 // WsPayload defines the data that we are receiving from the client
 type WsPayload struct {
-	Action      string              `json:"action"`
-	Message     string              `json:"message"`
-	UserName    string              `json:"username"`
-	MessageType string              `json:"message_type"`
-	UserID      int                 `json:"user_id"`
-	Conn        WebSocketConnection `json:"-"`
+	Action      string   `json:"action"`
+	Message     string   `json:"message"`
+	UserName    string   `json:"username"`
+	MessageType string   `json:"message_type"`
+	UserID      int      `json:"user_id"`
+	Topics      []string `json:"topics"` // what to subscribe to, or unsubscribe from
+	SenderID    int      `json:"-"`      // the signed in user whose connection sent it
 }
 
 // WsJsonResponse what to send to the end-user
 type WsJsonResponse struct {
-	Action  string `json:"action"`
-	Message string `json:"message"`
-	UserID  int    `json:"user_id"`
+	Action  string          `json:"action"`
+	Message string          `json:"message"`
+	UserID  int             `json:"user_id"`
+	Topic   string          `json:"topic,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
 }
 
+// upgradeConnection leaves CheckOrigin unset, so only pages served from our own host can connect
 var upgradeConnection = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-//clients to keep track of every client who is connected
-var clients = make(map[WebSocketConnection]string)
-
-//wsChan push to the channel any time receiving information
-var wsChan = make(chan WsPayload)
-
+// WsEndPoint connects a signed in user's page to the hub
 func (app *application) WsEndPoint(w http.ResponseWriter, r *http.Request) {
-	// 1. upgrade the connection, when a request comes from the front-end
-	ws, err := upgradeConnection.Upgrade(w, r, nil)
-	if err != nil {
-		app.errorLog.Println(err)
+	userID := app.Session.GetInt(r.Context(), "userID")
+	if userID == 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
 
-	app.infoLog.Println(fmt.Sprintf("Client connected from %s", r.RemoteAddr))
-	var response WsJsonResponse
-	response.Message = "Connected to server"
-
-	// write json using gorilla websocket to write exactly the format that websocket requires
-	err = ws.WriteJSON(response)
+	// 1. upgrade the connection, when a request comes from the front-end
+	ws, err := upgradeConnection.Upgrade(w, r, nil)
 	if err != nil {
 		app.errorLog.Println(err)
 		return
 	}
 
-	// get the connection after connected
-	conn := WebSocketConnection{Conn: ws}
-
-	// put the connection in the clients map
-	clients[conn] = ""
-
-	// run go routine in the background to listen to the websocket connection
-	go app.ListenForWS(&conn)
-}
+	app.infoLog.Println(fmt.Sprintf("Client connected from %s as user %d", r.RemoteAddr, userID))
 
-func (app *application) ListenForWS(conn *WebSocketConnection) {
-	defer func() {
-		if r := recover(); r != nil {
-			app.errorLog.Println("Error:", fmt.Sprintf("%v", r))
-		}
-	}()
-
-	var payload WsPayload
+	client := &wsClient{
+		hub:    app.hub,
+		conn:   ws,
+		userID: userID,
+		send:   make(chan WsJsonResponse, sendQueueSize),
+		topics: make(map[string]bool),
+	}
+	client.send <- WsJsonResponse{Message: "Connected to server"}
 
-	for {
-		err := conn.ReadJSON(&payload)
-		if err != nil {
-			// do nothing
-		} else {
-			payload.Conn = *conn
-			wsChan <- payload
-		}
+	if !app.hub.add(client) {
+		_ = ws.Close()
+		return
 	}
+
+	go client.writePump()
+	go client.readPump()
 }
 
+// ListenToWsChannel acts on what clients send the hub, until it stops. Pages only subscribe to topics, which the hub
+// sees to, so anything else is logged and dropped
 func (app *application) ListenToWsChannel() {
-	var response WsJsonResponse
-
 	for {
-		e := <-wsChan
-		switch e.Action {
-		case "deleteUser":
-			response.Action = "logout"
-			response.Message = "Your account has been deleted"
-			response.UserID = e.UserID
-			app.broadcastToAll(response)
-		default:
-
-		}
-	}
-}
-
-func (app *application) broadcastToAll(response WsJsonResponse) {
-	for client := range clients {
-		// broadcast to every connected client
-		err := client.WriteJSON(response)
-		if err != nil {
-			app.errorLog.Printf("Websocket err on %s: %s", response.Action, err)
-			_ = client.Close()
-			delete(clients, client)
+		select {
+		case e := <-app.hub.inbound:
+			switch e.Action {
+			default:
+				app.infoLog.Printf("ignoring websocket action %q from user %d", e.Action, e.SenderID)
+			}
+		case <-app.hub.done:
+			return
 		}
 	}
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a write to a client may take
+	writeWait = 10 * time.Second
+
+	// pongWait is how long a client may go without answering a ping before it is dropped
+	pongWait = 60 * time.Second
+
+	// pingPeriod is how often clients are pinged; it has to be shorter than pongWait
+	pingPeriod = pongWait * 9 / 10
+
+	// maxMessageSize is the largest message read from a client
+	maxMessageSize = 4096
+
+	// sendQueueSize is how many messages may wait to be written to a client; one that falls further behind is dropped
+	sendQueueSize = 256
+)
+
+// wsClient is one websocket connection, of a signed in user. Its messages are written by its own goroutine, from the
+// send queue, so a slow connection never holds up the hub or the others
+type wsClient struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID int
+	send   chan WsJsonResponse
+	topics map[string]bool // what the client subscribed to; it belongs to the hub's goroutine
+}
+
+// wsMessage is a response on its way to the clients of userID, to the clients subscribed to topic, or to every
+// client when it has neither
+type wsMessage struct {
+	userID   int
+	topic    string
+	response WsJsonResponse
+}
+
+// wsSubscription subscribes a client to topics, or unsubscribes it
+type wsSubscription struct {
+	client    *wsClient
+	topics    []string
+	subscribe bool
+}
+
+// Hub keeps track of the websocket clients, by user, and sends them messages. All of its state belongs to the
+// goroutine of run; everything else talks to it over its channels, and gives up once done is closed
+type Hub struct {
+	done       chan struct{} // closed when run returns
+	register   chan *wsClient
+	unregister chan *wsClient
+	subscribe  chan wsSubscription
+	outbound   chan wsMessage
+	inbound    chan WsPayload // messages clients sent us
+	clients    map[*wsClient]bool
+	users      map[int]map[*wsClient]bool
+}
+
+// newHub returns a hub; it does nothing until run is started
+func newHub() *Hub {
+	return &Hub{
+		done:       make(chan struct{}),
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
+		subscribe:  make(chan wsSubscription),
+		outbound:   make(chan wsMessage, 256),
+		inbound:    make(chan WsPayload, 256),
+		clients:    make(map[*wsClient]bool),
+		users:      make(map[int]map[*wsClient]bool),
+	}
+}
+
+// run registers and unregisters clients and hands them their messages, until ctx is done; then it hangs up on every
+// client
+func (h *Hub) run(ctx context.Context) {
+	defer close(h.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			for c := range h.clients {
+				h.remove(c)
+			}
+			return
+
+		case c := <-h.register:
+			h.clients[c] = true
+			if h.users[c.userID] == nil {
+				h.users[c.userID] = make(map[*wsClient]bool)
+			}
+			h.users[c.userID][c] = true
+
+		case c := <-h.unregister:
+			h.remove(c)
+
+		case s := <-h.subscribe:
+			for _, topic := range s.topics {
+				if s.subscribe {
+					s.client.topics[topic] = true
+				} else {
+					delete(s.client.topics, topic)
+				}
+			}
+
+		case m := <-h.outbound:
+			targets := h.clients
+			if m.userID != 0 {
+				targets = h.users[m.userID]
+			}
+			for c := range targets {
+				if m.topic != "" && !c.topics[m.topic] {
+					continue
+				}
+				select {
+				case c.send <- m.response:
+				default:
+					// its queue is full; it is too far behind to catch up
+					h.remove(c)
+				}
+			}
+		}
+	}
+}
+
+// remove forgets a client and closes its send queue, which has its write goroutine say goodbye and hang up
+func (h *Hub) remove(c *wsClient) {
+	if !h.clients[c] {
+		return
+	}
+
+	delete(h.clients, c)
+	delete(h.users[c.userID], c)
+	if len(h.users[c.userID]) == 0 {
+		delete(h.users, c.userID)
+	}
+	close(c.send)
+}
+
+// Broadcast sends response to every connected client
+func (h *Hub) Broadcast(response WsJsonResponse) {
+	h.send(wsMessage{response: response})
+}
+
+// SendToUser sends response to every connection of the user with userID
+func (h *Hub) SendToUser(userID int, response WsJsonResponse) {
+	h.send(wsMessage{userID: userID, response: response})
+}
+
+// Publish sends response to every client subscribed to topic
+func (h *Hub) Publish(topic string, response WsJsonResponse) {
+	h.send(wsMessage{topic: topic, response: response})
+}
+
+// send hands m to the hub, or drops it once the hub has stopped
+func (h *Hub) send(m wsMessage) {
+	select {
+	case h.outbound <- m:
+	case <-h.done:
+	}
+}
+
+// add registers c with the hub, and reports false once the hub has stopped and c will never be sent anything
+func (h *Hub) add(c *wsClient) bool {
+	select {
+	case h.register <- c:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// readPump hands the hub what the client sends, until the connection fails or the client stops answering pings
+func (c *wsClient) readPump() {
+	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+		_ = c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var payload WsPayload
+		err := c.conn.ReadJSON(&payload)
+		if err != nil {
+			return
+		}
+
+		switch payload.Action {
+		case "subscribe", "unsubscribe":
+			select {
+			case c.hub.subscribe <- wsSubscription{
+				client:    c,
+				topics:    payload.Topics,
+				subscribe: payload.Action == "subscribe",
+			}:
+			case <-c.hub.done:
+				return
+			}
+		default:
+			payload.SenderID = c.userID
+			select {
+			case c.hub.inbound <- payload:
+			case <-c.hub.done:
+				return
+			}
+		}
+	}
+}
+
+// writePump writes the client's queued messages, and pings it, until the hub closes its queue or a write fails
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		select {
+		case response, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			err := c.conn.WriteJSON(response)
+			if err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// These tests are meant to be run with -race, as the hub is all about goroutines
+
+// startHub runs a hub until stop is called, or the test is over
+func startHub(t *testing.T) (h *Hub, stop func()) {
+	t.Helper()
+
+	h = newHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.run(ctx)
+
+	stop = func() {
+		cancel()
+		<-h.done
+	}
+	t.Cleanup(stop)
+	return h, stop
+}
+
+// testClient returns a client of userID with no connection; the test reads its send queue itself
+func testClient(h *Hub, userID int) *wsClient {
+	return &wsClient{
+		hub:    h,
+		userID: userID,
+		send:   make(chan WsJsonResponse, sendQueueSize),
+		topics: make(map[string]bool),
+	}
+}
+
+// receive reads c's queue until it has n messages, it is closed or nothing comes for a while, and returns what it
+// read and whether it was closed
+func receive(c *wsClient, n int) ([]WsJsonResponse, bool) {
+	var got []WsJsonResponse
+	for len(got) < n {
+		select {
+		case r, ok := <-c.send:
+			if !ok {
+				return got, true
+			}
+			got = append(got, r)
+		case <-time.After(2 * time.Second):
+			return got, false
+		}
+	}
+	return got, false
+}
+
+// closed reports whether c's queue is closed once what is left in it has been read
+func closed(c *wsClient) bool {
+	for {
+		select {
+		case _, ok := <-c.send:
+			if !ok {
+				return true
+			}
+		case <-time.After(2 * time.Second):
+			return false
+		}
+	}
+}
+
+func TestHubBroadcastsToConcurrentClients(t *testing.T) {
+	h, _ := startHub(t)
+
+	const clients, senders, perSender = 50, 8, 20
+
+	all := make([]*wsClient, clients)
+	var wg sync.WaitGroup
+	for i := range all {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			all[i] = testClient(h, i%5+1)
+			h.register <- all[i]
+		}(i)
+	}
+	wg.Wait()
+
+	// every client reads while the senders send
+	counts := make([]int, clients)
+	var readers sync.WaitGroup
+	for i, c := range all {
+		readers.Add(1)
+		go func(i int, c *wsClient) {
+			defer readers.Done()
+			got, _ := receive(c, senders*perSender)
+			counts[i] = len(got)
+		}(i, c)
+	}
+
+	var senderWG sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		senderWG.Add(1)
+		go func(s int) {
+			defer senderWG.Done()
+			for n := 0; n < perSender; n++ {
+				h.Broadcast(WsJsonResponse{Action: "test", Message: fmt.Sprintf("%d-%d", s, n)})
+			}
+		}(s)
+	}
+	senderWG.Wait()
+	readers.Wait()
+
+	for i, n := range counts {
+		if n != senders*perSender {
+			t.Errorf("client %d got %d messages, want %d", i, n, senders*perSender)
+		}
+	}
+
+	for _, c := range all {
+		wg.Add(1)
+		go func(c *wsClient) {
+			defer wg.Done()
+			h.unregister <- c
+		}(c)
+	}
+	wg.Wait()
+
+	for i, c := range all {
+		if !closed(c) {
+			t.Errorf("client %d was not closed when it unregistered", i)
+		}
+	}
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	h, _ := startHub(t)
+
+	fast := testClient(h, 1)
+	slow := testClient(h, 2)
+	h.register <- fast
+	h.register <- slow
+
+	// the slow client reads nothing, so its queue overflows, while the fast one keeps up with every message
+	const sent = sendQueueSize + 10
+	for n := 0; n < sent; n++ {
+		h.Broadcast(WsJsonResponse{Message: strconv.Itoa(n)})
+		if got, _ := receive(fast, 1); len(got) != 1 {
+			t.Fatalf("fast client did not get message %d", n)
+		}
+	}
+
+	got, wasClosed := receive(slow, sent)
+	if !wasClosed {
+		t.Fatal("slow client was not dropped")
+	}
+	if len(got) != sendQueueSize {
+		t.Errorf("slow client got %d messages before it was dropped, want %d", len(got), sendQueueSize)
+	}
+
+	// its read pump unregisters it when the connection is hung up, after the hub has dropped it
+	h.unregister <- slow
+	h.Broadcast(WsJsonResponse{Message: "after"})
+	if got, _ := receive(fast, 1); len(got) != 1 {
+		t.Error("fast client stopped getting messages once the slow one was dropped")
+	}
+}
+
+func TestHubIgnoresClosedClients(t *testing.T) {
+	h, _ := startHub(t)
+
+	const clients = 40
+
+	all := make([]*wsClient, clients)
+	for i := range all {
+		all[i] = testClient(h, 1)
+		h.register <- all[i]
+	}
+
+	// half the clients unregister, some of them twice, while messages go out to the user
+	var wg sync.WaitGroup
+	for i, c := range all {
+		if i%2 == 1 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *wsClient) {
+			defer wg.Done()
+			h.unregister <- c
+			if i%4 == 0 {
+				h.unregister <- c
+			}
+		}(i, c)
+	}
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			h.SendToUser(1, WsJsonResponse{Message: strconv.Itoa(n)})
+		}(n)
+	}
+	wg.Wait()
+
+	for i, c := range all {
+		if i%2 == 0 {
+			if !closed(c) {
+				t.Errorf("client %d was not closed when it unregistered", i)
+			}
+			continue
+		}
+		if got, wasClosed := receive(c, 20); wasClosed || len(got) != 20 {
+			t.Errorf("client %d got %d messages, closed %v; want 20, open", i, len(got), wasClosed)
+		}
+	}
+}
+
+func TestHubSendsToUsersAndTopics(t *testing.T) {
+	h, _ := startHub(t)
+
+	alice := testClient(h, 1)
+	bob := testClient(h, 2)
+	h.register <- alice
+	h.register <- bob
+	h.subscribe <- wsSubscription{client: bob, topics: []string{"orders"}, subscribe: true}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			h.SendToUser(1, WsJsonResponse{Action: "user"})
+		}()
+		go func() {
+			defer wg.Done()
+			h.Publish("orders", WsJsonResponse{Action: "topic"})
+		}()
+	}
+	wg.Wait()
+
+	got, _ := receive(alice, 20)
+	for _, r := range got {
+		if r.Action != "user" {
+			t.Errorf("alice got a %q message, want only hers", r.Action)
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("alice got %d messages, want 10", len(got))
+	}
+
+	got, _ = receive(bob, 20)
+	for _, r := range got {
+		if r.Action != "topic" {
+			t.Errorf("bob got a %q message, want only the topic's", r.Action)
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("bob got %d messages, want 10", len(got))
+	}
+}
+
+// newWsTest starts a front end with a running hub whose only routes sign in as a user and connect to the hub; stop
+// stops the hub
+func newWsTest(t *testing.T) (app *application, server *httptest.Server, stop func()) {
+	t.Helper()
+
+	discard := log.New(io.Discard, "", 0)
+	session := scs.New()
+	app = &application{
+		infoLog:  discard,
+		errorLog: discard,
+		Session:  session,
+	}
+
+	mux := chi.NewRouter()
+	mux.Get("/sign-in/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		session.Put(r.Context(), "userID", id)
+	})
+	mux.Get("/ws", app.WsEndPoint)
+
+	server = httptest.NewServer(session.LoadAndSave(mux))
+	t.Cleanup(server.Close)
+
+	// the hub stops, and hangs up, before the server is closed
+	app.hub, stop = startHub(t)
+	go app.ListenToWsChannel()
+	return app, server, stop
+}
+
+// dial signs in as userID and connects to the hub, reading the greeting
+func dial(t *testing.T, server *httptest.Server, userID int) *websocket.Conn {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(fmt.Sprintf("%s/sign-in/%d", server.URL, userID))
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	resp.Body.Close()
+
+	dialer := websocket.Dialer{Jar: jar}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	var greeting WsJsonResponse
+	err = conn.ReadJSON(&greeting)
+	if err != nil || greeting.Message != "Connected to server" {
+		t.Errorf("got greeting %+v, %v", greeting, err)
+	}
+	return conn
+}
+
+func TestWsEndPointConcurrentConnections(t *testing.T) {
+	app, server, _ := newWsTest(t)
+
+	const clients = 30
+
+	conns := make([]*websocket.Conn, clients)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i] = dial(t, server, i+1)
+			if conns[i] == nil {
+				return
+			}
+			err := conns[i].WriteJSON(WsPayload{Action: "subscribe", Topics: []string{"orders"}})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	// the hub takes the subscriptions after it has registered the clients, and does not answer them, so publish
+	// until every client has had something on the topic
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				app.hub.Publish("orders", WsJsonResponse{Action: "ready"})
+			}
+		}
+	}()
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *websocket.Conn) {
+			defer wg.Done()
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var r WsJsonResponse
+			for r.Action != "ready" {
+				if err := c.ReadJSON(&r); err != nil {
+					t.Errorf("client %d was not subscribed: %v", i, err)
+					return
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	close(stop)
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	// a third of the clients hang up while the rest keep getting messages
+	for i, c := range conns {
+		if i%3 == 0 {
+			wg.Add(1)
+			go func(c *websocket.Conn) {
+				defer wg.Done()
+				c.Close()
+			}(c)
+		}
+	}
+	for n := 0; n < 5; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			app.hub.Publish("orders", WsJsonResponse{Action: "order", Message: strconv.Itoa(n)})
+		}(n)
+	}
+	wg.Wait()
+
+	for i, c := range conns {
+		if i%3 == 0 {
+			continue
+		}
+		got := 0
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for got < 5 {
+			var r WsJsonResponse
+			if err := c.ReadJSON(&r); err != nil {
+				t.Errorf("client %d: %v after %d messages", i, err, got)
+				break
+			}
+			if r.Action == "order" {
+				got++
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestStoppedHubHangsUp(t *testing.T) {
+	app, server, stop := newWsTest(t)
+
+	conns := make([]*websocket.Conn, 3)
+	for i := range conns {
+		conns[i] = dial(t, server, i+1)
+		if conns[i] == nil {
+			t.FailNow()
+		}
+		defer conns[i].Close()
+	}
+	queued := testClient(app.hub, 99)
+	if !app.hub.add(queued) {
+		t.Fatal("a running hub did not take a client")
+	}
+
+	stop()
+
+	if !closed(queued) {
+		t.Error("the send queue of a client was left open")
+	}
+	for i, c := range conns {
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var r WsJsonResponse
+		err := c.ReadJSON(&r)
+		if !websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
+			t.Errorf("client %d got %v, want the hub to hang up", i, err)
+		}
+	}
+
+	// nothing waits on a hub that has stopped
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*cap(app.hub.outbound); i++ {
+			app.hub.Broadcast(WsJsonResponse{Message: "too late"})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Error("sending to a stopped hub blocked")
+	}
+	if app.hub.add(testClient(app.hub, 100)) {
+		t.Error("a stopped hub took a client")
+	}
+}
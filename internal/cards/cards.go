@@ -7,6 +7,7 @@ import (
 	"github.com/stripe/stripe-go/v72/paymentmethod"
 	"github.com/stripe/stripe-go/v72/refund"
 	"github.com/stripe/stripe-go/v72/sub"
+	"github.com/stripe/stripe-go/v72/webhook"
 )
 
 // Card hold info that is required to talk to stripe
@@ -181,3 +182,9 @@ func (c *Card) CancelSubscription(subID string) error {
 	return nil
 
 }
+
+// ConstructEvent verifies the signature stripe puts on a webhook payload, using the endpoint's signing secret,
+// and parses the payload into an event
+func (c *Card) ConstructEvent(payload []byte, signature, endpointSecret string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, signature, endpointSecret)
+}
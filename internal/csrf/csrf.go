@@ -0,0 +1,104 @@
+// Package csrf issues and checks the tokens that show a request was made from a page the front end served. A token
+// is random, so the front end can tie it to a session, and signed with the secret the front end shares with the api,
+// so the api can check it without the session. It is bound to the sign in of the session, by the family of its api
+// tokens, so a token one visitor fetched does not pass for another who is signed in
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderName is the header that JavaScript sends a token in; FieldName is the form field that forms send it in
+const (
+	HeaderName = "X-CSRF-Token"
+	FieldName  = "csrf_token"
+)
+
+// ErrInvalidToken is returned for a token that was not issued with the secret, or has expired
+var ErrInvalidToken = errors.New("invalid or expired CSRF token")
+
+// Tokens issues tokens that last for TTL, and checks them
+type Tokens struct {
+	Secret []byte
+	TTL    time.Duration
+}
+
+// Generate issues a new token bound to binding, the token family of the session's sign in, or nothing before one
+func (t Tokens) Generate(binding string) (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%s.%s.%d", base64.RawURLEncoding.EncodeToString(b), t.bind(binding), time.Now().Add(t.TTL).Unix())
+	return payload + "." + t.sign(payload), nil
+}
+
+// Verify checks that token was issued with the secret, has not expired and is bound to binding
+func (t Tokens) Verify(token, binding string) error {
+	payload, err := t.verify(token)
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[1]), []byte(t.bind(binding))) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// VerifySignature checks that token was issued with the secret and has not expired, whatever it is bound to. It is
+// for requests that act for no one, which a token of someone else's session gets no further than one of their own
+func (t Tokens) VerifySignature(token string) error {
+	_, err := t.verify(token)
+	return err
+}
+
+// verify checks the signature and expiry of token, and returns its payload
+func (t Tokens) verify(token string) (string, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+	payload, signature := token[:i], token[i+1:]
+
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(t.sign(payload))) != 1 {
+		return "", ErrInvalidToken
+	}
+
+	expiry, err := strconv.ParseInt(payload[strings.LastIndex(payload, ".")+1:], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", ErrInvalidToken
+	}
+
+	return payload, nil
+}
+
+// Equal reports whether two tokens are the same, taking as long whatever they are
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (t Tokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.Secret)
+	mac.Write([]byte("csrf:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// bind returns what a token bound to binding carries of it; the binding itself is kept out of the page
+func (t Tokens) bind(binding string) string {
+	mac := hmac.New(sha256.New, t.Secret)
+	mac.Write([]byte("csrf-binding:" + binding))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,62 @@
+package csrf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokensAreBoundToTheirSignIn(t *testing.T) {
+	tokens := Tokens{Secret: []byte("secret"), TTL: time.Hour}
+
+	token, err := tokens.Generate("family a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tokens.Verify(token, "family a"); err != nil {
+		t.Errorf("a token did not pass for its own sign in: %v", err)
+	}
+	if err := tokens.Verify(token, "family b"); err != ErrInvalidToken {
+		t.Errorf("got %v for a token of another sign in, want %v", err, ErrInvalidToken)
+	}
+	if err := tokens.Verify(token, ""); err != ErrInvalidToken {
+		t.Errorf("got %v for a token of a sign in without one, want %v", err, ErrInvalidToken)
+	}
+	if err := tokens.VerifySignature(token); err != nil {
+		t.Errorf("got %v checking only the signature, want none", err)
+	}
+}
+
+func TestTokensThatDoNotPass(t *testing.T) {
+	tokens := Tokens{Secret: []byte("secret"), TTL: time.Hour}
+
+	token, err := tokens.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := Tokens{Secret: tokens.Secret, TTL: -time.Minute}.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Tokens{Secret: []byte("other secret"), TTL: time.Hour}.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"empty":               "",
+		"expired":             expired,
+		"tampered":            "x" + token,
+		"signed with another": other,
+		"without a signature": token[:len(token)-10],
+		"without a payload":   token[len(token)-10:],
+	}
+	for name, tok := range tests {
+		if err := tokens.Verify(tok, ""); err != ErrInvalidToken {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidToken)
+		}
+		if err := tokens.VerifySignature(tok); err != ErrInvalidToken {
+			t.Errorf("%s: got %v checking only the signature, want %v", name, err, ErrInvalidToken)
+		}
+	}
+}
@@ -3,16 +3,49 @@ package driver
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-func OpenDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
+// Dialect is the kind of database the models run against, named the way the migrations name it
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite3"
+)
+
+// OpenDB opens and pings the database at dsn. Queries keep MySQL's ? placeholders whatever the dialect; on
+// PostgreSQL they are numbered before they are sent
+func OpenDB(dialect Dialect, dsn string) (*sql.DB, error) {
+	var db *sql.DB
+	switch dialect {
+	case MySQL, SQLite:
+		var err error
+		db, err = sql.Open(string(dialect), dsn)
+		if err != nil {
+			return nil, err
+		}
+	case Postgres:
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		db = sql.OpenDB(rebindConnector{connector})
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", dialect)
+	}
+
+	if dialect == SQLite {
+		// writes to a SQLite database are serialised anyway, and one connection keeps them from failing as busy
+		db.SetMaxOpenConns(1)
 	}
 
-	err = db.Ping()
+	err := db.Ping()
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
@@ -20,3 +53,81 @@ func OpenDB(dsn string) (*sql.DB, error) {
 	return db, nil
 
 }
+
+// Name returns the dialect, MySQL when it was not set
+func (d Dialect) Name() Dialect {
+	if d == "" {
+		return MySQL
+	}
+	return d
+}
+
+// ReturningID says whether an insert has to ask for the id it gave a row with returning id, rather than the driver
+// telling it
+func (d Dialect) ReturningID() bool {
+	return d.Name() == Postgres
+}
+
+// InsertIgnore turns insert, an insert into statement, into one that inserts nothing when a row with the same key is
+// already there
+func (d Dialect) InsertIgnore(insert string) string {
+	if d.Name() == MySQL {
+		return "insert ignore" + strings.TrimPrefix(strings.TrimSpace(insert), "insert")
+	}
+	return insert + " on conflict do nothing"
+}
+
+// Upsert turns insert, an insert into statement, into one that updates columns of the row with the same key when there
+// is one. keys are the columns of the key, which MySQL works out for itself
+func (d Dialect) Upsert(insert string, keys []string, columns ...string) string {
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		if d.Name() == MySQL {
+			set[i] = fmt.Sprintf("%s = values(%s)", c, c)
+		} else {
+			set[i] = fmt.Sprintf("%s = excluded.%s", c, c)
+		}
+	}
+
+	if d.Name() == MySQL {
+		return insert + " on duplicate key update " + strings.Join(set, ", ")
+	}
+	return fmt.Sprintf("%s on conflict (%s) do update set %s", insert, strings.Join(keys, ", "), strings.Join(set, ", "))
+}
+
+// ForUpdate returns the clause locking the rows a select reads until the transaction ends. SQLite locks the whole
+// database for a transaction's writes, so has none
+func (d Dialect) ForUpdate() string {
+	if d.Name() == SQLite {
+		return ""
+	}
+	return " for update"
+}
+
+// Greatest returns the expression for the largest of exprs
+func (d Dialect) Greatest(exprs ...string) string {
+	if d.Name() == SQLite {
+		return "max(" + strings.Join(exprs, ", ") + ")"
+	}
+	return "greatest(" + strings.Join(exprs, ", ") + ")"
+}
+
+// Concat returns the expression joining the strings exprs
+func (d Dialect) Concat(exprs ...string) string {
+	if d.Name() == MySQL {
+		return "concat(" + strings.Join(exprs, ", ") + ")"
+	}
+	return strings.Join(exprs, " || ")
+}
+
+// FormatDate returns the expression formatting the date expr with format, written the dialect's way
+func (d Dialect) FormatDate(expr, format string) string {
+	switch d.Name() {
+	case Postgres:
+		return fmt.Sprintf("to_char(%s, '%s')", expr, format)
+	case SQLite:
+		return fmt.Sprintf("strftime('%s', %s)", format, expr)
+	default:
+		return fmt.Sprintf("date_format(%s, '%s')", expr, format)
+	}
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// subscriberQueueSize is how many events may wait for a subscriber; more than that and it misses them
+const subscriberQueueSize = 256
+
+// Bus hands the events published on it to its subscribers
+type Bus interface {
+	Publish(e Event) error
+	Subscribe(handler func(Event))
+}
+
+// NewBus returns the bus called name: local, for a process on its own, or db, to share events between every process
+// using the database
+func NewBus(name string, db *sql.DB, errorLog *log.Logger) (Bus, error) {
+	switch name {
+	case "local":
+		return NewLocal(), nil
+	case "db":
+		return NewDBBus(db, errorLog)
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", name)
+	}
+}
+
+// Local is a bus within one process. Each subscriber is handed events in the order they were published, by a
+// goroutine of its own, so a slow one holds up neither the publisher nor the other subscribers
+type Local struct {
+	mu          sync.RWMutex
+	subscribers []chan Event
+}
+
+// NewLocal returns a bus with no subscribers
+func NewLocal() *Local {
+	return &Local{}
+}
+
+// Publish hands e to every subscriber, skipping any too far behind to take it
+func (b *Local) Publish(e Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe has handler called with every event published from now on
+func (b *Local) Subscribe(handler func(Event)) {
+	ch := make(chan Event, subscriberQueueSize)
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, ch)
+	b.mu.Unlock()
+
+	go func() {
+		for e := range ch {
+			handler(e)
+		}
+	}()
+}
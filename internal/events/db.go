@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// pollInterval is how often a DBBus looks for new events
+	pollInterval = time.Second
+
+	// settleTime is how old an event has to be before it is read. An event can be given its id before one written
+	// just before it is committed; stopping at the first event that has not settled keeps the poll from moving past
+	// one still on its way
+	settleTime = 500 * time.Millisecond
+
+	// eventRetention is how long events are kept, for processes that were down for a while to catch up
+	eventRetention = 24 * time.Hour
+)
+
+// DBBus is a bus shared by every process using the same database. Publish writes an event to the events table, and
+// every process, the publisher too, polls the table for the events written since it last looked
+type DBBus struct {
+	db       *sql.DB
+	errorLog *log.Logger
+	local    *Local // hands the events read to the subscribers in this process
+	lastID   int64
+}
+
+// NewDBBus returns a bus on db that hands its subscribers the events published from now on
+func NewDBBus(db *sql.DB, errorLog *log.Logger) (*DBBus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	b := &DBBus{
+		db:       db,
+		errorLog: errorLog,
+		local:    NewLocal(),
+	}
+
+	err := db.QueryRowContext(ctx, "select coalesce(max(id), 0) from events").Scan(&b.lastID)
+	if err != nil {
+		return nil, err
+	}
+
+	go b.poll()
+	return b, nil
+}
+
+// Publish writes e to the events table
+func (b *DBBus) Publish(e Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into events (event_type, data, published_at) values (?, ?, ?)`
+	_, err := b.db.ExecContext(ctx, stmt, e.Type, string(e.Data), e.At.UnixMilli())
+	return err
+}
+
+// Subscribe has handler called with every event published from now on, in any process
+func (b *DBBus) Subscribe(handler func(Event)) {
+	b.local.Subscribe(handler)
+}
+
+// poll reads the new events every pollInterval, and clears out old ones every hour
+func (b *DBBus) poll() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	lastCleared := time.Now()
+	for range ticker.C {
+		err := b.readEvents()
+		if err != nil {
+			b.errorLog.Println(err)
+		}
+
+		if time.Since(lastCleared) > time.Hour {
+			lastCleared = time.Now()
+			err = b.clearEvents()
+			if err != nil {
+				b.errorLog.Println(err)
+			}
+		}
+	}
+}
+
+// readEvents hands the subscribers the events written since the last one read, up to the first that has not settled
+func (b *DBBus) readEvents() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, event_type, data, published_at from events where id > ? order by id limit 500`
+	rows, err := b.db.QueryContext(ctx, query, b.lastID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	settled := time.Now().Add(-settleTime).UnixMilli()
+
+	for rows.Next() {
+		var id, publishedAt int64
+		var e Event
+		var data string
+		err = rows.Scan(&id, &e.Type, &data, &publishedAt)
+		if err != nil {
+			return err
+		}
+		if publishedAt > settled {
+			break
+		}
+
+		e.Data = json.RawMessage(data)
+		e.At = time.UnixMilli(publishedAt)
+		b.lastID = id
+		_ = b.local.Publish(e)
+	}
+
+	return rows.Err()
+}
+
+// clearEvents deletes the events older than eventRetention
+func (b *DBBus) clearEvents() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := b.db.ExecContext(ctx, "delete from events where published_at < ?", time.Now().Add(-eventRetention).UnixMilli())
+	return err
+}
@@ -0,0 +1,92 @@
+// Package events carries what happens in the shop, like an order being placed or refunded, from where it happens to
+// whatever wants to hear about it, like the admin pages
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The types of event
+const (
+	OrderCreated          = "order.created"
+	PaymentFailed         = "payment.failed"
+	RefundIssued          = "refund.issued"
+	SubscriptionCancelled = "subscription.cancelled"
+	LowInventory          = "inventory.low"
+	UserDeleted           = "user.deleted"
+	InvoiceSent           = "invoice.sent"
+	FulfilmentUpdated     = "order.fulfilment"
+)
+
+// Types lists every type of event
+var Types = []string{OrderCreated, PaymentFailed, RefundIssued, SubscriptionCancelled, LowInventory, UserDeleted,
+	InvoiceSent, FulfilmentUpdated}
+
+// IsType reports whether t is a type of event
+func IsType(t string) bool {
+	for _, typ := range Types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something that happened, with the data of its type
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	At   time.Time       `json:"at"`
+}
+
+// New returns an event of type typ that happened now
+func New(typ string, data interface{}) (Event, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Type: typ,
+		Data: b,
+		At:   time.Now(),
+	}, nil
+}
+
+// Order is the data of the order events: created, refunded, subscription cancelled and invoice sent
+type Order struct {
+	OrderID   int    `json:"order_id"`
+	Product   string `json:"product,omitempty"`
+	Quantity  int    `json:"quantity,omitempty"`
+	Amount    int    `json:"amount"`
+	FirstName string `json:"first_name,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+// Fulfilment is the data of an order moving to another fulfilment status
+type Fulfilment struct {
+	OrderID  int    `json:"order_id"`
+	StatusID int    `json:"status_id"`
+	Status   string `json:"status"`
+}
+
+// Payment is the data of a failed payment; OrderID is left out when the payment is not for an order we know
+type Payment struct {
+	OrderID      int    `json:"order_id,omitempty"`
+	Email        string `json:"email"`
+	Amount       int    `json:"amount"`
+	AttemptCount int    `json:"attempt_count"`
+}
+
+// Inventory is the data of a widget running low
+type Inventory struct {
+	WidgetID int    `json:"widget_id"`
+	Name     string `json:"name"`
+	Level    int    `json:"level"`
+}
+
+// User is the data of a user being deleted
+type User struct {
+	UserID int `json:"user_id"`
+}
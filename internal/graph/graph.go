@@ -0,0 +1,93 @@
+// Package graph answers the GraphQL queries of the admin dashboards, which want orders together with their customers,
+// transactions, widgets and refunds without a round trip for each. What the items of a list refer to is loaded for
+// the whole list at once, rather than once for each item
+package graph
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/graph-gophers/graphql-go"
+)
+
+//go:embed schema.graphql
+var schema string
+
+const (
+	// maxDepth is how deeply a query may nest, so one cannot ask for the orders of the customers of orders forever
+	maxDepth = 8
+
+	// maxPageSize is the most items of a list that can be asked for at once
+	maxPageSize = 100
+)
+
+// contextKey is the type of the keys we store values in a request context under
+type contextKey string
+
+// tokenContextKey and loadersContextKey are where NewContext puts the token of a request and its loaders
+const (
+	tokenContextKey   = contextKey("token")
+	loadersContextKey = contextKey("loaders")
+)
+
+// NewSchema returns the schema of the dashboards, resolved from db
+func NewSchema(db models.DBModel) (*graphql.Schema, error) {
+	return graphql.ParseSchema(schema, &Resolver{DB: db}, graphql.MaxDepth(maxDepth))
+}
+
+// NewContext returns ctx with what a query needs: the token it is made with, whose scopes decide what it may read,
+// and loaders of its own
+func NewContext(ctx context.Context, db models.DBModel, token *models.Token) context.Context {
+	ctx = context.WithValue(ctx, tokenContextKey, token)
+	return context.WithValue(ctx, loadersContextKey, newLoaders(db))
+}
+
+// requireScope returns an error unless the token of the query has scope
+func requireScope(ctx context.Context, scope string) error {
+	token, _ := ctx.Value(tokenContextKey).(*models.Token)
+	if token == nil {
+		return errors.New("not signed in")
+	}
+	if !token.HasScope(scope) {
+		return fmt.Errorf("this token does not have the %s scope", scope)
+	}
+	return nil
+}
+
+// loadersFor returns the loaders of the query
+func loadersFor(ctx context.Context) *loaders {
+	return ctx.Value(loadersContextKey).(*loaders)
+}
+
+// parseID returns the number an id stands for
+func parseID(id graphql.ID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an id", id)
+	}
+	return n, nil
+}
+
+// toID returns the id of n
+func toID(n int) graphql.ID {
+	return graphql.ID(strconv.Itoa(n))
+}
+
+// pageArgs returns the page and page size asked for, defaulted and capped
+func pageArgs(page, pageSize int32) (int, int) {
+	p, size := 1, 20
+	if page > 0 {
+		p = int(page)
+	}
+	if pageSize > 0 {
+		size = int(pageSize)
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	return p, size
+}
@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"sync"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+)
+
+// loader loads values by key in batches. A resolver of a list says up front which keys its items will want; the
+// first item to ask loads them all in one query, and the others find theirs already loaded
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending map[K]bool
+	loaded  map[K]V
+}
+
+// newLoader returns a loader that loads with fetch; fetch leaves out the keys there is nothing for
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{
+		fetch:   fetch,
+		pending: make(map[K]bool),
+		loaded:  make(map[K]V),
+	}
+}
+
+// want queues keys to be loaded with the next batch
+func (l *loader[K, V]) want(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, k := range keys {
+		if _, ok := l.loaded[k]; !ok {
+			l.pending[k] = true
+		}
+	}
+}
+
+// load returns the value for key, loading it, and every key queued, when it is not loaded yet. A key there is
+// nothing for has the zero value. Whatever else fetch returns is kept too
+func (l *loader[K, V]) load(key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := l.loaded[key]; ok {
+		return v, nil
+	}
+
+	l.pending[key] = true
+	keys := make([]K, 0, len(l.pending))
+	for k := range l.pending {
+		keys = append(keys, k)
+	}
+
+	values, err := l.fetch(keys)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	for _, k := range keys {
+		l.loaded[k] = values[k]
+		delete(l.pending, k)
+	}
+	for k, v := range values {
+		l.loaded[k] = v
+	}
+
+	return l.loaded[key], nil
+}
+
+// loaders are the loaders of one request, so nothing loaded outlives it
+type loaders struct {
+	widgets            *loader[int, *models.Widget]
+	customerOrders     *loader[int, []*models.Order]
+	statuses           *loader[int, string]
+	fulfilmentStatuses *loader[int, string]
+}
+
+// newLoaders returns the loaders of a request that reads from db
+func newLoaders(db models.DBModel) *loaders {
+	return &loaders{
+		widgets: newLoader(func(ids []int) (map[int]*models.Widget, error) {
+			widgets, err := db.GetWidgetsByIDs(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			byID := make(map[int]*models.Widget)
+			for _, w := range widgets {
+				byID[w.ID] = w
+			}
+			return byID, nil
+		}),
+
+		customerOrders: newLoader(func(ids []int) (map[int][]*models.Order, error) {
+			orders, err := db.GetOrdersForCustomers(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			byCustomer := make(map[int][]*models.Order)
+			for _, o := range orders {
+				byCustomer[o.CustomerID] = append(byCustomer[o.CustomerID], o)
+			}
+			return byCustomer, nil
+		}),
+
+		// there are only a few statuses, so the first to be asked for loads them all
+		statuses: newLoader(func([]int) (map[int]string, error) {
+			return statusNames(db.GetAllStatuses())
+		}),
+		fulfilmentStatuses: newLoader(func([]int) (map[int]string, error) {
+			return statusNames(db.GetAllFulfilmentStatuses())
+		}),
+	}
+}
+
+// statusNames returns the names of statuses by id
+func statusNames(statuses []models.Status, err error) (map[int]string, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string)
+	for _, s := range statuses {
+		names[s.ID] = s.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,467 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// statusRefunded is the status of an order that was refunded
+const statusRefunded = 2
+
+// Resolver resolves the queries of the schema
+type Resolver struct {
+	DB models.DBModel
+}
+
+// Widgets returns every widget
+func (r *Resolver) Widgets() ([]*widgetResolver, error) {
+	widgets, err := r.DB.GetAllWidgets()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*widgetResolver, len(widgets))
+	for i, w := range widgets {
+		resolvers[i] = &widgetResolver{w}
+	}
+	return resolvers, nil
+}
+
+// Widget returns the widget with id, or nothing when there is none
+func (r *Resolver) Widget(args struct{ ID graphql.ID }) (*widgetResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := r.DB.GetWidget(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &widgetResolver{&w}, nil
+}
+
+// orderListArgs are the arguments of the lists of orders
+type orderListArgs struct {
+	Page     int32
+	PageSize int32
+	Filter   *orderFilterInput
+}
+
+// orderFilterInput is the OrderFilter input
+type orderFilterInput struct {
+	StatusID      *int32
+	Customer      *string
+	WidgetID      *int32
+	MinAmount     *int32
+	MaxAmount     *int32
+	From          *string
+	To            *string
+	LastFour      *string
+	PaymentIntent *string
+	Sort          *string
+	Direction     *string
+}
+
+// filter returns the order filter for the input, with its dates parsed; the to date is inclusive
+func (in *orderFilterInput) filter() (models.OrderFilter, error) {
+	var f models.OrderFilter
+	if in == nil {
+		return f, nil
+	}
+
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	num := func(n *int32) int {
+		if n == nil {
+			return 0
+		}
+		return int(*n)
+	}
+
+	f.StatusID = num(in.StatusID)
+	f.Customer = str(in.Customer)
+	f.WidgetID = num(in.WidgetID)
+	f.MinAmount = num(in.MinAmount)
+	f.MaxAmount = num(in.MaxAmount)
+	f.LastFour = str(in.LastFour)
+	f.PaymentIntent = str(in.PaymentIntent)
+	f.Sort = str(in.Sort)
+	f.Direction = str(in.Direction)
+
+	if from := str(in.From); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return f, errors.New("from must be a date like 2022-01-01")
+		}
+		f.From = t
+	}
+	if to := str(in.To); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return f, errors.New("to must be a date like 2022-01-31")
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+
+	return f, nil
+}
+
+// Orders returns a page of the orders for one time widgets
+func (r *Resolver) Orders(ctx context.Context, args orderListArgs) (*orderPageResolver, error) {
+	return r.orderPage(ctx, args, r.DB.GetAllOrdersPaginated)
+}
+
+// Subscriptions returns a page of the orders for subscriptions
+func (r *Resolver) Subscriptions(ctx context.Context, args orderListArgs) (*orderPageResolver, error) {
+	return r.orderPage(ctx, args, r.DB.GetAllSubscriptionsPaginated)
+}
+
+// orderPage returns the page of orders args ask for from list
+func (r *Resolver) orderPage(ctx context.Context, args orderListArgs,
+	list func(pageSize, page int, filter models.OrderFilter) ([]*models.Order, int, int, error)) (*orderPageResolver, error) {
+	err := requireScope(ctx, models.ScopeOrdersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := args.Filter.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	page, pageSize := pageArgs(args.Page, args.PageSize)
+	orders, lastPage, totalRecords, err := list(pageSize, page, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &orderPageResolver{
+		orders:       newOrderResolvers(ctx, orders),
+		currentPage:  page,
+		pageSize:     pageSize,
+		lastPage:     lastPage,
+		totalRecords: totalRecords,
+	}, nil
+}
+
+// Order returns the order with id, or nothing when there is none
+func (r *Resolver) Order(ctx context.Context, args struct{ ID graphql.ID }) (*orderResolver, error) {
+	err := requireScope(ctx, models.ScopeOrdersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := r.DB.GetOrderByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &orderResolver{&o}, nil
+}
+
+// Customers returns a page of customers, optionally searching their names and emails
+func (r *Resolver) Customers(ctx context.Context, args struct {
+	Page     int32
+	PageSize int32
+	Search   *string
+}) (*customerPageResolver, error) {
+	err := requireScope(ctx, models.ScopeCustomersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	search := ""
+	if args.Search != nil {
+		search = *args.Search
+	}
+
+	page, pageSize := pageArgs(args.Page, args.PageSize)
+	customers, lastPage, totalRecords, err := r.DB.GetCustomersPaginated(pageSize, page, search)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(customers))
+	resolvers := make([]*customerResolver, len(customers))
+	for i, c := range customers {
+		ids[i] = c.ID
+		resolvers[i] = &customerResolver{customer: c.Customer, summary: c}
+	}
+	loadersFor(ctx).customerOrders.want(ids...)
+
+	return &customerPageResolver{
+		customers:    resolvers,
+		currentPage:  page,
+		pageSize:     pageSize,
+		lastPage:     lastPage,
+		totalRecords: totalRecords,
+	}, nil
+}
+
+// Customer returns the customer with id, or nothing when there is none
+func (r *Resolver) Customer(ctx context.Context, args struct{ ID graphql.ID }) (*customerResolver, error) {
+	err := requireScope(ctx, models.ScopeCustomersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.DB.GetCustomer(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &customerResolver{customer: c.Customer, summary: &c}, nil
+}
+
+// Users returns every admin user
+func (r *Resolver) Users(ctx context.Context) ([]*userResolver, error) {
+	err := requireScope(ctx, models.ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := r.DB.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*userResolver, len(users))
+	for i, u := range users {
+		resolvers[i] = &userResolver{u}
+	}
+	return resolvers, nil
+}
+
+// User returns the admin user with id, or nothing when there is none
+func (r *Resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	err := requireScope(ctx, models.ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := r.DB.GetOneUser(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{&u}, nil
+}
+
+type widgetResolver struct {
+	w *models.Widget
+}
+
+func (r *widgetResolver) ID() graphql.ID        { return toID(r.w.ID) }
+func (r *widgetResolver) Name() string          { return r.w.Name }
+func (r *widgetResolver) Description() string   { return r.w.Description }
+func (r *widgetResolver) InventoryLevel() int32 { return int32(r.w.InventoryLevel) }
+func (r *widgetResolver) Price() int32          { return int32(r.w.Price) }
+func (r *widgetResolver) Image() string         { return r.w.Image }
+func (r *widgetResolver) IsRecurring() bool     { return r.w.IsRecurring }
+func (r *widgetResolver) PlanID() string        { return r.w.PlanID }
+
+type orderResolver struct {
+	o *models.Order
+}
+
+// newOrderResolvers returns resolvers for orders, with the widgets they are for and the orders of their customers
+// queued to be loaded together
+func newOrderResolvers(ctx context.Context, orders []*models.Order) []*orderResolver {
+	widgetIDs := make([]int, len(orders))
+	customerIDs := make([]int, len(orders))
+	resolvers := make([]*orderResolver, len(orders))
+	for i, o := range orders {
+		widgetIDs[i] = o.WidgetID
+		customerIDs[i] = o.Customer.ID
+		resolvers[i] = &orderResolver{o}
+	}
+	loadersFor(ctx).widgets.want(widgetIDs...)
+	loadersFor(ctx).customerOrders.want(customerIDs...)
+
+	return resolvers
+}
+
+func (r *orderResolver) ID() graphql.ID            { return toID(r.o.ID) }
+func (r *orderResolver) Quantity() int32           { return int32(r.o.Quantity) }
+func (r *orderResolver) Amount() int32             { return int32(r.o.Amount) }
+func (r *orderResolver) StatusID() int32           { return int32(r.o.StatusID) }
+func (r *orderResolver) Refunded() bool            { return r.o.StatusID == statusRefunded }
+func (r *orderResolver) FulfilmentStatusID() int32 { return int32(r.o.FulfilmentStatusID) }
+func (r *orderResolver) CreatedAt() graphql.Time   { return graphql.Time{Time: r.o.CreatedAt} }
+func (r *orderResolver) UpdatedAt() graphql.Time   { return graphql.Time{Time: r.o.UpdatedAt} }
+
+func (r *orderResolver) Status(ctx context.Context) (string, error) {
+	return loadersFor(ctx).statuses.load(r.o.StatusID)
+}
+
+func (r *orderResolver) FulfilmentStatus(ctx context.Context) (string, error) {
+	return loadersFor(ctx).fulfilmentStatuses.load(r.o.FulfilmentStatusID)
+}
+
+func (r *orderResolver) Widget(ctx context.Context) (*widgetResolver, error) {
+	w, err := loadersFor(ctx).widgets.load(r.o.WidgetID)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	return &widgetResolver{w}, nil
+}
+
+func (r *orderResolver) Transaction() *transactionResolver {
+	return &transactionResolver{&r.o.Transaction}
+}
+
+func (r *orderResolver) Customer() *customerResolver {
+	return &customerResolver{customer: r.o.Customer}
+}
+
+type transactionResolver struct {
+	t *models.Transaction
+}
+
+func (r *transactionResolver) ID() graphql.ID         { return toID(r.t.ID) }
+func (r *transactionResolver) Amount() int32          { return int32(r.t.Amount) }
+func (r *transactionResolver) Currency() string       { return r.t.Currency }
+func (r *transactionResolver) LastFour() string       { return r.t.LastFour }
+func (r *transactionResolver) ExpiryMonth() int32     { return int32(r.t.ExpiryMonth) }
+func (r *transactionResolver) ExpiryYear() int32      { return int32(r.t.ExpiryYear) }
+func (r *transactionResolver) PaymentIntent() string  { return r.t.PaymentIntent }
+func (r *transactionResolver) BankReturnCode() string { return r.t.BankReturnCode }
+
+// customerResolver resolves a customer; summary is only there for the customers of a page of customers, or one
+// looked up by id
+type customerResolver struct {
+	customer models.Customer
+	summary  *models.CustomerSummary
+}
+
+func (r *customerResolver) ID() graphql.ID    { return toID(r.customer.ID) }
+func (r *customerResolver) FirstName() string { return r.customer.FirstName }
+func (r *customerResolver) LastName() string  { return r.customer.LastName }
+func (r *customerResolver) Email() string     { return r.customer.Email }
+
+func (r *customerResolver) OrderCount() *int32 {
+	if r.summary == nil {
+		return nil
+	}
+	n := int32(r.summary.Orders)
+	return &n
+}
+
+func (r *customerResolver) LifetimeValue() *int32 {
+	if r.summary == nil {
+		return nil
+	}
+	n := int32(r.summary.LifetimeValue)
+	return &n
+}
+
+func (r *customerResolver) LastOrderAt() *graphql.Time {
+	if r.summary == nil || r.summary.LastOrderAt.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: r.summary.LastOrderAt}
+}
+
+// Orders returns the customer's orders for one time widgets, newest first
+func (r *customerResolver) Orders(ctx context.Context) ([]*orderResolver, error) {
+	return r.orders(ctx, func(o *models.Order) bool { return !o.Widget.IsRecurring })
+}
+
+// Subscriptions returns the customer's orders for subscriptions, newest first
+func (r *customerResolver) Subscriptions(ctx context.Context) ([]*orderResolver, error) {
+	return r.orders(ctx, func(o *models.Order) bool { return o.Widget.IsRecurring })
+}
+
+// Refunds returns the customer's refunded orders, newest first
+func (r *customerResolver) Refunds(ctx context.Context) ([]*orderResolver, error) {
+	return r.orders(ctx, func(o *models.Order) bool { return o.StatusID == statusRefunded })
+}
+
+// orders returns the customer's orders that keep says to
+func (r *customerResolver) orders(ctx context.Context, keep func(o *models.Order) bool) ([]*orderResolver, error) {
+	err := requireScope(ctx, models.ScopeOrdersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := loadersFor(ctx).customerOrders.load(r.customer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading the orders of customer %d: %w", r.customer.ID, err)
+	}
+
+	var kept []*models.Order
+	for _, o := range orders {
+		if keep(o) {
+			kept = append(kept, o)
+		}
+	}
+	return newOrderResolvers(ctx, kept), nil
+}
+
+type userResolver struct {
+	u *models.User
+}
+
+func (r *userResolver) ID() graphql.ID         { return toID(r.u.ID) }
+func (r *userResolver) FirstName() string      { return r.u.FirstName }
+func (r *userResolver) LastName() string       { return r.u.LastName }
+func (r *userResolver) Email() string          { return r.u.Email }
+func (r *userResolver) TwoFactorEnabled() bool { return r.u.TwoFactorEnabled }
+func (r *userResolver) SingleSignOn() bool     { return r.u.SingleSignOn }
+
+type orderPageResolver struct {
+	orders                                        []*orderResolver
+	currentPage, pageSize, lastPage, totalRecords int
+}
+
+func (r *orderPageResolver) Orders() []*orderResolver { return r.orders }
+func (r *orderPageResolver) CurrentPage() int32       { return int32(r.currentPage) }
+func (r *orderPageResolver) PageSize() int32          { return int32(r.pageSize) }
+func (r *orderPageResolver) LastPage() int32          { return int32(r.lastPage) }
+func (r *orderPageResolver) TotalRecords() int32      { return int32(r.totalRecords) }
+
+type customerPageResolver struct {
+	customers                                     []*customerResolver
+	currentPage, pageSize, lastPage, totalRecords int
+}
+
+func (r *customerPageResolver) Customers() []*customerResolver { return r.customers }
+func (r *customerPageResolver) CurrentPage() int32             { return int32(r.currentPage) }
+func (r *customerPageResolver) PageSize() int32                { return int32(r.pageSize) }
+func (r *customerPageResolver) LastPage() int32                { return int32(r.lastPage) }
+func (r *customerPageResolver) TotalRecords() int32            { return int32(r.totalRecords) }
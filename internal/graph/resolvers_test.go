@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	dbdriver "github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+	"github.com/mattn/go-sqlite3"
+)
+
+// queries counts the queries run through the counting driver
+var queries atomic.Int64
+
+var registerCounting sync.Once
+
+// countingConn is a SQLite connection that counts the queries run on it
+type countingConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queries.Add(1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+// countingDriver opens SQLite connections that count their queries
+type countingDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d countingDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// newTestDB returns models over a migrated SQLite database of their own, whose queries are counted
+func newTestDB(t *testing.T) models.DBModel {
+	t.Helper()
+
+	registerCounting.Do(func() {
+		sql.Register("sqlite3-counting", countingDriver{})
+	})
+
+	path := t.TempDir() + "/widgets.db"
+	conn, err := dbdriver.OpenDB(dbdriver.SQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&migrate.Migrator{DB: conn, Dialect: dbdriver.SQLite, FS: migrations.FS}).Up()
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counted, err := sql.Open("sqlite3-counting", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted.SetMaxOpenConns(1)
+	t.Cleanup(func() { counted.Close() })
+
+	return models.DBModel{DB: counted, Dialect: dbdriver.SQLite}
+}
+
+func TestOrdersOfCustomersOfOrdersLoadTogether(t *testing.T) {
+	db := newTestDB(t)
+
+	// three customers with two orders each
+	for c := 0; c < 3; c++ {
+		customerID, err := db.InsertCustomer(models.Customer{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for o := 0; o < 2; o++ {
+			txnID, err := db.InsertTransaction(models.Transaction{Amount: 1000, Currency: "cad", TransactionStatusID: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.InsertOrder(models.Order{
+				WidgetID:      1,
+				TransactionID: txnID,
+				CustomerID:    customerID,
+				StatusID:      1,
+				Quantity:      1,
+				Amount:        1000,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	schema, err := NewSchema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := &models.Token{Scopes: []string{models.ScopeOrdersRead, models.ScopeCustomersRead}}
+
+	queries.Store(0)
+	resp := schema.Exec(NewContext(context.Background(), db, token), `{
+		orders {
+			orders {
+				id
+				widget { name }
+				customer { orders { id widget { name } } }
+			}
+		}
+	}`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatal(resp.Errors)
+	}
+	if n := strings.Count(string(resp.Data), `"customer"`); n != 6 {
+		t.Fatalf("got %d orders with their customer, want 6: %s", n, resp.Data)
+	}
+
+	// the page and its count, the widgets, and the orders of every customer at once
+	if n := queries.Load(); n > 4 {
+		t.Errorf("the query ran %d queries for 6 orders, want at most 4", n)
+	}
+}
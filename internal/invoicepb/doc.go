@@ -0,0 +1,5 @@
+// Package invoicepb holds the messages and the gRPC service of the invoicing microservice, generated from
+// invoice.proto, and the signing of the calls made to it
+package invoicepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative invoice.proto
@@ -0,0 +1,233 @@
+// The invoicing microservice writes the pdf invoices of orders and emails them to customers. The shop's back end and
+// front end call it over gRPC, each request signed with the secret they share with it.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: invoice.proto
+
+package invoicepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	InvoiceService_CreateInvoice_FullMethodName    = "/invoice.v1.InvoiceService/CreateInvoice"
+	InvoiceService_GetInvoiceStatus_FullMethodName = "/invoice.v1.InvoiceService/GetInvoiceStatus"
+	InvoiceService_ResendInvoice_FullMethodName    = "/invoice.v1.InvoiceService/ResendInvoice"
+	InvoiceService_RedactInvoices_FullMethodName   = "/invoice.v1.InvoiceService/RedactInvoices"
+)
+
+// InvoiceServiceClient is the client API for InvoiceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InvoiceServiceClient interface {
+	// CreateInvoice writes the invoice of an order and emails it to the customer
+	CreateInvoice(ctx context.Context, in *CreateInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error)
+	// GetInvoiceStatus says whether the invoice of an order was written and when it was last emailed
+	GetInvoiceStatus(ctx context.Context, in *InvoiceRef, opts ...grpc.CallOption) (*Invoice, error)
+	// ResendInvoice emails an invoice that was written before to its customer again
+	ResendInvoice(ctx context.Context, in *InvoiceRef, opts ...grpc.CallOption) (*Invoice, error)
+	// RedactInvoices writes again, with the pseudonymised customer they carry, the invoices of orders whose customer
+	// had their personal data erased. Only invoices that were written before are replaced, and nothing is emailed
+	RedactInvoices(ctx context.Context, in *RedactInvoicesRequest, opts ...grpc.CallOption) (*RedactInvoicesResponse, error)
+}
+
+type invoiceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInvoiceServiceClient(cc grpc.ClientConnInterface) InvoiceServiceClient {
+	return &invoiceServiceClient{cc}
+}
+
+func (c *invoiceServiceClient) CreateInvoice(ctx context.Context, in *CreateInvoiceRequest, opts ...grpc.CallOption) (*Invoice, error) {
+	out := new(Invoice)
+	err := c.cc.Invoke(ctx, InvoiceService_CreateInvoice_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) GetInvoiceStatus(ctx context.Context, in *InvoiceRef, opts ...grpc.CallOption) (*Invoice, error) {
+	out := new(Invoice)
+	err := c.cc.Invoke(ctx, InvoiceService_GetInvoiceStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) ResendInvoice(ctx context.Context, in *InvoiceRef, opts ...grpc.CallOption) (*Invoice, error) {
+	out := new(Invoice)
+	err := c.cc.Invoke(ctx, InvoiceService_ResendInvoice_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *invoiceServiceClient) RedactInvoices(ctx context.Context, in *RedactInvoicesRequest, opts ...grpc.CallOption) (*RedactInvoicesResponse, error) {
+	out := new(RedactInvoicesResponse)
+	err := c.cc.Invoke(ctx, InvoiceService_RedactInvoices_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InvoiceServiceServer is the server API for InvoiceService service.
+// All implementations must embed UnimplementedInvoiceServiceServer
+// for forward compatibility
+type InvoiceServiceServer interface {
+	// CreateInvoice writes the invoice of an order and emails it to the customer
+	CreateInvoice(context.Context, *CreateInvoiceRequest) (*Invoice, error)
+	// GetInvoiceStatus says whether the invoice of an order was written and when it was last emailed
+	GetInvoiceStatus(context.Context, *InvoiceRef) (*Invoice, error)
+	// ResendInvoice emails an invoice that was written before to its customer again
+	ResendInvoice(context.Context, *InvoiceRef) (*Invoice, error)
+	// RedactInvoices writes again, with the pseudonymised customer they carry, the invoices of orders whose customer
+	// had their personal data erased. Only invoices that were written before are replaced, and nothing is emailed
+	RedactInvoices(context.Context, *RedactInvoicesRequest) (*RedactInvoicesResponse, error)
+	mustEmbedUnimplementedInvoiceServiceServer()
+}
+
+// UnimplementedInvoiceServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedInvoiceServiceServer struct {
+}
+
+func (UnimplementedInvoiceServiceServer) CreateInvoice(context.Context, *CreateInvoiceRequest) (*Invoice, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateInvoice not implemented")
+}
+func (UnimplementedInvoiceServiceServer) GetInvoiceStatus(context.Context, *InvoiceRef) (*Invoice, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInvoiceStatus not implemented")
+}
+func (UnimplementedInvoiceServiceServer) ResendInvoice(context.Context, *InvoiceRef) (*Invoice, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResendInvoice not implemented")
+}
+func (UnimplementedInvoiceServiceServer) RedactInvoices(context.Context, *RedactInvoicesRequest) (*RedactInvoicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedactInvoices not implemented")
+}
+func (UnimplementedInvoiceServiceServer) mustEmbedUnimplementedInvoiceServiceServer() {}
+
+// UnsafeInvoiceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InvoiceServiceServer will
+// result in compilation errors.
+type UnsafeInvoiceServiceServer interface {
+	mustEmbedUnimplementedInvoiceServiceServer()
+}
+
+func RegisterInvoiceServiceServer(s grpc.ServiceRegistrar, srv InvoiceServiceServer) {
+	s.RegisterService(&InvoiceService_ServiceDesc, srv)
+}
+
+func _InvoiceService_CreateInvoice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).CreateInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: InvoiceService_CreateInvoice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).CreateInvoice(ctx, req.(*CreateInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_GetInvoiceStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvoiceRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).GetInvoiceStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: InvoiceService_GetInvoiceStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).GetInvoiceStatus(ctx, req.(*InvoiceRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_ResendInvoice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvoiceRef)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).ResendInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: InvoiceService_ResendInvoice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).ResendInvoice(ctx, req.(*InvoiceRef))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _InvoiceService_RedactInvoices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RedactInvoicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InvoiceServiceServer).RedactInvoices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: InvoiceService_RedactInvoices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InvoiceServiceServer).RedactInvoices(ctx, req.(*RedactInvoicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// InvoiceService_ServiceDesc is the grpc.ServiceDesc for InvoiceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var InvoiceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "invoice.v1.InvoiceService",
+	HandlerType: (*InvoiceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateInvoice",
+			Handler:    _InvoiceService_CreateInvoice_Handler,
+		},
+		{
+			MethodName: "GetInvoiceStatus",
+			Handler:    _InvoiceService_GetInvoiceStatus_Handler,
+		},
+		{
+			MethodName: "ResendInvoice",
+			Handler:    _InvoiceService_ResendInvoice_Handler,
+		},
+		{
+			MethodName: "RedactInvoices",
+			Handler:    _InvoiceService_RedactInvoices_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "invoice.proto",
+}
@@ -0,0 +1,628 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// call is one call of a fizz migration, like add_column("widgets", "image", "string", {}), with the calls of the
+// block it is given, for create_table
+type call struct {
+	name  string
+	args  []interface{} // strings, numbers, bools, []interface{} and map[string]interface{}
+	block []call
+}
+
+// translateFizz returns the statements the fizz migration src runs on a database of dialect. Only the calls the
+// package documentation lists are understood
+func translateFizz(src string, dialect driver.Dialect) ([]string, error) {
+	p := &fizzParser{src: src}
+	calls, err := p.parseCalls(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []string
+	for _, c := range calls {
+		s, err := translateCall(c, dialect.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+		stmts = append(stmts, s...)
+	}
+	return stmts, nil
+}
+
+// translateCall returns the statements c runs on a database of dialect
+func translateCall(c call, dialect driver.Dialect) ([]string, error) {
+	switch c.name {
+	case "sql":
+		s, err := stringArgs(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		return splitStatements(s[0]), nil
+
+	case "create_table":
+		s, err := stringArgs(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		return createTable(s[0], c.block, dialect)
+
+	case "drop_table":
+		s, err := stringArgs(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"drop table " + quote(s[0], dialect)}, nil
+
+	case "add_column":
+		s, err := stringArgs(c, 3)
+		if err != nil {
+			return nil, err
+		}
+		col, err := columnDefinition(s[1], s[2], options(c, 3), dialect)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("alter table %s add column %s", quote(s[0], dialect), col)}, nil
+
+	case "drop_column":
+		s, err := stringArgs(c, 2)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("alter table %s drop column %s", quote(s[0], dialect), quote(s[1], dialect))}, nil
+
+	case "add_index":
+		return addIndex(c, dialect)
+
+	case "drop_index":
+		s, err := stringArgs(c, 2)
+		if err != nil {
+			return nil, err
+		}
+		if dialect == driver.MySQL {
+			return []string{fmt.Sprintf("drop index %s on %s", quote(s[1], dialect), quote(s[0], dialect))}, nil
+		}
+		return []string{"drop index " + quote(s[1], dialect)}, nil
+
+	case "add_foreign_key":
+		return addForeignKey(c, dialect)
+
+	case "drop_foreign_key":
+		s, err := stringArgs(c, 2)
+		if err != nil {
+			return nil, err
+		}
+		switch dialect {
+		case driver.MySQL:
+			return []string{fmt.Sprintf("alter table %s drop foreign key %s", quote(s[0], dialect), quote(s[1], dialect))}, nil
+		case driver.Postgres:
+			return []string{fmt.Sprintf("alter table %s drop constraint %s", quote(s[0], dialect), quote(s[1], dialect))}, nil
+		default:
+			return nil, errNoSQLiteForeignKeys
+		}
+
+	default:
+		return nil, fmt.Errorf("%s is not a fizz call we know", c.name)
+	}
+}
+
+// errNoSQLiteForeignKeys is returned for changes to the foreign keys of an existing SQLite table, which only making
+// the table again can do
+var errNoSQLiteForeignKeys = errors.New("SQLite cannot change the foreign keys of a table; give the migration a .sqlite3 version")
+
+// createTable returns the statements creating table with the columns of block. Like soda, it gives the table an
+// integer id when no column is the primary key, and created_at and updated_at columns unless block calls
+// t.DisableTimestamps
+func createTable(table string, block []call, dialect driver.Dialect) ([]string, error) {
+	var cols []string
+	var primary string
+	timestamps := true
+	hasID := false
+
+	for _, c := range block {
+		switch c.name {
+		case "t.Column":
+			s, err := stringArgs(c, 2)
+			if err != nil {
+				return nil, err
+			}
+			opts := options(c, 2)
+			if opts["primary"] == true {
+				primary = s[0]
+			}
+			if s[0] == "id" {
+				hasID = true
+			}
+			col, err := columnDefinition(s[0], s[1], opts, dialect)
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, col)
+		case "t.DisableTimestamps":
+			timestamps = false
+		case "t.Timestamps":
+		default:
+			return nil, fmt.Errorf("%s is not a create_table call we know", c.name)
+		}
+	}
+
+	if primary == "" && !hasID {
+		col, _ := columnDefinition("id", "integer", map[string]interface{}{"primary": true}, dialect)
+		cols = append([]string{col}, cols...)
+		primary = "id"
+	}
+	if timestamps {
+		for _, name := range []string{"created_at", "updated_at"} {
+			col, _ := columnDefinition(name, "timestamp", nil, dialect)
+			cols = append(cols, col)
+		}
+	}
+	if dialect == driver.MySQL {
+		cols = append(cols, fmt.Sprintf("primary key (%s)", quote(primary, dialect)))
+	}
+
+	stmt := fmt.Sprintf("create table %s (\n    %s\n)", quote(table, dialect), strings.Join(cols, ",\n    "))
+	if dialect == driver.MySQL {
+		stmt += " engine=InnoDB"
+	}
+	return []string{stmt}, nil
+}
+
+// columnDefinition returns the definition of column name of the fizz type colType, with the options primary, null,
+// default and size. Columns are not null unless the null option says they may be
+func columnDefinition(name, colType string, opts map[string]interface{}, dialect driver.Dialect) (string, error) {
+	if opts["primary"] == true {
+		switch dialect {
+		case driver.MySQL:
+			return quote(name, dialect) + " integer not null auto_increment", nil
+		case driver.Postgres:
+			return quote(name, dialect) + " serial primary key", nil
+		default:
+			return quote(name, dialect) + " integer primary key autoincrement", nil
+		}
+	}
+
+	sqlType, err := columnType(colType, opts, dialect)
+	if err != nil {
+		return "", err
+	}
+
+	def := quote(name, dialect) + " " + sqlType
+	if opts["null"] != true {
+		def += " not null"
+	}
+	if value, ok := opts["default"]; ok {
+		def += " default " + literal(value, colType)
+	}
+	return def, nil
+}
+
+// columnType returns the type dialect has for the fizz type colType
+func columnType(colType string, opts map[string]interface{}, dialect driver.Dialect) (string, error) {
+	size := 255
+	if n, ok := opts["size"].(int); ok {
+		size = n
+	}
+
+	switch colType {
+	case "string":
+		return fmt.Sprintf("varchar(%d)", size), nil
+	case "text":
+		return "text", nil
+	case "integer", "int":
+		return "integer", nil
+	case "bigint":
+		return "bigint", nil
+	case "bool", "boolean":
+		return "boolean", nil
+	case "float":
+		if dialect == driver.Postgres {
+			return "double precision", nil
+		}
+		return "float", nil
+	case "timestamp", "datetime":
+		if dialect == driver.Postgres {
+			return "timestamp", nil
+		}
+		return "datetime", nil
+	case "blob":
+		if dialect == driver.Postgres {
+			return "bytea", nil
+		}
+		return "blob", nil
+	case "varbinary":
+		switch dialect {
+		case driver.Postgres:
+			return "bytea", nil
+		case driver.SQLite:
+			return "blob", nil
+		default:
+			return fmt.Sprintf("varbinary(%d)", size), nil
+		}
+	default:
+		return "", fmt.Errorf("%s is not a column type we know", colType)
+	}
+}
+
+// literal returns value written as a default of a column of the fizz type colType. Booleans given as 0 or 1 are
+// written as false and true, which every dialect reads as a boolean
+func literal(value interface{}, colType string) string {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		if colType == "bool" || colType == "boolean" {
+			return strconv.FormatBool(v != 0)
+		}
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return "null"
+	}
+}
+
+// addIndex returns the statement for add_index(table, column or [columns], {unique, name}). Indexes are named
+// table_columns_idx unless they are given a name
+func addIndex(c call, dialect driver.Dialect) ([]string, error) {
+	s, err := stringArgs(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	table := s[0]
+
+	var columns []string
+	switch v := arg(c, 1).(type) {
+	case string:
+		columns = []string{v}
+	case []interface{}:
+		for _, col := range v {
+			name, ok := col.(string)
+			if !ok {
+				return nil, fmt.Errorf("the columns of an index are strings")
+			}
+			columns = append(columns, name)
+		}
+	default:
+		return nil, fmt.Errorf("an index needs a column or a list of columns")
+	}
+
+	opts := options(c, 2)
+	name, ok := opts["name"].(string)
+	if !ok {
+		name = fmt.Sprintf("%s_%s_idx", table, strings.Join(columns, "_"))
+	}
+
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = quote(col, dialect)
+	}
+
+	create := "create index"
+	if opts["unique"] == true {
+		create = "create unique index"
+	}
+	return []string{fmt.Sprintf("%s %s on %s (%s)", create, quote(name, dialect), quote(table, dialect), strings.Join(quoted, ", "))}, nil
+}
+
+// addForeignKey returns the statement for add_foreign_key(table, column, {ref_table: [ref_column]}, {on_delete,
+// on_update, name}). Foreign keys are named table_ref_table_ref_column_fk unless they are given a name
+func addForeignKey(c call, dialect driver.Dialect) ([]string, error) {
+	if dialect == driver.SQLite {
+		return nil, errNoSQLiteForeignKeys
+	}
+
+	s, err := stringArgs(c, 2)
+	if err != nil {
+		return nil, err
+	}
+	table, column := s[0], s[1]
+
+	refs, ok := arg(c, 2).(map[string]interface{})
+	if !ok || len(refs) != 1 {
+		return nil, fmt.Errorf("a foreign key needs the table and column it refers to")
+	}
+	var refTable, refColumn string
+	for t, cols := range refs {
+		list, _ := cols.([]interface{})
+		if len(list) != 1 {
+			return nil, fmt.Errorf("a foreign key refers to one column")
+		}
+		refTable = t
+		refColumn, _ = list[0].(string)
+	}
+
+	opts := options(c, 3)
+	name, ok := opts["name"].(string)
+	if !ok {
+		name = fmt.Sprintf("%s_%s_%s_fk", table, refTable, refColumn)
+	}
+
+	stmt := fmt.Sprintf("alter table %s add constraint %s foreign key (%s) references %s (%s)",
+		quote(table, dialect), quote(name, dialect), quote(column, dialect), quote(refTable, dialect), quote(refColumn, dialect))
+	if action, ok := opts["on_delete"].(string); ok {
+		stmt += " on delete " + action
+	}
+	if action, ok := opts["on_update"].(string); ok {
+		stmt += " on update " + action
+	}
+	return []string{stmt}, nil
+}
+
+// quote quotes the identifier name the way dialect does
+func quote(name string, dialect driver.Dialect) string {
+	if dialect == driver.MySQL {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+// stringArgs returns the first n arguments of c, which have to be strings
+func stringArgs(c call, n int) ([]string, error) {
+	if len(c.args) < n {
+		return nil, fmt.Errorf("needs %d arguments", n)
+	}
+	s := make([]string, n)
+	for i := range s {
+		var ok bool
+		s[i], ok = c.args[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d has to be a string", i+1)
+		}
+	}
+	return s, nil
+}
+
+// arg returns argument i of c, or nil when it was not given
+func arg(c call, i int) interface{} {
+	if i < len(c.args) {
+		return c.args[i]
+	}
+	return nil
+}
+
+// options returns argument i of c as options, which may be left out
+func options(c call, i int) map[string]interface{} {
+	opts, _ := arg(c, i).(map[string]interface{})
+	return opts
+}
+
+// fizzParser reads the calls of a fizz migration
+type fizzParser struct {
+	src string
+	pos int
+}
+
+// parseCalls reads calls up to the end of the migration, or of the block when inBlock
+func (p *fizzParser) parseCalls(inBlock bool) ([]call, error) {
+	var calls []call
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			if inBlock {
+				return nil, p.errorf("a block is not closed")
+			}
+			return calls, nil
+		}
+		if inBlock && p.src[p.pos] == '}' {
+			p.pos++
+			return calls, nil
+		}
+
+		c, err := p.parseCall()
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, c)
+	}
+}
+
+// parseCall reads a call, with its block if it has one
+func (p *fizzParser) parseCall() (call, error) {
+	var c call
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == '.' || isAlnum(p.src[p.pos])) {
+		p.pos++
+	}
+	c.name = p.src[start:p.pos]
+	if c.name == "" {
+		return c, p.errorf("expected a call")
+	}
+
+	p.skipSpace()
+	if !p.consume('(') {
+		return c, p.errorf("expected ( after %s", c.name)
+	}
+	for {
+		p.skipSpace()
+		if p.consume(')') {
+			break
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return c, err
+		}
+		c.args = append(c.args, v)
+		p.skipSpace()
+		if p.consume(')') {
+			break
+		}
+		if !p.consume(',') {
+			return c, p.errorf("expected , or ) in the arguments of %s", c.name)
+		}
+	}
+
+	p.skipSpace()
+	if p.consume('{') {
+		block, err := p.parseCalls(true)
+		if err != nil {
+			return c, err
+		}
+		c.block = block
+	}
+	return c, nil
+}
+
+// parseValue reads a string, number, boolean, list or map
+func (p *fizzParser) parseValue() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+
+	switch ch := p.src[p.pos]; {
+	case ch == '"':
+		return p.parseString()
+	case ch == '[':
+		p.pos++
+		var list []interface{}
+		for {
+			p.skipSpace()
+			if p.consume(']') {
+				return list, nil
+			}
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipSpace()
+			p.consume(',')
+		}
+	case ch == '{':
+		p.pos++
+		m := make(map[string]interface{})
+		for {
+			p.skipSpace()
+			if p.consume('}') {
+				return m, nil
+			}
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(':') {
+				return nil, p.errorf("expected : after %s", key)
+			}
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			p.skipSpace()
+			p.consume(',')
+		}
+	case ch == '-' || (ch >= '0' && ch <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		if n, err := strconv.Atoi(text); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("%s is not a number", text)
+		}
+		return f, nil
+	default:
+		word, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil", "null":
+			return nil, nil
+		}
+		return nil, p.errorf("%s is not a value", word)
+	}
+}
+
+// parseKey reads the key of a map entry, quoted or not
+func (p *fizzParser) parseKey() (string, error) {
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		return p.parseString()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '_' || isAlnum(p.src[p.pos])) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a key")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// parseString reads a double quoted string
+func (p *fizzParser) parseString() (string, error) {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		p.pos++
+		switch ch {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos < len(p.src) {
+				b.WriteByte(p.src[p.pos])
+				p.pos++
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", p.errorf("a string is not closed")
+}
+
+// skipSpace skips white space and comments
+func (p *fizzParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch {
+		case unicode.IsSpace(rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"), p.src[p.pos] == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// consume skips ch if it is next
+func (p *fizzParser) consume(ch byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == ch {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *fizzParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isAlnum(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,187 @@
+package migrate
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+)
+
+func TestTranslateFizz(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		dialect driver.Dialect
+		want    []string
+	}{
+		{
+			name: "create table on mysql",
+			src: `create_table("widgets") {
+				t.Column("name", "string", {"default": ""})
+				t.Column("price", "integer", {null: true})
+			}`,
+			dialect: driver.MySQL,
+			want: []string{"create table `widgets` (\n" +
+				"    `id` integer not null auto_increment,\n" +
+				"    `name` varchar(255) not null default '',\n" +
+				"    `price` integer,\n" +
+				"    `created_at` datetime not null,\n" +
+				"    `updated_at` datetime not null,\n" +
+				"    primary key (`id`)\n" +
+				") engine=InnoDB"},
+		},
+		{
+			name: "create table on postgres",
+			src: `create_table("tokens") {
+				t.Column("id", "integer", {primary: true})
+				t.Column("hash", "varbinary", {"size": 32})
+				t.DisableTimestamps()
+			}`,
+			dialect: driver.Postgres,
+			want: []string{"create table \"tokens\" (\n" +
+				"    \"id\" serial primary key,\n" +
+				"    \"hash\" bytea not null\n" +
+				")"},
+		},
+		{
+			name: "create table on sqlite",
+			src: `create_table("users") {
+				t.Column("email", "string", {"size": 100})
+				t.Column("active", "bool", {"default": 1})
+				t.Timestamps()
+			}`,
+			dialect: driver.SQLite,
+			want: []string{"create table \"users\" (\n" +
+				"    \"id\" integer primary key autoincrement,\n" +
+				"    \"email\" varchar(100) not null,\n" +
+				"    \"active\" boolean not null default true,\n" +
+				"    \"created_at\" datetime not null,\n" +
+				"    \"updated_at\" datetime not null\n" +
+				")"},
+		},
+		{
+			name:    "columns",
+			src:     `add_column("orders", "note", "text", {"null": true}) drop_column("orders", "old")`,
+			dialect: driver.Postgres,
+			want: []string{
+				`alter table "orders" add column "note" text`,
+				`alter table "orders" drop column "old"`,
+			},
+		},
+		{
+			name: "indexes on mysql",
+			src: `add_index("tokens", ["user_id", "expiry"], {"unique": true})
+				drop_index("tokens", "tokens_user_id_expiry_idx")`,
+			dialect: driver.MySQL,
+			want: []string{
+				"create unique index `tokens_user_id_expiry_idx` on `tokens` (`user_id`, `expiry`)",
+				"drop index `tokens_user_id_expiry_idx` on `tokens`",
+			},
+		},
+		{
+			name: "indexes on sqlite",
+			src: `add_index("orders", "status_id", {"name": "orders_status"})
+				drop_index("orders", "orders_status")`,
+			dialect: driver.SQLite,
+			want: []string{
+				`create index "orders_status" on "orders" ("status_id")`,
+				`drop index "orders_status"`,
+			},
+		},
+		{
+			name: "foreign keys on postgres",
+			src: `add_foreign_key("orders", "widget_id", {"widgets": ["id"]}, {"on_delete": "cascade"})
+				drop_foreign_key("orders", "orders_widgets_id_fk")`,
+			dialect: driver.Postgres,
+			want: []string{
+				`alter table "orders" add constraint "orders_widgets_id_fk" foreign key ("widget_id") references "widgets" ("id") on delete cascade`,
+				`alter table "orders" drop constraint "orders_widgets_id_fk"`,
+			},
+		},
+		{
+			name:    "sql",
+			src:     "// seed the statuses\nsql(\"insert into statuses (name) values ('Cleared'); insert into statuses (name) values ('Refunded;');\")",
+			dialect: driver.MySQL,
+			want: []string{
+				"insert into statuses (name) values ('Cleared')",
+				"insert into statuses (name) values ('Refunded;')",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translateFizz(tt.src, tt.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestTranslateFizzErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		dialect driver.Dialect
+		want    string
+	}{
+		{"call we do not know", `rename_table("a", "b")`, driver.MySQL, "rename_table is not a fizz call we know"},
+		{"create_table call we do not know", `create_table("a") { t.ForeignKey("b_id", {"b": ["id"]}) }`, driver.MySQL, "t.ForeignKey is not a create_table call we know"},
+		{"column type we do not know", `add_column("a", "b", "uuid", {})`, driver.MySQL, "uuid is not a column type we know"},
+		{"missing arguments", `drop_column("a")`, driver.MySQL, "needs 2 arguments"},
+		{"argument that is not a string", `drop_table(1)`, driver.MySQL, "argument 1 has to be a string"},
+		{"block not closed", "create_table(\"a\") {\n t.Column(\"b\", \"string\", {})", driver.MySQL, "line 2: a block is not closed"},
+		{"string not closed", "drop_table(\"a)", driver.MySQL, "line 1: a string is not closed"},
+		{"plush", `<%= sql("select 1") %>`, driver.MySQL, "line 1: expected a call"},
+		{"foreign key on sqlite", `add_foreign_key("a", "b_id", {"b": ["id"]}, {})`, driver.SQLite, errNoSQLiteForeignKeys.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := translateFizz(tt.src, tt.dialect)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one saying %q", err, tt.want)
+			}
+		})
+	}
+
+	_, err := translateFizz(`drop_foreign_key("a", "a_b_id_fk")`, driver.SQLite)
+	if !errors.Is(err, errNoSQLiteForeignKeys) {
+		t.Errorf("got error %v dropping a foreign key on sqlite, want %v", err, errNoSQLiteForeignKeys)
+	}
+}
+
+// TestMigrationsTranslate checks that every fizz migration a database runs is in the part of fizz understood here
+func TestMigrationsTranslate(t *testing.T) {
+	for _, dialect := range []driver.Dialect{driver.MySQL, driver.Postgres, driver.SQLite} {
+		m := &Migrator{Dialect: dialect, FS: migrations.FS}
+		migs, err := m.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, mig := range migs {
+			for _, name := range []string{mig.Up, mig.Down} {
+				if !strings.HasSuffix(name, ".fizz") {
+					continue
+				}
+				src, err := fs.ReadFile(migrations.FS, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = translateFizz(string(src), dialect)
+				if err != nil {
+					t.Errorf("%s on %s: %v", name, dialect, err)
+				}
+			}
+		}
+	}
+}
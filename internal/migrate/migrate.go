@@ -0,0 +1,329 @@
+// Package migrate applies and rolls back the migrations of the shop's database, recording them in schema_migration
+// the way soda does, without soda or the fizz library. The fizz migrations are translated here, and only the part of
+// fizz our migrations use is understood:
+//
+//   - create_table("name") { ... } with t.Column("name", "type", {options}), t.Timestamps() and
+//     t.DisableTimestamps(). The table gets an integer id when no column is the primary key, and created_at and
+//     updated_at unless timestamps are disabled
+//   - drop_table, add_column, drop_column, add_index (one column or a list, with unique and name), drop_index,
+//     add_foreign_key (with on_delete, on_update and name), drop_foreign_key and sql
+//   - the column types string, text, integer, int, bigint, bool, boolean, float, timestamp, datetime, blob and
+//     varbinary, and the column options primary, null, default and size
+//
+// Anything else, like rename_table, change_column, t.ForeignKey or raw Plush templating, fails the migration rather
+// than being skipped. SQLite cannot change the foreign keys of an existing table, so a migration that does needs a
+// .sqlite3 version, as does one that needs any of the rest; migrations written for one database are preferred over
+// those written for all of them
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// migrationTimeout is how long a migration may take to apply or roll back
+const migrationTimeout = 5 * time.Minute
+
+// fileName is how a migration file is named, the soda way: version_name[.dialect].up|down.fizz|sql
+var fileName = regexp.MustCompile(`^(\d{14})_(\w+?)(?:\.(mysql|postgres|sqlite3))?\.(up|down)\.(fizz|sql)$`)
+
+// migrationName is what a new migration may be called
+var migrationName = regexp.MustCompile(`^\w+$`)
+
+// Migration is one version of the schema, with the files that apply and roll it back on a database of the dialect it
+// was loaded for. A file written for that dialect is used in place of the one written for every database
+type Migration struct {
+	Version string
+	Name    string
+	Up      string // the name of the file applying the migration
+	Down    string // the name of the file rolling it back, if there is one
+}
+
+// Status is a migration, and when it was applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies the migrations in FS to DB, a database of Dialect, recording the versions applied in the
+// schema_migration table, as soda does
+type Migrator struct {
+	DB      *sql.DB
+	Dialect driver.Dialect
+	FS      fs.FS
+}
+
+// Migrations returns the migrations in m.FS for m.Dialect, oldest first. Migrations written only for other
+// databases are left out
+func (m *Migrator) Migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(m.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	specific := make(map[string]bool) // the files chosen that were written for m.Dialect
+
+	for _, f := range files {
+		match := fileName.FindStringSubmatch(f.Name())
+		if match == nil {
+			continue
+		}
+		version, name, dialect, direction := match[1], match[2], match[3], match[4]
+		if dialect != "" && driver.Dialect(dialect) != m.Dialect.Name() {
+			continue
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		}
+
+		key := version + direction
+		if specific[key] {
+			continue
+		}
+		if direction == "up" {
+			mig.Up = f.Name()
+		} else {
+			mig.Down = f.Name()
+		}
+		specific[key] = dialect != ""
+	}
+
+	var migrations []Migration
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status returns every migration, and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, mig := range migrations {
+		statuses[i] = Status{Migration: mig, Applied: applied[mig.Version]}
+	}
+	return statuses, nil
+}
+
+// Up applies the migrations that have not been, oldest first, and returns them
+func (m *Migrator) Up() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+		err = m.run(s.Migration, s.Up, "insert into schema_migration (version) values (?)")
+		if err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the n migrations applied last, newest first, and returns them
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(statuses) - 1; i >= 0 && len(done) < n; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return done, fmt.Errorf("migration %s_%s cannot be rolled back", s.Version, s.Name)
+		}
+		err = m.run(s.Migration, s.Down, "delete from schema_migration where version = ?")
+		if err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// run runs the statements of file, and record, given the version of mig, in one transaction. MySQL commits each
+// change to a table's structure on its own, so a migration that fails there may be left half done
+func (m *Migrator) run(mig Migration, file, record string) error {
+	stmts, err := m.statements(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, record, mig.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// statements returns the statements of the migration file called name, written for m.Dialect
+func (m *Migrator) statements(name string) ([]string, error) {
+	src, err := fs.ReadFile(m.FS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(name, ".fizz") {
+		return translateFizz(string(src), m.Dialect)
+	}
+	return splitStatements(string(src)), nil
+}
+
+// applied returns the versions recorded in schema_migration, creating the table the first time
+func (m *Migrator) applied() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, "select count(*) from schema_migration").Scan(&count)
+	if err != nil {
+		stmts := []string{
+			"create table schema_migration (version varchar(14) not null)",
+			"create unique index schema_migration_version_idx on schema_migration (version)",
+		}
+		for _, stmt := range stmts {
+			_, err = m.DB.ExecContext(ctx, stmt)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err := m.DB.QueryContext(ctx, "select version from schema_migration")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Create writes the files of a new migration called name to dir, with the current time as its version, and returns
+// their paths. ext is fizz, to write the migration once for every database, or sql
+func Create(dir, name, ext string) ([]string, error) {
+	if ext != "fizz" && ext != "sql" {
+		return nil, fmt.Errorf("a migration is fizz or sql, not %s", ext)
+	}
+	name = strings.ToLower(strings.Join(strings.Fields(name), "_"))
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("%q is not a name for a migration", name)
+	}
+
+	version := time.Now().UTC().Format("20060102150405")
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.%s", version, name, direction, ext))
+		err := os.WriteFile(path, nil, 0644)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// splitStatements splits the SQL in src into its statements, at the semicolons that are not in strings, quoted
+// identifiers or comments. Drivers do not all run more than one statement at a time
+func splitStatements(src string) []string {
+	var stmts []string
+	var b strings.Builder
+	var quote byte
+
+	add := func() {
+		stmt := strings.TrimSpace(b.String())
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		b.Reset()
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '-' && strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case ch == ';':
+			add()
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	add()
+
+	return stmts
+}
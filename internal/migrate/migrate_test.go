@@ -0,0 +1,139 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+)
+
+func TestSplitStatements(t *testing.T) {
+	src := `
+		-- a comment; with a semicolon
+		create table "a;b" (c varchar(10) default ';');
+		insert into ` + "`a;b`" + ` values ('it''s; fine');
+
+		select 1
+	`
+	want := []string{
+		`create table "a;b" (c varchar(10) default ';')`,
+		"insert into `a;b` values ('it''s; fine')",
+		"select 1",
+	}
+
+	got := splitStatements(src)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMigrationsPreferTheirDialect(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20220101000000_one.up.fizz":             {},
+		"20220101000000_one.down.fizz":           {},
+		"20220101000000_one.sqlite3.up.sql":      {},
+		"20220102000000_two.mysql.up.sql":        {},
+		"20220102000000_two.mysql.down.sql":      {},
+		"20220102000000_two.postgres.up.sql":     {},
+		"20220103000000_three.up.fizz":           {},
+		"20220103000000_three.postgres.down.sql": {},
+		"README.md":                              {},
+	}
+
+	tests := []struct {
+		dialect driver.Dialect
+		want    []Migration
+	}{
+		{driver.MySQL, []Migration{
+			{"20220101000000", "one", "20220101000000_one.up.fizz", "20220101000000_one.down.fizz"},
+			{"20220102000000", "two", "20220102000000_two.mysql.up.sql", "20220102000000_two.mysql.down.sql"},
+			{"20220103000000", "three", "20220103000000_three.up.fizz", ""},
+		}},
+		{driver.Postgres, []Migration{
+			{"20220101000000", "one", "20220101000000_one.up.fizz", "20220101000000_one.down.fizz"},
+			{"20220102000000", "two", "20220102000000_two.postgres.up.sql", ""},
+			{"20220103000000", "three", "20220103000000_three.up.fizz", "20220103000000_three.postgres.down.sql"},
+		}},
+		{driver.SQLite, []Migration{
+			{"20220101000000", "one", "20220101000000_one.sqlite3.up.sql", "20220101000000_one.down.fizz"},
+			{"20220103000000", "three", "20220103000000_three.up.fizz", ""},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			got, err := (&Migrator{Dialect: tt.dialect, FS: fsys}).Migrations()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	conn, err := driver.OpenDB(driver.SQLite, t.TempDir()+"/widgets.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	m := &Migrator{DB: conn, Dialect: driver.SQLite, FS: migrations.FS}
+	all, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every migration applies, rolls back and applies again
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(all) {
+		t.Fatalf("applied %d migrations, want %d", len(done), len(all))
+	}
+
+	done, err = m.Up()
+	if err != nil || len(done) != 0 {
+		t.Fatalf("applied %d migrations again, %v; want none", len(done), err)
+	}
+
+	done, err = m.Down(len(all))
+	if err != nil {
+		t.Fatalf("rolling back after %v: %v", done, err)
+	}
+	if len(done) != len(all) {
+		t.Fatalf("rolled back %d migrations, want %d", len(done), len(all))
+	}
+
+	var tables int
+	err = conn.QueryRow("select count(*) from sqlite_master where type = 'table' and name not in ('schema_migration', 'sqlite_sequence')").Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables are left once every migration is rolled back", tables)
+	}
+
+	done, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(all) {
+		t.Errorf("applied %d migrations the second time, want %d", len(done), len(all))
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("migration %s_%s is not recorded as applied", s.Version, s.Name)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package mockidp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// keyID names the one signing key
+const keyID = "mock"
+
+// codeTTL is how long an authorization code can be exchanged for
+const codeTTL = time.Minute
+
+// authorization is what an authorization code was issued for
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	name          string
+	groups        []string
+	expiry        time.Time
+}
+
+func (p *Provider) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	out, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		p.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// tokenError answers a token request with an OAuth 2 error
+func (p *Provider) tokenError(w http.ResponseWriter, code, description string) {
+	p.writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// Discovery describes the provider
+func (p *Provider) Discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.config.Issuer
+
+	p.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile", "groups"},
+		"claims_supported":                      []string{"sub", "email", "email_verified", "name", "given_name", "family_name", "groups"},
+	})
+}
+
+// Keys publishes the public key id tokens are signed with
+func (p *Provider) Keys(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+
+	p.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+var signInForm = template.Must(template.New("sign-in").Parse(`<!doctype html>
+<html>
+<head><title>Mock Identity Provider</title></head>
+<body>
+<h1>Mock Identity Provider</h1>
+<form method="post" action="/authorize?{{.Query}}">
+    <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+    <p><label>Name <input type="text" name="name" value="{{.Name}}"></label></p>
+    <p><label>Groups <input type="text" name="groups" value="{{.Groups}}"></label> (comma separated)</p>
+    <button type="submit">Sign In</button>
+</form>
+</body>
+</html>
+`))
+
+// Authorize shows the sign in form, or with -auto signs in the configured user straight away
+func (p *Provider) Authorize(w http.ResponseWriter, r *http.Request) {
+	if !p.validAuthorizeRequest(w, r) {
+		return
+	}
+
+	if p.config.Auto {
+		p.issueCode(w, r, p.config.User.Email, p.config.User.Name, p.config.User.Groups)
+		return
+	}
+
+	err := signInForm.Execute(w, map[string]string{
+		"Query":  r.URL.RawQuery,
+		"Email":  p.config.User.Email,
+		"Name":   p.config.User.Name,
+		"Groups": p.config.User.Groups,
+	})
+	if err != nil {
+		p.errorLog.Println(err)
+	}
+}
+
+// Approve signs in the user the sign in form describes
+func (p *Provider) Approve(w http.ResponseWriter, r *http.Request) {
+	if !p.validAuthorizeRequest(w, r) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.issueCode(w, r, r.PostForm.Get("email"), r.PostForm.Get("name"), r.PostForm.Get("groups"))
+}
+
+// validAuthorizeRequest checks the query of an authorization request, telling the browser what is wrong with it
+func (p *Provider) validAuthorizeRequest(w http.ResponseWriter, r *http.Request) bool {
+	qs := r.URL.Query()
+
+	switch {
+	case qs.Get("client_id") != p.config.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+	case qs.Get("response_type") != "code":
+		http.Error(w, "response_type must be code", http.StatusBadRequest)
+	case qs.Get("redirect_uri") == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+	case qs.Get("code_challenge") != "" && qs.Get("code_challenge_method") != "S256":
+		http.Error(w, "code_challenge_method must be S256", http.StatusBadRequest)
+	default:
+		return true
+	}
+
+	return false
+}
+
+// issueCode sends the browser back to the client with an authorization code for the user
+func (p *Provider) issueCode(w http.ResponseWriter, r *http.Request, email, name, groups string) {
+	qs := r.URL.Query()
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		p.errorLog.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := hex.EncodeToString(b)
+
+	a := authorization{
+		clientID:      qs.Get("client_id"),
+		redirectURI:   qs.Get("redirect_uri"),
+		nonce:         qs.Get("nonce"),
+		codeChallenge: qs.Get("code_challenge"),
+		email:         strings.ToLower(strings.TrimSpace(email)),
+		name:          strings.TrimSpace(name),
+		expiry:        time.Now().Add(codeTTL),
+	}
+	for _, g := range strings.Split(groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			a.groups = append(a.groups, g)
+		}
+	}
+
+	p.mu.Lock()
+	p.codes[code] = a
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(a.redirectURI)
+	if err != nil {
+		http.Error(w, "redirect_uri is not a url", http.StatusBadRequest)
+		return
+	}
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", qs.Get("state"))
+	redirect.RawQuery = q.Encode()
+
+	p.infoLog.Printf("signed in %s, groups %v", a.email, a.groups)
+	http.Redirect(w, r, redirect.String(), http.StatusSeeOther)
+}
+
+// Token exchanges an authorization code for an id token
+func (p *Provider) Token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		p.tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// the client id and secret are form encoded before they go in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != p.config.ClientID ||
+		(p.config.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.config.ClientSecret)) != 1) {
+		p.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		p.tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// a code is good once, whatever happens next
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	a, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(a.expiry):
+		p.tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	case a.clientID != clientID || a.redirectURI != r.PostForm.Get("redirect_uri"):
+		p.tokenError(w, "invalid_grant", "code was issued for another client or redirect_uri")
+		return
+	case a.codeChallenge != "" && !verifierMatches(r.PostForm.Get("code_verifier"), a.codeChallenge):
+		p.tokenError(w, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	given, family, _ := strings.Cut(a.name, " ")
+	now := time.Now()
+
+	idToken, err := p.sign(map[string]interface{}{
+		"iss":            p.config.Issuer,
+		"sub":            "mock|" + a.email,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          a.nonce,
+		"email":          a.email,
+		"email_verified": p.config.User.EmailVerified,
+		"name":           a.name,
+		"given_name":     given,
+		"family_name":    family,
+		"groups":         a.groups,
+	})
+	if err != nil {
+		p.errorLog.Println(err)
+		p.tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": code + "-access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// verifierMatches reports whether a PKCE code verifier hashes to the S256 challenge
+func verifierMatches(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// sign makes a JWT of claims, signed with RS256
+func (p *Provider) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
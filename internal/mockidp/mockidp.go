@@ -0,0 +1,55 @@
+// Package mockidp is an OpenID Connect identity provider to sign in to the front end with single sign on, locally and
+// in tests. It signs in whoever its sign in form says, or, when Auto is set, the User of its config without asking
+package mockidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"sync"
+)
+
+// Config is what the provider calls itself, the client it serves and the user it signs in
+type Config struct {
+	Issuer       string // the url of the provider, as the client reaches it
+	ClientID     string
+	ClientSecret string // any secret is accepted when empty
+	Auto         bool   // sign in User without showing the sign in form
+	User         User
+}
+
+// User is the user the provider signs in, or fills the sign in form with
+type User struct {
+	Email         string
+	Name          string
+	Groups        string // comma separated
+	EmailVerified bool   // what the id token says of the email
+}
+
+// Provider is a running identity provider
+type Provider struct {
+	config   Config
+	infoLog  *log.Logger
+	errorLog *log.Logger
+	key      *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// New returns a provider with cfg, signing id tokens with a new key
+func New(cfg Config, infoLog, errorLog *log.Logger) (*Provider, error) {
+	// a new key every start; relying parties fetch it again when they see a key id they do not know
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:   cfg,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		key:      key,
+		codes:    make(map[string]authorization),
+	}, nil
+}
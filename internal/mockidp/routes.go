@@ -0,0 +1,20 @@
+package mockidp
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Routes returns the handler serving the provider's endpoints, discovery at /.well-known/openid-configuration
+func (p *Provider) Routes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Get("/.well-known/openid-configuration", p.Discovery)
+	mux.Get("/jwks", p.Keys)
+	mux.Get("/authorize", p.Authorize)
+	mux.Post("/authorize", p.Approve)
+	mux.Post("/token", p.Token)
+
+	return mux
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// AnalyticsFilter narrows the sales reports to a date range, and optionally to one currency
+type AnalyticsFilter struct {
+	From     time.Time
+	To       time.Time
+	Currency string
+	Interval string // day, week or month
+}
+
+// RevenuePoint is the revenue and number of orders for one period of a report
+type RevenuePoint struct {
+	Period  string `json:"period"`
+	Revenue int    `json:"revenue"`
+	Orders  int    `json:"orders"`
+}
+
+// WidgetSales is how much of one widget was sold
+type WidgetSales struct {
+	WidgetID int    `json:"widget_id"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	Revenue  int    `json:"revenue"`
+}
+
+// SalesSummary holds the totals for orders placed in a date range. Revenue and the average order value count only
+// the orders that are still paid for; what was refunded is reported on its own
+type SalesSummary struct {
+	Revenue            int     `json:"revenue"`
+	Orders             int     `json:"orders"`
+	AverageOrderValue  int     `json:"average_order_value"`
+	Refunded           int     `json:"refunded"`
+	RefundedAmount     int     `json:"refunded_amount"`
+	RefundRate         float64 `json:"refund_rate"`
+	NewCustomers       int     `json:"new_customers"`
+	ReturningCustomers int     `json:"returning_customers"`
+}
+
+// SubscriptionSummary holds the recurring revenue and churn of subscriptions in a date range
+type SubscriptionSummary struct {
+	Active    int     `json:"active"`
+	MRR       int     `json:"mrr"`
+	New       int     `json:"new"`
+	Cancelled int     `json:"cancelled"`
+	ChurnRate float64 `json:"churn_rate"`
+}
+
+// periodFormats maps a report interval to the date format, in each dialect, that buckets rows into it. SQLite has no
+// ISO weeks, so its weeks start on the first Monday of the year
+var periodFormats = map[string]map[driver.Dialect]string{
+	"day":   {driver.MySQL: "%Y-%m-%d", driver.Postgres: "YYYY-MM-DD", driver.SQLite: "%Y-%m-%d"},
+	"week":  {driver.MySQL: "%x-W%v", driver.Postgres: `IYYY-"W"IW`, driver.SQLite: "%Y-W%W"},
+	"month": {driver.MySQL: "%Y-%m", driver.Postgres: "YYYY-MM", driver.SQLite: "%Y-%m"},
+}
+
+// where returns the conditions and arguments restricting dateColumn to the filter's range, and
+// the transaction alias t to its currency
+func (f AnalyticsFilter) where(dateColumn string) (string, []interface{}) {
+	conditions := []string{fmt.Sprintf("%s >= ?", dateColumn), fmt.Sprintf("%s < ?", dateColumn)}
+	args := []interface{}{f.From, f.To}
+
+	if f.Currency != "" {
+		conditions = append(conditions, "t.currency = ?")
+		args = append(args, strings.ToLower(f.Currency))
+	}
+
+	return strings.Join(conditions, " and "), args
+}
+
+// GetRevenueByPeriod returns the cleared revenue and the number of orders for each day, week or month in the range.
+// Revenue counts every cleared transaction, so renewals of subscriptions and virtual terminal charges are included,
+// except those of orders refunded or cancelled since, as GetSalesSummary leaves them out
+func (m *DBModel) GetRevenueByPeriod(f AnalyticsFilter) ([]*RevenuePoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	formats, ok := periodFormats[f.Interval]
+	if !ok {
+		return nil, errors.New("interval must be day, week or month")
+	}
+
+	where, args := f.where("t.created_at")
+	query := fmt.Sprintf(`
+		select
+			%s as period,
+			coalesce(sum(t.amount), 0),
+			count(o.id)
+		from
+			transactions t
+			left join orders o on (o.transaction_id = t.id)
+		where
+			t.transaction_status_id = 2
+			and (o.id is null or o.status_id = 1)
+			and %s
+		group by
+			period
+		order by
+			period
+	`, m.Dialect.FormatDate("t.created_at", formats[m.Dialect.Name()]), where)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []*RevenuePoint
+	for rows.Next() {
+		var p RevenuePoint
+		err = rows.Scan(&p.Period, &p.Revenue, &p.Orders)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, &p)
+	}
+
+	return points, rows.Err()
+}
+
+// GetSalesSummary returns the totals for the orders placed in the range
+func (m *DBModel) GetSalesSummary(f AnalyticsFilter) (SalesSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var s SalesSummary
+	var cleared int
+
+	// an order is cleared while it is paid for, status 1, and refunded or cancelled after
+	where, args := f.where("o.created_at")
+	query := fmt.Sprintf(`
+		select
+			count(o.id),
+			coalesce(sum(case when o.status_id = 1 then 1 else 0 end), 0),
+			coalesce(sum(case when o.status_id = 1 then o.amount else 0 end), 0),
+			coalesce(sum(case when o.status_id = 2 then 1 else 0 end), 0),
+			coalesce(sum(case when o.status_id = 2 then o.amount else 0 end), 0)
+		from
+			orders o
+			left join transactions t on (o.transaction_id = t.id)
+		where
+			%s
+	`, where)
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.Orders, &cleared, &s.Revenue, &s.Refunded, &s.RefundedAmount)
+	if err != nil {
+		return s, err
+	}
+
+	if cleared > 0 {
+		s.AverageOrderValue = s.Revenue / cleared
+	}
+	if s.Orders > 0 {
+		s.RefundRate = float64(s.Refunded) / float64(s.Orders)
+	}
+
+	// a customer row is inserted with every order, so customers are told apart by email; a customer is new when
+	// their first order ever falls in the range
+	query = fmt.Sprintf(`
+		select
+			coalesce(sum(case when first_order.created_at >= ? then 1 else 0 end), 0),
+			coalesce(sum(case when first_order.created_at < ? then 1 else 0 end), 0)
+		from
+			(
+				select distinct lower(c.email) as email
+				from
+					orders o
+					left join customers c on (o.customer_id = c.id)
+					left join transactions t on (o.transaction_id = t.id)
+				where
+					%s
+			) buyers
+			inner join (
+				select lower(c.email) as email, min(o.created_at) as created_at
+				from
+					orders o
+					left join customers c on (o.customer_id = c.id)
+				group by
+					lower(c.email)
+			) first_order on (first_order.email = buyers.email)
+	`, where)
+
+	args = append([]interface{}{f.From, f.From}, args...)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&s.NewCustomers, &s.ReturningCustomers)
+	if err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// GetTopWidgets returns the widgets that brought in the most revenue in the range, best first
+func (m *DBModel) GetTopWidgets(f AnalyticsFilter, limit int) ([]*WidgetSales, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	where, args := f.where("o.created_at")
+	query := fmt.Sprintf(`
+		select
+			w.id, w.name, coalesce(sum(o.quantity), 0), coalesce(sum(o.amount), 0) as revenue
+		from
+			orders o
+			left join widgets w on (o.widget_id = w.id)
+			left join transactions t on (o.transaction_id = t.id)
+		where
+			o.status_id = 1
+			and %s
+		group by
+			w.id, w.name
+		order by
+			revenue desc
+		limit ?
+	`, where)
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var widgets []*WidgetSales
+	for rows.Next() {
+		var ws WidgetSales
+		err = rows.Scan(&ws.WidgetID, &ws.Name, &ws.Quantity, &ws.Revenue)
+		if err != nil {
+			return nil, err
+		}
+		widgets = append(widgets, &ws)
+	}
+
+	return widgets, rows.Err()
+}
+
+// GetSubscriptionSummary returns the monthly recurring revenue of the subscriptions active at the end of the range,
+// and the share of the subscriptions active at its start that were cancelled during it
+func (m *DBModel) GetSubscriptionSummary(f AnalyticsFilter) (SubscriptionSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var s SubscriptionSummary
+
+	// a cancelled subscription has status 3, and updated_at is when it was cancelled
+	query := `
+		select
+			coalesce(sum(case when o.created_at < ? and (o.status_id <> 3 or o.updated_at >= ?) then 1 else 0 end), 0),
+			coalesce(sum(case when o.created_at < ? and (o.status_id <> 3 or o.updated_at >= ?) then o.amount else 0 end), 0),
+			coalesce(sum(case when o.created_at >= ? and o.created_at < ? then 1 else 0 end), 0),
+			coalesce(sum(case when o.status_id = 3 and o.updated_at >= ? and o.updated_at < ? then 1 else 0 end), 0),
+			coalesce(sum(case when o.created_at < ? and (o.status_id <> 3 or o.updated_at >= ?) then 1 else 0 end), 0)
+		from
+			orders o
+			left join widgets w on (o.widget_id = w.id)
+			left join transactions t on (o.transaction_id = t.id)
+		where
+			w.is_recurring = true
+	`
+	args := []interface{}{
+		f.To, f.To,
+		f.To, f.To,
+		f.From, f.To,
+		f.From, f.To,
+		f.From, f.From,
+	}
+	if f.Currency != "" {
+		query += " and t.currency = ?"
+		args = append(args, strings.ToLower(f.Currency))
+	}
+
+	var activeAtStart int
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.Active, &s.MRR, &s.New, &s.Cancelled, &activeAtStart)
+	if err != nil {
+		return s, err
+	}
+
+	if activeAtStart > 0 {
+		s.ChurnRate = float64(s.Cancelled) / float64(activeAtStart)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/ahmedkhaeld/ecommerce/internal/migrate"
+	"github.com/ahmedkhaeld/ecommerce/migrations"
+)
+
+// newTestDB returns models over a migrated SQLite database of their own
+func newTestDB(t *testing.T) DBModel {
+	t.Helper()
+
+	conn, err := driver.OpenDB(driver.SQLite, t.TempDir()+"/widgets.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = (&migrate.Migrator{DB: conn, Dialect: driver.SQLite, FS: migrations.FS}).Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DBModel{DB: conn, Dialect: driver.SQLite}
+}
+
+// placeOrder inserts a cleared charge of amount, and an order of it with statusID
+func placeOrder(t *testing.T, m DBModel, amount, statusID int) {
+	t.Helper()
+
+	customerID, err := m.InsertCustomer(Customer{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnID, err := m.InsertTransaction(Transaction{Amount: amount, Currency: "cad", TransactionStatusID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.InsertOrder(Order{
+		WidgetID:      1,
+		TransactionID: txnID,
+		CustomerID:    customerID,
+		StatusID:      statusID,
+		Quantity:      1,
+		Amount:        amount,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevenueLeavesOutRefundedOrders(t *testing.T) {
+	m := newTestDB(t)
+
+	placeOrder(t, m, 1000, 1)
+	placeOrder(t, m, 2000, 1)
+	placeOrder(t, m, 500, 2) // refunded
+
+	f := AnalyticsFilter{
+		From:     time.Now().Add(-time.Hour),
+		To:       time.Now().Add(time.Hour),
+		Interval: "month",
+	}
+
+	summary, err := m.GetSalesSummary(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Revenue != 3000 || summary.RefundedAmount != 500 {
+		t.Errorf("got a summary of %d revenue and %d refunded, want 3000 and 500", summary.Revenue, summary.RefundedAmount)
+	}
+
+	points, err := m.GetRevenueByPeriod(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revenue, orders := 0, 0
+	for _, p := range points {
+		revenue += p.Revenue
+		orders += p.Orders
+	}
+	if revenue != summary.Revenue || orders != 2 {
+		t.Errorf("got a chart of %d revenue over %d orders, want %d over 2 as in the summary", revenue, orders, summary.Revenue)
+	}
+
+	// a virtual terminal charge has no order, and is revenue all the same
+	_, err = m.InsertTransaction(Transaction{Amount: 700, Currency: "cad", TransactionStatusID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err = m.GetRevenueByPeriod(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revenue = 0
+	for _, p := range points {
+		revenue += p.Revenue
+	}
+	if revenue != 3700 {
+		t.Errorf("got a chart of %d revenue with a terminal charge, want 3700", revenue)
+	}
+}
@@ -0,0 +1,418 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CustomerSummary is a customer with the totals of their orders
+type CustomerSummary struct {
+	Customer
+	Orders        int       `json:"orders"`
+	LifetimeValue int       `json:"lifetime_value"`
+	LastOrderAt   time.Time `json:"last_order_at"`
+}
+
+// EmailLog records an email we sent
+type EmailLog struct {
+	ID        int       `json:"id"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Template  string    `json:"template"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// customerValue selects the lifetime value of the customer aliased c: what their orders brought in, less refunds,
+// plus every renewal of their subscriptions
+const customerValue = `
+	coalesce((
+		select sum(o.amount) from orders o where o.customer_id = c.id and o.status_id <> 2
+	), 0) + coalesce((
+		select sum(t.amount)
+		from
+			subscription_invoices si
+			inner join orders o on (si.order_id = o.id)
+			inner join transactions t on (si.transaction_id = t.id)
+		where o.customer_id = c.id
+	), 0)`
+
+// GetCustomersPaginated returns one page of customers whose name or email contains search, most recent buyers first
+func (m *DBModel) GetCustomersPaginated(pageSize, page int, search string) ([]*CustomerSummary, int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	var q queryBuilder
+	if search != "" {
+		like := "%" + escapeLike(strings.ToLower(search)) + "%"
+		q.where(customerLike(m.Dialect), like, like)
+	}
+
+	query := fmt.Sprintf(`
+		select
+			c.id, c.first_name, c.last_name, c.email, c.created_at, c.updated_at,
+			(select count(o.id) from orders o where o.customer_id = c.id),
+			%s,
+			coalesce((select max(o.created_at) from orders o where o.customer_id = c.id), c.created_at) as last_order_at
+		from
+			customers c
+		%s
+		order by
+			last_order_at desc, c.id desc
+		limit ? offset ?
+	`, customerValue, q.clause())
+
+	rows, err := m.DB.QueryContext(ctx, query, append(q.args, pageSize, offset)...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	var customers []*CustomerSummary
+	for rows.Next() {
+		var c CustomerSummary
+		var lastOrderAt computedTime
+		err = rows.Scan(
+			&c.ID,
+			&c.FirstName,
+			&c.LastName,
+			&c.Email,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.Orders,
+			&c.LifetimeValue,
+			&lastOrderAt,
+		)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		c.LastOrderAt = lastOrderAt.Time
+		customers = append(customers, &c)
+	}
+
+	var totalRecords int
+	countRow := m.DB.QueryRowContext(ctx, "select count(c.id) from customers c "+q.clause(), q.args...)
+	err = countRow.Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+
+	return customers, lastPage, totalRecords, nil
+}
+
+// GetCustomer gets one customer by id, with the totals of their orders
+func (m *DBModel) GetCustomer(id int) (CustomerSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c CustomerSummary
+	var lastOrderAt computedTime
+
+	query := fmt.Sprintf(`
+		select
+			c.id, c.first_name, c.last_name, c.email, c.created_at, c.updated_at,
+			(select count(o.id) from orders o where o.customer_id = c.id),
+			%s,
+			coalesce((select max(o.created_at) from orders o where o.customer_id = c.id), c.created_at)
+		from
+			customers c
+		where
+			c.id = ?
+	`, customerValue)
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.FirstName,
+		&c.LastName,
+		&c.Email,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.Orders,
+		&c.LifetimeValue,
+		&lastOrderAt,
+	)
+	if err != nil {
+		return c, err
+	}
+	c.LastOrderAt = lastOrderAt.Time
+
+	return c, nil
+}
+
+// GetOrdersForCustomer gets every order of a customer, newest first. Widget.IsRecurring tells subscriptions apart
+func (m *DBModel) GetOrdersForCustomer(customerID int) ([]*Order, error) {
+	return m.GetOrdersForCustomers([]int{customerID})
+}
+
+// GetOrdersForCustomers gets every order of the customers with ids in one go, newest first
+func (m *DBModel) GetOrdersForCustomers(ids []int) ([]*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var q queryBuilder
+	q.whereIn("o.customer_id", ids)
+
+	query := fmt.Sprintf(`
+		select
+			o.id, o.widget_id, o.transaction_id, o.customer_id,
+			o.status_id, o.quantity, o.amount, o.created_at,
+			o.updated_at, w.id, w.name, w.is_recurring, t.id, t.amount, t.currency,
+			t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
+			t.bank_return_code, c.id, c.first_name, c.last_name, c.email,
+			o.fulfilment_status_id
+		from
+			orders o
+			left join widgets w on (o.widget_id = w.id)
+			left join transactions t on (o.transaction_id = t.id)
+			left join customers c on (o.customer_id = c.id)
+		%s
+		order by
+			o.created_at desc
+	`, q.clause())
+
+	rows, err := m.DB.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		var o Order
+		err = rows.Scan(
+			&o.ID,
+			&o.WidgetID,
+			&o.TransactionID,
+			&o.CustomerID,
+			&o.StatusID,
+			&o.Quantity,
+			&o.Amount,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+			&o.Widget.ID,
+			&o.Widget.Name,
+			&o.Widget.IsRecurring,
+			&o.Transaction.ID,
+			&o.Transaction.Amount,
+			&o.Transaction.Currency,
+			&o.Transaction.LastFour,
+			&o.Transaction.ExpiryMonth,
+			&o.Transaction.ExpiryYear,
+			&o.Transaction.PaymentIntent,
+			&o.Transaction.BankReturnCode,
+			&o.Customer.ID,
+			&o.Customer.FirstName,
+			&o.Customer.LastName,
+			&o.Customer.Email,
+			&o.FulfilmentStatusID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &o)
+	}
+
+	return orders, nil
+}
+
+// GetDuplicateCustomers gets the other customer rows with the same email as c. A row is inserted for every purchase,
+// so a returning buyer has one for each of their orders
+func (m *DBModel) GetDuplicateCustomers(c Customer) ([]*Customer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select
+			id, first_name, last_name, email, created_at, updated_at
+		from
+			customers
+		where
+			lower(email) = ? and id <> ?
+		order by
+			created_at
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, strings.ToLower(c.Email), c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []*Customer
+	for rows.Next() {
+		var dup Customer
+		err = rows.Scan(
+			&dup.ID,
+			&dup.FirstName,
+			&dup.LastName,
+			&dup.Email,
+			&dup.CreatedAt,
+			&dup.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, &dup)
+	}
+
+	return customers, nil
+}
+
+// EditCustomer updates the contact details of a customer
+func (m *DBModel) EditCustomer(c Customer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		update customers set
+			first_name = ?,
+			last_name = ?,
+			email = ?,
+			updated_at = ?
+		where
+			id = ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.FirstName,
+		c.LastName,
+		c.Email,
+		time.Now(),
+		c.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MergeCustomers moves every order of the customers in ids to the customer targetID, then deletes those customers.
+// It all happens in one database transaction, so a failure leaves nothing half merged
+func (m *DBModel) MergeCustomers(targetID int, ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var merge []int
+	for _, id := range ids {
+		if id != targetID {
+			merge = append(merge, id)
+		}
+	}
+	if len(merge) == 0 {
+		return errors.New("no customers to merge")
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "select count(id) from customers where id = ?", targetID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return fmt.Errorf("customer %d does not exist", targetID)
+	}
+
+	for _, id := range merge {
+		_, err = tx.ExecContext(ctx, "update orders set customer_id = ?, updated_at = ? where customer_id = ?",
+			targetID, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "delete from customers where id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "update customers set updated_at = ? where id = ?", time.Now(), targetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertEmailLog records an email we sent
+func (m *DBModel) InsertEmailLog(e EmailLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+		insert into email_logs (recipient, subject, template, created_at, updated_at)
+		values (?, ?, ?, ?, ?)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		strings.ToLower(e.Recipient),
+		e.Subject,
+		e.Template,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetEmailsSentTo gets the emails we sent to an address, newest first
+func (m *DBModel) GetEmailsSentTo(email string) ([]*EmailLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select
+			id, recipient, subject, template, created_at, updated_at
+		from
+			email_logs
+		where
+			recipient = ?
+		order by
+			created_at desc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, strings.ToLower(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*EmailLog
+	for rows.Next() {
+		var e EmailLog
+		err = rows.Scan(
+			&e.ID,
+			&e.Recipient,
+			&e.Subject,
+			&e.Template,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &e)
+	}
+
+	return emails, nil
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exportColumn is a column that can be exported, with the sql expression that selects it
+type exportColumn struct {
+	name string
+	expr string
+}
+
+// exportDataset describes one kind of record that can be exported
+type exportDataset struct {
+	from       string
+	where      string
+	dateColumn string
+	columns    []exportColumn
+}
+
+var orderExportColumns = []exportColumn{
+	{"id", "o.id"},
+	{"created_at", "o.created_at"},
+	{"status", "s.name"},
+	{"widget", "w.name"},
+	{"quantity", "o.quantity"},
+	{"amount", "o.amount"},
+	{"currency", "t.currency"},
+	{"last_four", "t.last_four"},
+	{"payment_intent", "t.payment_intent"},
+	{"customer_id", "c.id"},
+	{"first_name", "c.first_name"},
+	{"last_name", "c.last_name"},
+	{"email", "c.email"},
+}
+
+const orderExportFrom = `
+	orders o
+	left join statuses s on (o.status_id = s.id)
+	left join widgets w on (o.widget_id = w.id)
+	left join transactions t on (o.transaction_id = t.id)
+	left join customers c on (o.customer_id = c.id)`
+
+// exportDatasets lists what can be exported; only the columns listed here can ever be selected
+var exportDatasets = map[string]exportDataset{
+	"orders": {
+		from:       orderExportFrom,
+		where:      "w.is_recurring = false",
+		dateColumn: "o.created_at",
+		columns:    orderExportColumns,
+	},
+	"subscriptions": {
+		from:       orderExportFrom,
+		where:      "w.is_recurring = true",
+		dateColumn: "o.created_at",
+		columns:    orderExportColumns,
+	},
+	"transactions": {
+		from:       "transactions t left join transaction_statuses ts on (t.transaction_status_id = ts.id)",
+		dateColumn: "t.created_at",
+		columns: []exportColumn{
+			{"id", "t.id"},
+			{"created_at", "t.created_at"},
+			{"status", "ts.name"},
+			{"amount", "t.amount"},
+			{"currency", "t.currency"},
+			{"last_four", "t.last_four"},
+			{"expiry_month", "t.expiry_month"},
+			{"expiry_year", "t.expiry_year"},
+			{"bank_return_code", "t.bank_return_code"},
+			{"payment_intent", "t.payment_intent"},
+			{"payment_method", "t.payment_method"},
+		},
+	},
+	"customers": {
+		from:       "customers c",
+		dateColumn: "c.created_at",
+		columns: []exportColumn{
+			{"id", "c.id"},
+			{"first_name", "c.first_name"},
+			{"last_name", "c.last_name"},
+			{"email", "c.email"},
+			{"created_at", "c.created_at"},
+		},
+	},
+	"users": {
+		from:       "users u",
+		dateColumn: "u.created_at",
+		columns: []exportColumn{
+			{"id", "u.id"},
+			{"first_name", "u.first_name"},
+			{"last_name", "u.last_name"},
+			{"email", "u.email"},
+			{"created_at", "u.created_at"},
+		},
+	},
+}
+
+// ExportDatasets returns the name of every dataset that can be exported, with the columns it has
+func ExportDatasets() map[string][]string {
+	datasets := make(map[string][]string)
+	for name, ds := range exportDatasets {
+		for _, c := range ds.columns {
+			datasets[name] = append(datasets[name], c.name)
+		}
+	}
+	return datasets
+}
+
+// ExportHeader returns the names of the columns ExportRows would export for dataset and columns, or an error if the
+// dataset or one of the columns does not exist
+func ExportHeader(dataset string, columns []string) ([]string, error) {
+	selected, err := exportColumnsFor(dataset, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, c := range selected {
+		names = append(names, c.name)
+	}
+	return names, nil
+}
+
+// exportColumnsFor looks up the named columns of dataset; no names means every column
+func exportColumnsFor(dataset string, columns []string) ([]exportColumn, error) {
+	ds, ok := exportDatasets[dataset]
+	if !ok {
+		return nil, fmt.Errorf("unknown dataset %q", dataset)
+	}
+
+	if len(columns) == 0 {
+		return ds.columns, nil
+	}
+
+	var selected []exportColumn
+	for _, name := range columns {
+		found := false
+		for _, c := range ds.columns {
+			if c.name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q for %s", name, dataset)
+		}
+	}
+
+	return selected, nil
+}
+
+// ExportRows runs fn for every row of dataset created between from and to, oldest first, with the values of the
+// named columns formatted as strings. Rows are read one at a time, so fn may stream them out. An empty columns selects
+// every column, and a zero from or to leaves that end of the range open
+func (m *DBModel) ExportRows(ctx context.Context, dataset string, columns []string, from, to time.Time, fn func(row []string) error) error {
+	selected, err := exportColumnsFor(dataset, columns)
+	if err != nil {
+		return err
+	}
+	ds := exportDatasets[dataset]
+
+	var exprs, conditions []string
+	for _, c := range selected {
+		exprs = append(exprs, c.expr)
+	}
+
+	var args []interface{}
+	if ds.where != "" {
+		conditions = append(conditions, ds.where)
+	}
+	if !from.IsZero() {
+		conditions = append(conditions, ds.dateColumn+" >= ?")
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, ds.dateColumn+" < ?")
+		args = append(args, to)
+	}
+
+	query := fmt.Sprintf("select %s from %s", strings.Join(exprs, ", "), ds.from)
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+	query += fmt.Sprintf(" order by %s, %s", ds.dateColumn, ds.columns[0].expr)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]sql.NullString, len(selected))
+	dest := make([]interface{}, len(selected))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	row := make([]string, len(selected))
+
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			row[i] = v.String
+		}
+		err = fn(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
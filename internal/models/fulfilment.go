@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The fulfilment statuses of an order, as it makes its way from us to the customer
+const (
+	FulfilmentPending = iota + 1
+	FulfilmentProcessing
+	FulfilmentShipped
+	FulfilmentDelivered
+)
+
+// OrderStatus is where an order stands, as its customer is shown it
+type OrderStatus struct {
+	OrderID            int        `json:"order_id"`
+	Product            string     `json:"product"`
+	Quantity           int        `json:"quantity"`
+	Amount             int        `json:"amount"`
+	StatusID           int        `json:"status_id"`
+	Status             string     `json:"status"`
+	FulfilmentStatusID int        `json:"fulfilment_status_id"`
+	Fulfilment         string     `json:"fulfilment"`
+	IsRecurring        bool       `json:"is_recurring"`
+	PaymentCleared     bool       `json:"payment_cleared"`
+	InvoicedAt         *time.Time `json:"invoiced_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// GetOrderStatus returns where the order with id stands
+func (m *DBModel) GetOrderStatus(id int) (OrderStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s OrderStatus
+	var invoicedAt sql.NullTime
+
+	query := `
+		select
+			o.id, w.name, w.is_recurring, o.quantity, o.amount, o.status_id, s.name,
+			o.fulfilment_status_id, f.name, t.transaction_status_id = 2, o.invoiced_at,
+			o.created_at, o.updated_at
+		from
+			orders o
+			left join widgets w on (o.widget_id = w.id)
+			left join statuses s on (o.status_id = s.id)
+			left join fulfilment_statuses f on (o.fulfilment_status_id = f.id)
+			left join transactions t on (o.transaction_id = t.id)
+		where
+			o.id = ?`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&s.OrderID,
+		&s.Product,
+		&s.IsRecurring,
+		&s.Quantity,
+		&s.Amount,
+		&s.StatusID,
+		&s.Status,
+		&s.FulfilmentStatusID,
+		&s.Fulfilment,
+		&s.PaymentCleared,
+		&invoicedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return s, err
+	}
+
+	if invoicedAt.Valid {
+		s.InvoicedAt = &invoicedAt.Time
+	}
+
+	return s, nil
+}
+
+// GetAllFulfilmentStatuses returns the fulfilment statuses an order can be in, in the order it goes through them
+func (m *DBModel) GetAllFulfilmentStatuses() ([]Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var statuses []Status
+
+	rows, err := m.DB.QueryContext(ctx, "select id, name from fulfilment_statuses order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Status
+		err = rows.Scan(&s.ID, &s.Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, rows.Err()
+}
+
+// UpdateFulfilmentStatus moves the order with id to the fulfilment status with statusID
+func (m *DBModel) UpdateFulfilmentStatus(id, statusID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := "update orders set fulfilment_status_id = ?, updated_at = ? where id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, statusID, time.Now(), id)
+	return err
+}
+
+// MarkOrderInvoiced records that the invoice of the order with id was emailed to the customer
+func (m *DBModel) MarkOrderInvoiced(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := "update orders set invoiced_at = ?, updated_at = ? where id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), time.Now(), id)
+	return err
+}
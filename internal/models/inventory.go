@@ -0,0 +1,27 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LowInventoryLevel is how few of a widget are left in stock when it is running low
+const LowInventoryLevel = 3
+
+// ReduceInventory takes quantity of a widget out of stock, and returns the widget with what is left. Subscriptions
+// are not kept in stock, so their inventory is left as it is
+func (m *DBModel) ReduceInventory(widgetID, quantity int) (Widget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := fmt.Sprintf(`
+		update widgets set inventory_level = %s, updated_at = ?
+		where id = ? and is_recurring = false`, m.Dialect.Greatest("inventory_level - ?", "0"))
+	_, err := m.DB.ExecContext(ctx, stmt, quantity, time.Now(), widgetID)
+	if err != nil {
+		return Widget{}, err
+	}
+
+	return m.GetWidget(widgetID)
+}
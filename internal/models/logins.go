@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Failed sign ins are counted over loginWindow, per email and per ip address. From the third failure on an email,
+// each attempt has to wait twice as long as the last, up to maxLoginDelay; at AccountLockoutThreshold failures the
+// email is locked out for LockoutDuration, and so is an ip address at ipLockoutThreshold failures
+const (
+	loginWindow             = 15 * time.Minute
+	maxLoginDelay           = 30 * time.Second
+	AccountLockoutThreshold = 10
+	ipLockoutThreshold      = 50
+	LockoutDuration         = 15 * time.Minute
+)
+
+// LoginStatus says whether a sign in may be attempted now
+type LoginStatus struct {
+	Locked     bool          // too many failures; the email or ip address is locked out
+	RetryAfter time.Duration // how long to wait before trying again, zero when a sign in may be attempted now
+}
+
+// loginDelay returns how long to wait after the last of failures failed sign ins before the next attempt
+func loginDelay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	// doubling from a second passes the maximum at the eighth failure
+	if failures >= 8 {
+		return maxLoginDelay
+	}
+	return time.Second << (failures - 3)
+}
+
+// CheckLogin says whether a sign in as email from ip may be attempted now. It looks the same whether or not the
+// email has an account, so it cannot be used to find out
+func (m *DBModel) CheckLogin(email, ip string) (LoginStatus, error) {
+	var status LoginStatus
+
+	emailFailures, emailLast, err := m.countFailedLogins("email", strings.ToLower(email))
+	if err != nil {
+		return status, err
+	}
+
+	ipFailures, ipLast, err := m.countFailedLogins("ip_address", ip)
+	if err != nil {
+		return status, err
+	}
+
+	var until time.Time
+	switch {
+	case emailFailures >= AccountLockoutThreshold:
+		status.Locked = true
+		until = emailLast.Add(LockoutDuration)
+	case ipFailures >= ipLockoutThreshold:
+		status.Locked = true
+		until = ipLast.Add(LockoutDuration)
+	default:
+		until = emailLast.Add(loginDelay(emailFailures))
+	}
+
+	if wait := time.Until(until); wait > 0 {
+		status.RetryAfter = wait
+	} else {
+		status.Locked = false
+	}
+
+	return status, nil
+}
+
+// RecordFailedLogin records a failed sign in as email from ip, and reports whether it locked the email out
+func (m *DBModel) RecordFailedLogin(email, ip string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(email)
+
+	stmt := `insert into login_attempts (email, ip_address, created_at, updated_at) values (?, ?, ?, ?)`
+	_, err := m.DB.ExecContext(ctx, stmt, email, ip, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	// nothing older than the window is ever looked at again
+	_, err = m.DB.ExecContext(ctx, "delete from login_attempts where created_at < ?", time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return false, err
+	}
+
+	failures, _, err := m.countFailedLogins("email", email)
+	if err != nil {
+		return false, err
+	}
+
+	return failures == AccountLockoutThreshold, nil
+}
+
+// ClearFailedLogins forgets the failed sign ins as email, after a successful one or to unlock the account
+func (m *DBModel) ClearFailedLogins(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from login_attempts where email = ?", strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AccountLockedUntil returns when the lockout of email ends, or the zero time if it is not locked out
+func (m *DBModel) AccountLockedUntil(email string) (time.Time, error) {
+	failures, last, err := m.countFailedLogins("email", strings.ToLower(email))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if failures < AccountLockoutThreshold {
+		return time.Time{}, nil
+	}
+
+	until := last.Add(LockoutDuration)
+	if time.Now().After(until) {
+		return time.Time{}, nil
+	}
+
+	return until, nil
+}
+
+// countFailedLogins counts the failed sign ins in the window where column, email or ip_address, is value, and
+// returns when the last one was
+func (m *DBModel) countFailedLogins(column, value string) (int, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	var last computedTime
+
+	query := "select count(id), max(created_at) from login_attempts where " + column + " = ? and created_at > ?"
+	err := m.DB.QueryRowContext(ctx, query, value, time.Now().Add(-loginWindow)).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, last.Time, nil
+}
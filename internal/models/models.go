@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"strings"
 	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
 )
 
 // Models wrapper for all models
@@ -23,7 +26,34 @@ func NewModel(db *sql.DB) Models {
 
 // DBModel is the type for database connection values
 type DBModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect driver.Dialect // the kind of database DB is, MySQL when not set
+}
+
+// execer runs statements on a database, or in a transaction on one
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insert runs stmt, an insert into a table with an id column, and returns the id given to the row
+func (m *DBModel) insert(ctx context.Context, stmt string, args ...interface{}) (int64, error) {
+	return m.insertWith(ctx, m.DB, stmt, args...)
+}
+
+// insertWith is insert, run by db
+func (m *DBModel) insertWith(ctx context.Context, db execer, stmt string, args ...interface{}) (int64, error) {
+	var id int64
+	if m.Dialect.ReturningID() {
+		err := db.QueryRowContext(ctx, stmt+" returning id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
 
 /*
@@ -60,6 +90,8 @@ type Order struct {
 	Widget        Widget      `json:"widget"`
 	Transaction   Transaction `json:"transaction"`
 	Customer      Customer    `json:"customer"`
+	// FulfilmentStatusID is read by GetOrderByID and the paginated and per customer lists of orders
+	FulfilmentStatusID int `json:"fulfilment_status_id"`
 }
 
 // Status is the type for order statuses
@@ -96,13 +128,15 @@ type Transaction struct {
 
 // User is the type for users
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID               int       `json:"id"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	Password         string    `json:"password"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	SingleSignOn     bool      `json:"single_sign_on"`
+	CreatedAt        time.Time `json:"-"`
+	UpdatedAt        time.Time `json:"-"`
 }
 
 // Customer is the type for customers
@@ -155,11 +189,130 @@ func (m *DBModel) GetWidget(id int) (Widget, error) {
 	return widget, nil
 }
 
+// GetAllWidgets gets every widget, ordered by name
+func (m *DBModel) GetAllWidgets() ([]*Widget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var widgets []*Widget
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select 
+			id, name, description, inventory_level, price, coalesce(image, ''),
+		       is_recurring, plan_id,
+			created_at, updated_at
+		from 
+			widgets 
+		order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var widget Widget
+		err = rows.Scan(
+			&widget.ID,
+			&widget.Name,
+			&widget.Description,
+			&widget.InventoryLevel,
+			&widget.Price,
+			&widget.Image,
+			&widget.IsRecurring,
+			&widget.PlanID,
+			&widget.CreatedAt,
+			&widget.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		widgets = append(widgets, &widget)
+	}
+
+	return widgets, nil
+}
+
+// GetWidgetsByIDs gets the widgets with ids, in no particular order; ids that are not a widget are left out
+func (m *DBModel) GetWidgetsByIDs(ids []int) ([]*Widget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var q queryBuilder
+	q.whereIn("id", ids)
+
+	var widgets []*Widget
+
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf(`
+		select
+			id, name, description, inventory_level, price, coalesce(image, ''),
+			is_recurring, plan_id,
+			created_at, updated_at
+		from
+			widgets
+		%s`, q.clause()), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var widget Widget
+		err = rows.Scan(
+			&widget.ID,
+			&widget.Name,
+			&widget.Description,
+			&widget.InventoryLevel,
+			&widget.Price,
+			&widget.Image,
+			&widget.IsRecurring,
+			&widget.PlanID,
+			&widget.CreatedAt,
+			&widget.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		widgets = append(widgets, &widget)
+	}
+
+	return widgets, rows.Err()
+}
+
+// GetAllStatuses returns the statuses an order can be in: cleared, refunded or cancelled
+func (m *DBModel) GetAllStatuses() ([]Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var statuses []Status
+
+	rows, err := m.DB.QueryContext(ctx, "select id, name from statuses order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Status
+		err = rows.Scan(&s.ID, &s.Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, rows.Err()
+}
+
 // InsertTransaction insert new txn, and return its id
 func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return m.insertTransaction(ctx, m.DB, txn)
+}
+
+// insertTransaction inserts txn with db, and returns its id
+func (m *DBModel) insertTransaction(ctx context.Context, db execer, txn Transaction) (int, error) {
 	stmt := `
 		insert into transactions
 			(amount, currency, last_four, bank_return_code, expiry_month, expiry_year,
@@ -168,7 +321,7 @@ func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := m.DB.ExecContext(ctx, stmt,
+	id, err := m.insertWith(ctx, db, stmt,
 		txn.Amount,
 		txn.Currency,
 		txn.LastFour,
@@ -184,10 +337,6 @@ func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
 
 	return int(id), nil
 
@@ -205,7 +354,7 @@ func (m *DBModel) InsertOrder(order Order) (int, error) {
 		values (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := m.DB.ExecContext(ctx, stmt,
+	id, err := m.insert(ctx, stmt,
 		order.WidgetID,
 		order.TransactionID,
 		order.StatusID,
@@ -219,11 +368,6 @@ func (m *DBModel) InsertOrder(order Order) (int, error) {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
 	return int(id), nil
 }
 
@@ -237,7 +381,7 @@ func (m *DBModel) InsertCustomer(c Customer) (int, error) {
 			(first_name, last_name, email, created_at, updated_at)
 		values (?, ?, ?, ?, ?)`
 
-	result, err := m.DB.ExecContext(ctx, stmt,
+	id, err := m.insert(ctx, stmt,
 		c.FirstName,
 		c.LastName,
 		c.Email,
@@ -248,11 +392,6 @@ func (m *DBModel) InsertCustomer(c Customer) (int, error) {
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
 	return int(id), nil
 }
 
@@ -268,7 +407,7 @@ func (m *DBModel) GetUserByEmail(email string) (User, error) {
 
 	row := m.DB.QueryRowContext(ctx, `
 		select 
-			id, first_name, last_name, email, password, created_at, updated_at
+			id, first_name, last_name, email, password, oidc_subject is not null, created_at, updated_at
 		from  
 			users 
 		where email = ?`, email)
@@ -279,6 +418,7 @@ func (m *DBModel) GetUserByEmail(email string) (User, error) {
 		&u.LastName,
 		&u.Email,
 		&u.Password,
+		&u.SingleSignOn,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -343,7 +483,7 @@ func (m *DBModel) GetAllOrders() ([]*Order, error) {
 			left join widgets w on (o.widget_id = w.id)
 			left join transactions t on (o.transaction_id = t.id)
 			left join customers c on(o.customer_id = c.id) 
-		where w.is_recurring = 0
+		where w.is_recurring = false
 		order by o.created_at desc
 	`
 
@@ -388,85 +528,9 @@ func (m *DBModel) GetAllOrders() ([]*Order, error) {
 	return orders, nil
 }
 
-// GetAllOrdersPaginated returns a slice of subset of orders
-func (m *DBModel) GetAllOrdersPaginated(pageSize, page int) ([]*Order, int, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	offset := (page - 1) * pageSize
-	var orders []*Order
-	query := `
-		select 
-		   o.id, o.widget_id, o.transaction_id, o.customer_id, o.status_id, 
-		   o.quantity, o.amount, o.created_at, o.updated_at, 
-		   w.id, w.name,
-		   t.id, t.amount, t.currency, t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
-		   t.bank_return_code,
-		   c.id, c.first_name, c.last_name, c.email
-		from
-			orders o
-			left join widgets w on (o.widget_id = w.id)
-			left join transactions t on (o.transaction_id = t.id)
-			left join customers c on(o.customer_id = c.id) 
-		where w.is_recurring = 0
-		order by o.created_at desc
-		limit ? offset ?  
-	`
-
-	rows, err := m.DB.QueryContext(ctx, query, pageSize, offset)
-	if err != nil {
-		return nil, 0, 0, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var o Order
-		err = rows.Scan(
-			&o.ID,
-			&o.WidgetID,
-			&o.TransactionID,
-			&o.CustomerID,
-			&o.StatusID,
-			&o.Quantity,
-			&o.Amount,
-			&o.CreatedAt,
-			&o.UpdatedAt,
-			&o.Widget.ID,
-			&o.Widget.Name,
-			&o.Transaction.ID,
-			&o.Transaction.Amount,
-			&o.Transaction.Currency,
-			&o.Transaction.LastFour,
-			&o.Transaction.ExpiryMonth,
-			&o.Transaction.ExpiryYear,
-			&o.Transaction.PaymentIntent,
-			&o.Transaction.BankReturnCode,
-			&o.Customer.ID,
-			&o.Customer.FirstName,
-			&o.Customer.LastName,
-			&o.Customer.Email,
-		)
-		if err != nil {
-			return nil, 0, 0, err
-		}
-		orders = append(orders, &o)
-	}
-	// count the # of orders for widgets as is_recurring = 0
-	query = `
-		select count(o.id)
-		from orders o 
-		left join widgets w on (o.widget_id = w.id)
-		where 
-		w.is_recurring = 0
-	`
-	var totalRecords int
-	countRow := m.DB.QueryRowContext(ctx, query)
-	err = countRow.Scan(&totalRecords)
-	if err != nil {
-		return nil, 0, 0, err
-	}
-	lastPage := totalRecords / pageSize
-
-	return orders, lastPage, totalRecords, nil
+// GetAllOrdersPaginated returns a slice of subset of orders, narrowed and sorted by filter
+func (m *DBModel) GetAllOrdersPaginated(pageSize, page int, filter OrderFilter) ([]*Order, int, int, error) {
+	return m.getOrdersPaginated(false, pageSize, page, filter)
 }
 func (m *DBModel) GetAllSubscriptions() ([]*Order, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -487,7 +551,7 @@ func (m *DBModel) GetAllSubscriptions() ([]*Order, error) {
 			left join transactions t on (o.transaction_id = t.id)
 			left join customers c on (o.customer_id = c.id)
 		where
-			w.is_recurring = 1
+			w.is_recurring = true
 		order by 
 			o.created_at desc
 	`
@@ -534,36 +598,51 @@ func (m *DBModel) GetAllSubscriptions() ([]*Order, error) {
 	return orders, nil
 }
 
-// GetAllSubscriptionsPaginated returns a slice of a subset of subscriptions
-func (m *DBModel) GetAllSubscriptionsPaginated(pageSize, page int) ([]*Order, int, int, error) {
+// GetAllSubscriptionsPaginated returns a slice of a subset of subscriptions, narrowed and sorted by filter
+func (m *DBModel) GetAllSubscriptionsPaginated(pageSize, page int, filter OrderFilter) ([]*Order, int, int, error) {
+	return m.getOrdersPaginated(true, pageSize, page, filter)
+}
+
+// getOrdersPaginated returns one page of the orders for either recurring or one time widgets that match filter,
+// along with the last page and the total number of matching orders
+func (m *DBModel) getOrdersPaginated(recurring bool, pageSize, page int, filter OrderFilter) ([]*Order, int, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
 	offset := (page - 1) * pageSize
 
+	var q queryBuilder
+	q.where("w.is_recurring = ?", recurring)
+	filter.apply(&q, m.Dialect)
+
 	var orders []*Order
 
-	query := `
+	query := fmt.Sprintf(`
 	select
 		o.id, o.widget_id, o.transaction_id, o.customer_id, 
 		o.status_id, o.quantity, o.amount, o.created_at,
 		o.updated_at, w.id, w.name, t.id, t.amount, t.currency,
 		t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
-		t.bank_return_code, c.id, c.first_name, c.last_name, c.email
+		t.bank_return_code, c.id, c.first_name, c.last_name, c.email,
+		o.fulfilment_status_id
 		
 	from
 		orders o
 		left join widgets w on (o.widget_id = w.id)
 		left join transactions t on (o.transaction_id = t.id)
 		left join customers c on (o.customer_id = c.id)
-	where
-		w.is_recurring = 1
-	order by
-		o.created_at desc
+	%s
+	%s, o.id desc
 	limit ? offset ?
-	`
+	`, q.clause(), orderBy(orderSortColumns, filter.Sort, filter.Direction, "created_at"))
 
-	rows, err := m.DB.QueryContext(ctx, query, pageSize, offset)
+	rows, err := m.DB.QueryContext(ctx, query, append(q.args, pageSize, offset)...)
 	if err != nil {
 		return nil, 0, 0, err
 	}
@@ -595,6 +674,7 @@ func (m *DBModel) GetAllSubscriptionsPaginated(pageSize, page int) ([]*Order, in
 			&o.Customer.FirstName,
 			&o.Customer.LastName,
 			&o.Customer.Email,
+			&o.FulfilmentStatusID,
 		)
 		if err != nil {
 			return nil, 0, 0, err
@@ -602,17 +682,18 @@ func (m *DBModel) GetAllSubscriptionsPaginated(pageSize, page int) ([]*Order, in
 		orders = append(orders, &o)
 	}
 
-	query = `
+	query = fmt.Sprintf(`
 		select 
 			count(o.id)
 		from 
 			orders o
 			left join widgets w on (o.widget_id = w.id)
-		where
-			w.is_recurring = 1
-	`
+			left join transactions t on (o.transaction_id = t.id)
+			left join customers c on (o.customer_id = c.id)
+		%s
+	`, q.clause())
 	var totalRecords int
-	countRow := m.DB.QueryRowContext(ctx, query)
+	countRow := m.DB.QueryRowContext(ctx, query, q.args...)
 	err = countRow.Scan(&totalRecords)
 	if err != nil {
 		return nil, 0, 0, err
@@ -634,7 +715,8 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 			o.status_id, o.quantity, o.amount, o.created_at,
 			o.updated_at, w.id, w.name, t.id, t.amount, t.currency,
 			t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
-			t.bank_return_code, c.id, c.first_name, c.last_name, c.email
+			t.bank_return_code, c.id, c.first_name, c.last_name, c.email,
+			o.fulfilment_status_id
 		from
 			orders o
 			left join widgets w on (o.widget_id = w.id)
@@ -670,6 +752,7 @@ func (m *DBModel) GetOrderByID(id int) (Order, error) {
 		&o.Customer.FirstName,
 		&o.Customer.LastName,
 		&o.Customer.Email,
+		&o.FulfilmentStatusID,
 	)
 	if err != nil {
 		return o, err
@@ -682,9 +765,9 @@ func (m *DBModel) UpdateOrderStatus(id, statusID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := "update orders set status_id = ? where id = ?"
+	stmt := "update orders set status_id = ?, updated_at = ? where id = ?"
 
-	_, err := m.DB.ExecContext(ctx, stmt, statusID, id)
+	_, err := m.DB.ExecContext(ctx, stmt, statusID, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -700,7 +783,7 @@ func (m *DBModel) GetAllUsers() ([]*User, error) {
 
 	query := `
 		select
-			id, last_name, first_name, email, created_at, updated_at
+			id, last_name, first_name, email, two_factor_enabled, oidc_subject is not null, created_at, updated_at
 		from
 			users
 		order by
@@ -720,6 +803,8 @@ func (m *DBModel) GetAllUsers() ([]*User, error) {
 			&u.LastName,
 			&u.FirstName,
 			&u.Email,
+			&u.TwoFactorEnabled,
+			&u.SingleSignOn,
 			&u.CreatedAt,
 			&u.UpdatedAt,
 		)
@@ -741,7 +826,7 @@ func (m *DBModel) GetOneUser(id int) (User, error) {
 
 	query := `
 		select
-			id, last_name, first_name, email, created_at, updated_at
+			id, last_name, first_name, email, two_factor_enabled, oidc_subject is not null, created_at, updated_at
 		from
 			users
 		where id = ? 
@@ -754,6 +839,8 @@ func (m *DBModel) GetOneUser(id int) (User, error) {
 		&u.LastName,
 		&u.FirstName,
 		&u.Email,
+		&u.TwoFactorEnabled,
+		&u.SingleSignOn,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -0,0 +1,317 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrActiveSubscription is returned when erasing the data of a customer who still has a running subscription; it
+// would keep renewing with nobody to invoice, so it has to be cancelled first
+var ErrActiveSubscription = errors.New("the customer has an active subscription, cancel it before erasing their data")
+
+// TokenRecord is an authentication token as it is stored, without its hash
+type TokenRecord struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// PersonalData is everything we hold about one email address
+type PersonalData struct {
+	Email                string                 `json:"email"`
+	ExportedAt           time.Time              `json:"exported_at"`
+	Customers            []*Customer            `json:"customers"`
+	Orders               []*Order               `json:"orders"`
+	SubscriptionInvoices []*SubscriptionInvoice `json:"subscription_invoices"`
+	Emails               []*EmailLog            `json:"emails"`
+	User                 *User                  `json:"user,omitempty"`
+	Tokens               []*TokenRecord         `json:"tokens"`
+}
+
+// Erasure is the outcome of erasing the data of an email address
+type Erasure struct {
+	Pseudonym string   `json:"pseudonym"`
+	Customers int      `json:"customers"`
+	Orders    []*Order `json:"-"`
+}
+
+// HasData reports whether anything is held about the email
+func (p PersonalData) HasData() bool {
+	return len(p.Customers) > 0 || len(p.Emails) > 0 || p.User != nil || len(p.Tokens) > 0
+}
+
+// GetPersonalData collects everything held about email: the customer rows, their orders with the card details of the
+// transactions, the renewals of their subscriptions, the emails sent to them, and the user and tokens with that email
+func (m *DBModel) GetPersonalData(email string) (PersonalData, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	data := PersonalData{
+		Email:      email,
+		ExportedAt: time.Now(),
+	}
+
+	customers, err := m.getCustomersByEmail(email)
+	if err != nil {
+		return data, err
+	}
+	data.Customers = customers
+
+	for _, c := range customers {
+		orders, err := m.GetOrdersForCustomer(c.ID)
+		if err != nil {
+			return data, err
+		}
+		for _, o := range orders {
+			o.Transaction.PaymentMethod = ""
+			data.Orders = append(data.Orders, o)
+
+			invoices, err := m.GetSubscriptionInvoicesForOrder(o.ID)
+			if err != nil {
+				return data, err
+			}
+			data.SubscriptionInvoices = append(data.SubscriptionInvoices, invoices...)
+		}
+	}
+
+	data.Emails, err = m.GetEmailsSentTo(email)
+	if err != nil {
+		return data, err
+	}
+
+	user, err := m.GetUserByEmail(email)
+	if err == nil {
+		user.Password = ""
+		data.User = &user
+	}
+
+	data.Tokens, err = m.getTokensByEmail(email)
+	if err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+// ErasePersonalData pseudonymises everything held about email. Customer rows, the emails sent and the tokens get a
+// random address in place of the real one and lose their names, and the card details of the transactions are
+// cleared. Amounts, currencies and the stripe ids refunds need are kept, so the books still add up. It all happens in
+// one database transaction, and the erased orders are returned so their invoices can be redacted too
+func (m *DBModel) ErasePersonalData(email string) (Erasure, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var e Erasure
+
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return e, err
+	}
+	e.Pseudonym = fmt.Sprintf("erased-%s@erased.invalid", hex.EncodeToString(random))
+
+	customers, err := m.getCustomersByEmail(email)
+	if err != nil {
+		return e, err
+	}
+
+	for _, c := range customers {
+		orders, err := m.GetOrdersForCustomer(c.ID)
+		if err != nil {
+			return e, err
+		}
+		for _, o := range orders {
+			if o.Widget.IsRecurring && o.StatusID == 1 {
+				return e, ErrActiveSubscription
+			}
+		}
+		e.Orders = append(e.Orders, orders...)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer tx.Rollback()
+
+	for _, c := range customers {
+		_, err = tx.ExecContext(ctx, `
+			update transactions
+			set
+				last_four = '',
+				expiry_month = 0,
+				expiry_year = 0,
+				payment_method = '',
+				updated_at = ?
+			where
+				id in (select o.transaction_id from orders o where o.customer_id = ?)`,
+			time.Now(), c.ID)
+		if err != nil {
+			return e, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			update transactions
+			set
+				last_four = '',
+				expiry_month = 0,
+				expiry_year = 0,
+				payment_method = '',
+				updated_at = ?
+			where
+				id in (
+					select si.transaction_id
+					from subscription_invoices si inner join orders o on (si.order_id = o.id)
+					where o.customer_id = ?
+				)`,
+			time.Now(), c.ID)
+		if err != nil {
+			return e, err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"update customers set first_name = 'Erased', last_name = 'Customer', email = ?, updated_at = ? where id = ?",
+			e.Pseudonym, time.Now(), c.ID)
+		if err != nil {
+			return e, err
+		}
+	}
+	e.Customers = len(customers)
+
+	_, err = tx.ExecContext(ctx, "update email_logs set recipient = ?, updated_at = ? where recipient = ?",
+		e.Pseudonym, time.Now(), email)
+	if err != nil {
+		return e, err
+	}
+
+	_, err = tx.ExecContext(ctx, "update tokens set name = 'Erased', email = ?, updated_at = ? where lower(email) = ?",
+		e.Pseudonym, time.Now(), email)
+	if err != nil {
+		return e, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return e, err
+	}
+
+	for _, o := range e.Orders {
+		o.Customer.FirstName = "Erased"
+		o.Customer.LastName = "Customer"
+		o.Customer.Email = e.Pseudonym
+	}
+
+	return e, nil
+}
+
+// GetSubscriptionInvoicesForOrder gets the renewals of the subscription started by an order, with what each charged,
+// oldest first
+func (m *DBModel) GetSubscriptionInvoicesForOrder(orderID int) ([]*SubscriptionInvoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select
+			si.id, si.order_id, si.transaction_id, si.stripe_invoice_id, si.period_start, si.period_end,
+			t.amount, si.created_at, si.updated_at
+		from
+			subscription_invoices si
+			inner join transactions t on (si.transaction_id = t.id)
+		where
+			si.order_id = ?
+		order by
+			si.period_start
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []*SubscriptionInvoice
+	for rows.Next() {
+		var si SubscriptionInvoice
+		err = rows.Scan(
+			&si.ID,
+			&si.OrderID,
+			&si.TransactionID,
+			&si.StripeInvoiceID,
+			&si.PeriodStart,
+			&si.PeriodEnd,
+			&si.Amount,
+			&si.CreatedAt,
+			&si.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, &si)
+	}
+
+	return invoices, nil
+}
+
+// getCustomersByEmail gets every customer row with email
+func (m *DBModel) getCustomersByEmail(email string) ([]*Customer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select
+			id, first_name, last_name, email, created_at, updated_at
+		from
+			customers
+		where
+			lower(email) = ?
+		order by
+			id`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []*Customer
+	for rows.Next() {
+		var c Customer
+		err = rows.Scan(&c.ID, &c.FirstName, &c.LastName, &c.Email, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, &c)
+	}
+
+	return customers, nil
+}
+
+// getTokensByEmail gets the tokens issued to email
+func (m *DBModel) getTokensByEmail(email string) ([]*TokenRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		"select id, name, email, created_at, expiry from tokens where lower(email) = ? order by id", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*TokenRecord
+	for rows.Next() {
+		var t TokenRecord
+		err = rows.Scan(&t.ID, &t.Name, &t.Email, &t.CreatedAt, &t.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// queryBuilder collects the conditions of a where clause together with their arguments. Conditions are
+// written by us with ? placeholders; values supplied by a user only ever go in as arguments
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// where adds a condition, and the arguments for its placeholders
+func (q *queryBuilder) where(condition string, args ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+// whereIn adds a condition that column is one of ids
+func (q *queryBuilder) whereIn(column string, ids []int) {
+	if len(ids) == 0 {
+		q.where("1 = 0")
+		return
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	q.where(fmt.Sprintf("%s in (%s)", column, placeholders), args...)
+}
+
+// clause returns the conditions joined into a where clause, or an empty string when there are none
+func (q *queryBuilder) clause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return "where " + strings.Join(q.conditions, " and ")
+}
+
+// orderBy returns an order by clause for the column named sort, looked up in columns so that only known columns
+// can be sorted on. An unknown sort falls back to fallback, and any direction but asc sorts descending
+func orderBy(columns map[string]string, sort, direction, fallback string) string {
+	column, ok := columns[sort]
+	if !ok {
+		column = columns[fallback]
+	}
+
+	if strings.ToLower(direction) == "asc" {
+		return fmt.Sprintf("order by %s asc", column)
+	}
+	return fmt.Sprintf("order by %s desc", column)
+}
+
+// OrderFilter narrows and sorts a list of orders; zero values are ignored
+type OrderFilter struct {
+	StatusID      int       `json:"status_id"`
+	Customer      string    `json:"customer"` // part of the customer's name or email
+	WidgetID      int       `json:"widget_id"`
+	MinAmount     int       `json:"min_amount"`
+	MaxAmount     int       `json:"max_amount"`
+	From          time.Time `json:"-"`
+	To            time.Time `json:"-"`
+	LastFour      string    `json:"last_four"`
+	PaymentIntent string    `json:"payment_intent"`
+	Sort          string    `json:"sort"`
+	Direction     string    `json:"direction"`
+}
+
+// orderSortColumns are the columns a list of orders can be sorted on
+var orderSortColumns = map[string]string{
+	"id":         "o.id",
+	"created_at": "o.created_at",
+	"amount":     "o.amount",
+	"status":     "o.status_id",
+	"customer":   "c.last_name",
+	"widget":     "w.name",
+}
+
+// apply adds the filter's conditions, written for dialect, to q
+func (f OrderFilter) apply(q *queryBuilder, dialect driver.Dialect) {
+	if f.StatusID > 0 {
+		q.where("o.status_id = ?", f.StatusID)
+	}
+	if f.Customer != "" {
+		like := "%" + escapeLike(strings.ToLower(f.Customer)) + "%"
+		q.where(customerLike(dialect), like, like)
+	}
+	if f.WidgetID > 0 {
+		q.where("o.widget_id = ?", f.WidgetID)
+	}
+	if f.MinAmount > 0 {
+		q.where("o.amount >= ?", f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		q.where("o.amount <= ?", f.MaxAmount)
+	}
+	if !f.From.IsZero() {
+		q.where("o.created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q.where("o.created_at < ?", f.To)
+	}
+	if f.LastFour != "" {
+		q.where("t.last_four = ?", f.LastFour)
+	}
+	if f.PaymentIntent != "" {
+		q.where("t.payment_intent = ?", f.PaymentIntent)
+	}
+}
+
+// escapeLike escapes the characters that are wildcards in a like pattern, with !, as the condition customerLike
+// returns expects. Backslash is not an escape character everywhere, and MySQL and PostgreSQL disagree on how to
+// write it in a string
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// customerLike returns the condition matching the email or the full name of customer c against a like pattern, given
+// twice, escaped by escapeLike
+func customerLike(dialect driver.Dialect) string {
+	name := dialect.Concat("c.first_name", "' '", "c.last_name")
+	return fmt.Sprintf("(lower(c.email) like ? escape '!' or lower(%s) like ? escape '!')", name)
+}
+
+// timeFormats are the layouts SQLite's driver writes times in, and SQLite itself writes current_timestamp in
+var timeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// computedTime scans a time the database worked out, with max or coalesce, that may be null. MySQL and PostgreSQL
+// hand those back as times, but SQLite only knows a column holds times from how it is declared, so hands back the text
+// it keeps them as
+type computedTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements the sql.Scanner interface
+func (t *computedTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time, t.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range timeFormats {
+			parsed, err := time.Parse(layout, v)
+			if err == nil {
+				t.Time, t.Valid = parsed, true
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot read %q as a time", v)
+	default:
+		return fmt.Errorf("cannot read %T as a time", value)
+	}
+}
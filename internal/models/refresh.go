@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned for a refresh token that was already exchanged. Only a copy of it can be
+// presented again, so the sign in it belongs to has been revoked
+var ErrRefreshTokenReused = errors.New("refresh token used twice")
+
+// refreshReuseGrace is how soon after a refresh token was exchanged it is turned away without revoking its sign in;
+// two tabs refreshing at once both present it, and the slower one should take the tokens the faster one stored
+const refreshReuseGrace = 30 * time.Second
+
+// RefreshToken is exchanged, once, for a new access token and a new refresh token. The tokens issued from one sign
+// in make up a family
+type RefreshToken struct {
+	PlainText string    `json:"token"`
+	UserID    int       `json:"-"`
+	Family    string    `json:"-"`
+	Scopes    []string  `json:"-"` // of the access tokens it is exchanged for
+	Hash      []byte    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// NewTokenFamily returns a new random family for the tokens of a sign in
+func NewTokenFamily() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateRefreshToken creates a refresh token in family that lasts for ttl, for access tokens with scopes
+func GenerateRefreshToken(userID int, family string, ttl time.Duration, scopes ...string) (*RefreshToken, error) {
+	t := &RefreshToken{
+		UserID: userID,
+		Family: family,
+		Scopes: scopes,
+		Expiry: time.Now().Add(ttl),
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	t.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(t.PlainText))
+	t.Hash = hash[:]
+	return t, nil
+}
+
+// InsertRefreshToken saves a refresh token, and deletes those that have expired
+func (m *DBModel) InsertRefreshToken(t *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from refresh_tokens where expiry < ?", time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into refresh_tokens (user_id, family, scopes, token_hash, expiry, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?)`
+	_, err = m.DB.ExecContext(ctx, stmt,
+		t.UserID, t.Family, strings.Join(t.Scopes, " "), t.Hash, t.Expiry, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UseRefreshToken exchanges an unexpired refresh token, so it cannot be exchanged again, and returns it. When it was
+// exchanged already, its whole family is revoked and ErrRefreshTokenReused returned
+func (m *DBModel) UseRefreshToken(token string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := sha256.Sum256([]byte(token))
+
+	var t RefreshToken
+	var scopes string
+	var usedAt sql.NullTime
+
+	query := `select user_id, family, scopes, token_hash, expiry, used_at from refresh_tokens where token_hash = ?` +
+		m.Dialect.ForUpdate()
+	err = tx.QueryRowContext(ctx, query, tokenHash[:]).Scan(&t.UserID, &t.Family, &scopes, &t.Hash, &t.Expiry, &usedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+
+	if usedAt.Valid {
+		if time.Since(usedAt.Time) < refreshReuseGrace {
+			return nil, sql.ErrNoRows
+		}
+
+		err = revokeTokenFamily(ctx, tx, t.Family)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(t.Expiry) {
+		return nil, sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, "update refresh_tokens set used_at = ?, updated_at = ? where token_hash = ?",
+		time.Now(), time.Now(), tokenHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// RevokeTokenFamily revokes every access and refresh token issued from one sign in
+func (m *DBModel) RevokeTokenFamily(family string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = revokeTokenFamily(ctx, tx, family)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeTokenFamily deletes the tokens of family within tx
+func revokeTokenFamily(ctx context.Context, tx *sql.Tx, family string) error {
+	if family == "" {
+		return errors.New("no token family given")
+	}
+
+	_, err := tx.ExecContext(ctx, "delete from tokens where family = ?", family)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from refresh_tokens where family = ?", family)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OIDCIdentity is who an identity provider says signed in
+type OIDCIdentity struct {
+	Issuer    string
+	Subject   string
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// GetUserForOIDC gets the user an identity provider signed in. A user is linked to their identity the first time,
+// by email, and found by it from then on; when no user has the email, one is created if create is true. The user's
+// name is kept as the identity provider has it
+func (m *DBModel) GetUserForOIDC(id OIDCIdentity, create bool) (User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int
+
+	query := "select id from users where oidc_issuer = ? and oidc_subject = ?"
+	err := m.DB.QueryRowContext(ctx, query, id.Issuer, id.Subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		userID, err = m.linkOIDCUser(ctx, id, create)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	stmt := "update users set first_name = ?, last_name = ?, updated_at = ? where id = ?"
+	_, err = m.DB.ExecContext(ctx, stmt, id.FirstName, id.LastName, time.Now(), userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	return m.GetOneUser(userID)
+}
+
+// linkOIDCUser links the user with the email of an identity that no user is linked to yet, creating the user if
+// there is none and create is true
+func (m *DBModel) linkOIDCUser(ctx context.Context, id OIDCIdentity, create bool) (int, error) {
+	email := strings.ToLower(id.Email)
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, "select id from users where email = ? and oidc_subject is null", email).Scan(&userID)
+	if err == nil {
+		stmt := "update users set oidc_issuer = ?, oidc_subject = ?, updated_at = ? where id = ?"
+		_, err = m.DB.ExecContext(ctx, stmt, id.Issuer, id.Subject, time.Now(), userID)
+		return userID, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) || !create {
+		return 0, err
+	}
+
+	// the user signs in through the identity provider, never with a password, so give them one no one knows. It is
+	// 32 random bytes, which no cost makes any harder to guess, and a costly hash would eat the deadline above
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(b)), bcrypt.MinCost)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		insert into users (first_name, last_name, email, password, oidc_issuer, oidc_subject, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
+`
+	newID, err := m.insert(ctx, stmt,
+		id.FirstName, id.LastName, email, hash, id.Issuer, id.Subject, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
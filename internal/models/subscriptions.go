@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// SubscriptionInvoice links a renewal charge for a subscription to the order that started the subscription
+type SubscriptionInvoice struct {
+	ID              int       `json:"id"`
+	OrderID         int       `json:"order_id"`
+	TransactionID   int       `json:"transaction_id"`
+	StripeInvoiceID string    `json:"stripe_invoice_id"`
+	PeriodStart     time.Time `json:"period_start"`
+	PeriodEnd       time.Time `json:"period_end"`
+	Amount          int       `json:"amount"` // what the renewal charged, when read with its transaction
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+// GetOrderBySubscriptionID gets the order that started a subscription; the stripe subscription id is stored as the
+// payment intent of the order's transaction when the customer subscribes
+func (m *DBModel) GetOrderBySubscriptionID(subscriptionID string) (Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var o Order
+
+	query := `
+		select
+			o.id, o.widget_id, o.transaction_id, o.customer_id,
+			o.status_id, o.quantity, o.amount, o.created_at,
+			o.updated_at, w.id, w.name, t.id, t.amount, t.currency,
+			t.last_four, t.expiry_month, t.expiry_year, t.payment_intent,
+			t.bank_return_code, c.id, c.first_name, c.last_name, c.email
+		from
+			orders o
+			left join widgets w on (o.widget_id = w.id)
+			left join transactions t on (o.transaction_id = t.id)
+			left join customers c on (o.customer_id = c.id)
+		where
+			t.payment_intent = ?
+			and w.is_recurring = true
+		order by
+			o.id
+		limit 1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, subscriptionID)
+
+	err := row.Scan(
+		&o.ID,
+		&o.WidgetID,
+		&o.TransactionID,
+		&o.CustomerID,
+		&o.StatusID,
+		&o.Quantity,
+		&o.Amount,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+		&o.Widget.ID,
+		&o.Widget.Name,
+		&o.Transaction.ID,
+		&o.Transaction.Amount,
+		&o.Transaction.Currency,
+		&o.Transaction.LastFour,
+		&o.Transaction.ExpiryMonth,
+		&o.Transaction.ExpiryYear,
+		&o.Transaction.PaymentIntent,
+		&o.Transaction.BankReturnCode,
+		&o.Customer.ID,
+		&o.Customer.FirstName,
+		&o.Customer.LastName,
+		&o.Customer.Email,
+	)
+	if err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// RecordStripeEvent records that the stripe event with eventID has been handled, and reports whether it had not been
+// already. Stripe delivers an event again when it is not sure we got it
+func (m *DBModel) RecordStripeEvent(eventID, eventType string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.recordStripeEvent(ctx, m.DB, eventID, eventType)
+}
+
+// recordStripeEvent is RecordStripeEvent, run by db
+func (m *DBModel) recordStripeEvent(ctx context.Context, db execer, eventID, eventType string) (bool, error) {
+	stmt := m.Dialect.InsertIgnore(`insert into stripe_events (event_id, event_type, created_at) values (?, ?, ?)`)
+
+	result, err := db.ExecContext(ctx, stmt, eventID, eventType, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RecordSubscriptionRenewal records the transaction of a renewal charge, the subscription invoice linking it to the
+// order, and the stripe event that told us of it, all or none of them. It records nothing, and reports false, when
+// the event or the invoice has been recorded already, so a webhook delivered twice does not charge the books twice
+func (m *DBModel) RecordSubscriptionRenewal(eventID string, txn Transaction, si SubscriptionInvoice) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	recorded, err := m.recordStripeEvent(ctx, tx, eventID, "invoice.payment_succeeded")
+	if err != nil || !recorded {
+		return false, err
+	}
+
+	// the same invoice may come in another event
+	var count int
+	err = tx.QueryRowContext(ctx,
+		"select count(id) from subscription_invoices where stripe_invoice_id = ?", si.StripeInvoiceID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, tx.Commit()
+	}
+
+	si.TransactionID, err = m.insertTransaction(ctx, tx, txn)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `
+		insert into subscription_invoices
+			(order_id, transaction_id, stripe_invoice_id, period_start, period_end,
+			created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = m.insertWith(ctx, tx, stmt,
+		si.OrderID,
+		si.TransactionID,
+		si.StripeInvoiceID,
+		si.PeriodStart,
+		si.PeriodEnd,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
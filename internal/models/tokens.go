@@ -4,35 +4,81 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base32"
 	"log"
+	"strings"
 	"time"
 )
 
 // ScopeAuthentication
 //when working with an api, there are different kinds of scope
 // a good practice to identify scope for some particular part of code
+// A token signing a user in has ScopeAuthentication, which allows everything; an API key has some of the others
 const (
 	ScopeAuthentication = "authentication"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopeRefundsWrite   = "refunds:write"
+	ScopeCustomersRead  = "customers:read"
+	ScopeCustomersWrite = "customers:write"
 )
 
+// APIKeyScopes are the scopes an API key can be given
+var APIKeyScopes = []string{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeRefundsWrite,
+	ScopeCustomersRead,
+	ScopeCustomersWrite,
+}
+
 // Token type for authentication tokens
 type Token struct {
-	PlainText string    `json:"token"`
-	UserID    int64     `json:"-"`
-	Hash      []byte    `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID         int        `json:"id"`
+	PlainText  string     `json:"token,omitempty"`
+	UserID     int64      `json:"-"`
+	Name       string     `json:"name"`
+	Hash       []byte     `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scopes     []string   `json:"scopes"`
+	Family     string     `json:"-"` // the sign in a token, and the tokens it was refreshed into, came from
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
 }
 
+// HasScope reports whether the token allows what scope does
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAuthentication {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKeyScope reports whether an API key can be given scope
+func IsAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// lastUsedResolution is how stale the last use of a token may get, so that not every request has to write it
+const lastUsedResolution = time.Minute
+
 // GenerateToken creates a token that last for ttl
-//take in user id, time to life, and scope
+//take in user id, time to life, and scopes
 // returns a pointer to Token and potentially an error
-func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+func GenerateToken(userID int, ttl time.Duration, scopes ...string) (*Token, error) {
 	token := &Token{
-		UserID: int64(userID),
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    int64(userID),
+		Expiry:    time.Now().Add(ttl),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
 	}
 	// make sure token is secured, by assigning random bytes
 	randomBytes := make([]byte, 16)
@@ -47,48 +93,58 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 	return token, nil
 }
 
+// InsertToken saves a token issued to u. The user's other tokens are kept, so signing in on one device does not sign
+// out another; expired tokens, of any user, are deleted
 func (m *DBModel) InsertToken(t *Token, u User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// delete existing tokens, no need to keep the old tokens when user login again
-	stmt := `delete from tokens where user_id = ?`
-	_, err := m.DB.ExecContext(ctx, stmt, u.ID)
+	stmt := `delete from tokens where expiry < ?`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now())
 	if err != nil {
 		return err
 	}
 
-	stmt = `insert into tokens (user_id, name, email, token_hash, expiry, created_at, updated_at)
-			values (?, ?, ?, ?, ?, ?, ?)`
+	stmt = `insert into tokens (user_id, name, email, token_hash, expiry, label, scopes, family, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = m.DB.ExecContext(ctx, stmt,
+	id, err := m.insert(ctx, stmt,
 		u.ID,
 		u.LastName,
 		u.Email,
 		t.Hash,
 		t.Expiry,
-		time.Now(),
+		t.Name,
+		strings.Join(t.Scopes, " "),
+		t.Family,
+		t.CreatedAt,
 		time.Now(),
 	)
-
 	if err != nil {
 		return err
 	}
 
+	t.ID = int(id)
+
 	return nil
 }
 
-func (m *DBModel) GetUserForToken(token string) (*User, error) {
+// GetToken gets an unexpired token, and the user it was issued to, by its plain text, and records that it was used
+func (m *DBModel) GetToken(token string) (*User, *Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	tokenHash := sha256.Sum256([]byte(token))
 
 	var user User
+	var t Token
+	var scopes string
+	var lastUsed sql.NullTime
 
 	query := ` 
 		select 
-			u.id, u.first_name, u.last_name, u.email
+			u.id, u.first_name, u.last_name, u.email,
+			t.id, t.user_id, t.label, t.expiry, t.scopes, t.family, t.created_at, t.last_used_at
 		from
 			users u
 			inner join tokens t on (u.id = t.user_id)
@@ -102,11 +158,117 @@ func (m *DBModel) GetUserForToken(token string) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Email,
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Expiry,
+		&scopes,
+		&t.Family,
+		&t.CreatedAt,
+		&lastUsed,
 	)
 	if err != nil {
 		log.Println(err)
+		return nil, nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > lastUsedResolution {
+		now := time.Now()
+		_, err = m.DB.ExecContext(ctx, "update tokens set last_used_at = ? where id = ?", now, t.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.LastUsedAt = &now
+	}
+
+	return &user, &t, nil
+}
+
+// GetTokensForUser gets the unexpired tokens issued to a user, newest first. A sign in is refreshed into a new token
+// every so often, so only the newest token of each sign in is returned
+func (m *DBModel) GetTokensForUser(userID int) ([]*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select
+			id, user_id, label, expiry, scopes, family, created_at, last_used_at
+		from
+			tokens
+		where
+			user_id = ? and expiry > ?
+		order by
+			id desc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var tokens []*Token
+	families := make(map[string]bool)
+	for rows.Next() {
+		var t Token
+		var scopes string
+		var lastUsed sql.NullTime
+
+		err = rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.Expiry,
+			&scopes,
+			&t.Family,
+			&t.CreatedAt,
+			&lastUsed,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Family != "" {
+			if families[t.Family] {
+				continue
+			}
+			families[t.Family] = true
+		}
+
+		t.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, &t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteToken revokes a token issued to a user, along with the rest of its sign in
+func (m *DBModel) DeleteToken(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var family string
+	err := m.DB.QueryRowContext(ctx, "select family from tokens where id = ? and user_id = ?", id, userID).Scan(&family)
+	if err != nil {
+		return err
+	}
+
+	if family != "" {
+		return m.RevokeTokenFamily(family)
+	}
+
+	_, err = m.DB.ExecContext(ctx, "delete from tokens where id = ?", id)
+	if err != nil {
+		return err
+	}
 
-	return &user, nil
+	return nil
 }
@@ -0,0 +1,275 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// SettingRequireTwoFactor is the setting that, when "1", makes every user set up two factor authentication
+const SettingRequireTwoFactor = "require_two_factor"
+
+// TwoFactor is the two factor authentication of a user. Secret is stored as it is given, so the caller encrypts it,
+// and it is set but not Enabled while the user is part way through setting it up. LastStep is the time step of the
+// last code from their app that was accepted
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// GetTwoFactor gets the two factor authentication of a user
+func (m *DBModel) GetTwoFactor(userID int) (TwoFactor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tf TwoFactor
+	row := m.DB.QueryRowContext(ctx,
+		"select two_factor_secret, two_factor_enabled, two_factor_last_step from users where id = ?", userID)
+	err := row.Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		return tf, err
+	}
+
+	return tf, nil
+}
+
+// SetTwoFactorSecret stores the secret a user is setting up; it is not used until EnableTwoFactor
+func (m *DBModel) SetTwoFactorSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update users set two_factor_secret = ?, two_factor_enabled = false, two_factor_last_step = 0, updated_at = ?
+			where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EnableTwoFactor turns on two factor authentication for a user, replacing their recovery codes with codes
+func (m *DBModel) EnableTwoFactor(userID int, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "update users set two_factor_enabled = true, updated_at = ? where id = ?", time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from recovery_codes where user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range codes {
+		hash := sha256.Sum256([]byte(c))
+		_, err = tx.ExecContext(ctx,
+			"insert into recovery_codes (user_id, code_hash, created_at, updated_at) values (?, ?, ?, ?)",
+			userID, hash[:], time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTwoFactor turns off two factor authentication for a user, and forgets their secret, recovery codes and
+// trusted devices
+func (m *DBModel) DisableTwoFactor(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"update users set two_factor_secret = '', two_factor_enabled = false, two_factor_last_step = 0, updated_at = ? where id = ?",
+		time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from recovery_codes where user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from trusted_devices where user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTwoFactorStep records that a code from the user's app for time step step was accepted, and reports whether no
+// code for it, or a later step, was accepted before; the check and the write are one statement, so of two requests
+// with the same code only one gets true
+func (m *DBModel) UseTwoFactorStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update users set two_factor_last_step = ?, updated_at = ? where id = ? and two_factor_last_step < ?`
+	result, err := m.DB.ExecContext(ctx, stmt, step, time.Now(), userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UseRecoveryCode reports whether code is one of the user's recovery codes, and if so uses it up
+func (m *DBModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(code))
+	result, err := m.DB.ExecContext(ctx, "delete from recovery_codes where user_id = ? and code_hash = ?", userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns how many recovery codes a user has left
+func (m *DBModel) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, "select count(id) from recovery_codes where user_id = ?", userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// InsertTrustedDevice remembers a device for a user for ttl, and returns the token the device proves itself with
+func (m *DBModel) InsertTrustedDevice(userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token))
+
+	stmt := `insert into trusted_devices (user_id, token_hash, expiry, created_at, updated_at)
+			values (?, ?, ?, ?, ?)`
+	_, err = m.DB.ExecContext(ctx, stmt, userID, hash[:], time.Now().Add(ttl), time.Now(), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// TrustedDevice reports whether token belongs to a device the user asked us to remember, and that has not expired
+func (m *DBModel) TrustedDevice(userID int, token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(token))
+
+	var count int
+	err := m.DB.QueryRowContext(ctx,
+		"select count(id) from trusted_devices where user_id = ? and token_hash = ? and expiry > ?",
+		userID, hash[:], time.Now()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetSetting gets the value of a setting, or an empty string if it has never been set
+func (m *DBModel) GetSetting(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value string
+	err := m.DB.QueryRowContext(ctx, "select value from settings where name = ?", name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// SetSetting sets the value of a setting
+func (m *DBModel) SetSetting(name, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := m.Dialect.Upsert(`
+		insert into settings (name, value, created_at, updated_at)
+		values (?, ?, ?, ?)`, []string{"name"}, "value", "updated_at")
+	_, err := m.DB.ExecContext(ctx, stmt, name, value, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TwoFactorSetupRequired reports whether a user must set up two factor authentication before doing anything else:
+// it is required of everyone, they have not turned it on, and they do not sign in through single sign on
+func (m *DBModel) TwoFactorSetupRequired(userID int) (bool, error) {
+	required, err := m.GetSetting(SettingRequireTwoFactor)
+	if err != nil {
+		return false, err
+	}
+	if required != "1" {
+		return false, nil
+	}
+
+	user, err := m.GetOneUser(userID)
+	if err != nil {
+		return false, err
+	}
+	// the identity provider a single sign on user signs in through has its own second factor
+	if user.SingleSignOn {
+		return false, nil
+	}
+
+	return !user.TwoFactorEnabled, nil
+}
@@ -0,0 +1,357 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint of another system that is sent the events it wants to hear about
+type Webhook struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"-"` // encrypted; deliveries are signed with it
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Wants reports whether the webhook is sent events of eventType
+func (w *Webhook) Wants(eventType string) bool {
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is an event on its way to a webhook, and how it is getting on
+type WebhookDelivery struct {
+	ID            int               `json:"id"`
+	WebhookID     int               `json:"webhook_id"`
+	EventKey      string            `json:"-"`
+	EventType     string            `json:"event_type"`
+	Payload       string            `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	AttemptLog    []*WebhookAttempt `json:"attempt_log"`
+}
+
+// WebhookAttempt is one try at making a delivery, and how the endpoint answered
+type WebhookAttempt struct {
+	ID         int       `json:"id"`
+	DeliveryID int       `json:"delivery_id"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"`
+	DurationMS int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// InsertWebhook registers a webhook and returns its id
+func (m *DBModel) InsertWebhook(w Webhook) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into webhooks (url, event_types, secret, active, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?)`
+
+	id, err := m.insert(ctx, stmt,
+		w.URL,
+		strings.Join(w.EventTypes, " "),
+		w.Secret,
+		w.Active,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetAllWebhooks returns every webhook, the most recently registered first
+func (m *DBModel) GetAllWebhooks() ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhooks []*Webhook
+
+	query := `
+		select id, url, event_types, secret, active, created_at, updated_at
+		from webhooks
+		order by id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w Webhook
+		var eventTypes string
+		err = rows.Scan(&w.ID, &w.URL, &eventTypes, &w.Secret, &w.Active, &w.CreatedAt, &w.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		w.EventTypes = strings.Fields(eventTypes)
+		webhooks = append(webhooks, &w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns the webhook with id
+func (m *DBModel) GetWebhook(id int) (Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var w Webhook
+	var eventTypes string
+
+	query := `
+		select id, url, event_types, secret, active, created_at, updated_at
+		from webhooks
+		where id = ?`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&w.ID, &w.URL, &eventTypes, &w.Secret, &w.Active, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return w, err
+	}
+	w.EventTypes = strings.Fields(eventTypes)
+
+	return w, nil
+}
+
+// SetWebhookActive pauses the webhook with id, or has it sent events again
+func (m *DBModel) SetWebhookActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "update webhooks set active = ?, updated_at = ? where id = ?",
+		active, time.Now(), id)
+	return err
+}
+
+// DeleteWebhook deletes the webhook with id, along with its deliveries
+func (m *DBModel) DeleteWebhook(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from webhooks where id = ?", id)
+	return err
+}
+
+// InsertWebhookDelivery queues a delivery to be made now. It reports false, and queues nothing, when the webhook
+// already has a delivery of the same event, as it has when more than one process heard about it
+func (m *DBModel) InsertWebhookDelivery(d WebhookDelivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := m.Dialect.InsertIgnore(`insert into webhook_deliveries
+			(webhook_id, event_key, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at)
+			values (?, ?, ?, ?, ?, 0, ?, ?, ?)`)
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		d.WebhookID,
+		d.EventKey,
+		d.EventType,
+		d.Payload,
+		DeliveryPending,
+		time.Now(),
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose next attempt is due, the longest waiting first
+func (m *DBModel) GetDueWebhookDeliveries(limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, webhook_id, event_key, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at
+		from webhook_deliveries
+		where status = ? and next_attempt_at <= ?
+		order by next_attempt_at
+		limit ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, DeliveryPending, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// ClaimWebhookDelivery puts off the next attempt of a due delivery by lease, so no other process makes it at the same
+// time. It reports whether the delivery was still due, and is now ours to make
+func (m *DBModel) ClaimWebhookDelivery(id int, lease time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update webhook_deliveries set next_attempt_at = ?, updated_at = ?
+			where id = ? and status = ? and next_attempt_at <= ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, now.Add(lease), now, id, DeliveryPending, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RecordWebhookAttempt logs an attempt at a delivery, and moves the delivery on to status. A delivery still pending
+// is tried again at nextAttempt
+func (m *DBModel) RecordWebhookAttempt(a WebhookAttempt, status string, nextAttempt *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		insert into webhook_attempts (delivery_id, status_code, error, duration_ms, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?)`,
+		a.DeliveryID, a.StatusCode, a.Error, a.DurationMS, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update webhook_deliveries set status = ?, attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		where id = ?`,
+		status, nextAttempt, time.Now(), a.DeliveryID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWebhookDeliveries returns the latest limit deliveries to the webhook with webhookID, with their attempts
+func (m *DBModel) GetWebhookDeliveries(webhookID, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, webhook_id, event_key, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at
+		from webhook_deliveries
+		where webhook_id = ?
+		order by id desc
+		limit ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := scanWebhookDeliveries(rows)
+	rows.Close()
+	if err != nil || len(deliveries) == 0 {
+		return deliveries, err
+	}
+
+	byID := make(map[int]*WebhookDelivery)
+	for _, d := range deliveries {
+		d.AttemptLog = []*WebhookAttempt{}
+		byID[d.ID] = d
+	}
+
+	query = `
+		select a.id, a.delivery_id, a.status_code, a.error, a.duration_ms, a.created_at
+		from webhook_attempts a
+		where a.delivery_id in (
+			select id from (
+				select id from webhook_deliveries where webhook_id = ? order by id desc limit ?
+			) latest
+		)
+		order by a.id`
+
+	rows, err = m.DB.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a WebhookAttempt
+		err = rows.Scan(&a.ID, &a.DeliveryID, &a.StatusCode, &a.Error, &a.DurationMS, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := byID[a.DeliveryID]; ok {
+			d.AttemptLog = append(d.AttemptLog, &a)
+		}
+	}
+
+	return deliveries, rows.Err()
+}
+
+// ReplayWebhookDelivery has a delivery made again now, with a fresh set of attempts. Its earlier attempts stay in
+// the log
+func (m *DBModel) ReplayWebhookDelivery(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+			where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, DeliveryPending, time.Now(), time.Now(), id)
+	return err
+}
+
+// scanWebhookDeliveries reads the deliveries in rows
+func scanWebhookDeliveries(rows *sql.Rows) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttemptAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventKey, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&nextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if nextAttemptAt.Valid {
+			d.NextAttemptAt = &nextAttemptAt.Time
+		}
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
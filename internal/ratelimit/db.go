@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// bucketTTL is how long a bucket no one has taken from is kept in the database; rates should refill well within it
+const bucketTTL = 24 * time.Hour
+
+// DBStore keeps buckets in the rate_limits table, so that every instance of a server shares them
+type DBStore struct {
+	DB      *sql.DB
+	Dialect driver.Dialect
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+// Take takes a token from the bucket at key. The bucket's row is locked while it is updated, so two instances cannot
+// both take its last token
+func (s *DBStore) Take(key string, rate Rate) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.sweep(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	stmt := s.Dialect.InsertIgnore(`insert into rate_limits (bucket_key, tokens, refilled_at) values (?, ?, ?)`)
+	_, err = tx.ExecContext(ctx, stmt, key, rate.Requests, now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	var tokens float64
+	var refilled int64
+
+	query := `select tokens, refilled_at from rate_limits where bucket_key = ?` + s.Dialect.ForUpdate()
+	err = tx.QueryRowContext(ctx, query, key).Scan(&tokens, &refilled)
+	if err != nil {
+		return 0, err
+	}
+
+	tokens, wait := take(tokens, time.Unix(0, refilled), now, rate)
+
+	stmt = `update rate_limits set tokens = ?, refilled_at = ? where bucket_key = ?`
+	_, err = tx.ExecContext(ctx, stmt, tokens, now.UnixNano(), key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return wait, nil
+}
+
+// sweep deletes, at most once every sweepInterval, the buckets no one has taken from for bucketTTL
+func (s *DBStore) sweep(ctx context.Context) error {
+	s.mu.Lock()
+	if time.Since(s.swept) < sweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.swept = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.DB.ExecContext(ctx, "delete from rate_limits where refilled_at < ?", time.Now().Add(-bucketTTL).UnixNano())
+	return err
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in memory, so each instance of a server has its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	refilled time.Time
+	full     time.Time // when the bucket is full again, and can be forgotten
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// Take takes a token from the bucket at key
+func (s *MemoryStore) Take(key string, rate Rate) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Requests), refilled: now}
+		s.buckets[key] = b
+	}
+
+	var wait time.Duration
+	b.tokens, wait = take(b.tokens, b.refilled, now, rate)
+	b.refilled = now
+	b.full = now.Add(rate.Per)
+
+	return wait, nil
+}
@@ -0,0 +1,69 @@
+// Package ratelimit limits how often a client may make requests, with a token bucket for each client: a bucket holds
+// up to Rate.Requests tokens, a request takes one, and tokens come back at Rate.Requests every Rate.Per
+package ratelimit
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+)
+
+// Rate is how many requests are allowed every Per; that many may also come all at once
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// PerMinute returns a rate of n requests a minute
+func PerMinute(n int) Rate {
+	return Rate{Requests: n, Per: time.Minute}
+}
+
+// Store keeps the buckets. Take takes a token from the bucket at key, and returns zero if there was one, or how long
+// to wait until there is
+type Store interface {
+	Take(key string, rate Rate) (time.Duration, error)
+}
+
+// sweepInterval is how often stores forget buckets that are full again
+const sweepInterval = time.Minute
+
+// take refills a bucket that held tokens at refilled for the time since, then takes a token from it. It returns the
+// tokens left, and how long to wait if there were none to take
+func take(tokens float64, refilled, now time.Time, rate Rate) (float64, time.Duration) {
+	perSecond := float64(rate.Requests) / rate.Per.Seconds()
+
+	tokens = math.Min(float64(rate.Requests), tokens+now.Sub(refilled).Seconds()*perSecond)
+	if tokens >= 1 {
+		return tokens - 1, 0
+	}
+
+	return tokens, time.Duration((1 - tokens) / perSecond * float64(time.Second))
+}
+
+// ClientIP returns the ip address a request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// New returns the store called name: memory, or db to share the buckets of every instance through db, a database of
+// the given dialect
+func New(name string, db *sql.DB, dialect driver.Dialect) (Store, error) {
+	switch name {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "db":
+		return &DBStore{DB: db, Dialect: dialect}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", name)
+	}
+}
@@ -0,0 +1,108 @@
+package secureheaders
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxReportSize is the largest body of reports we read
+const maxReportSize = 64 << 10
+
+// Report is a violation of the Content Security Policy that a browser reported
+type Report struct {
+	DocumentURI       string
+	BlockedURI        string
+	ViolatedDirective string
+	SourceFile        string
+	LineNumber        int
+	Sample            string
+	Disposition       string // enforce, or report when the policy is report only
+}
+
+// legacyReport is what browsers send to a report-uri, as application/csp-report
+type legacyReport struct {
+	Body struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ScriptSample       string `json:"script-sample"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// apiReport is one of the reports browsers send to a report-to group, as application/reports+json
+type apiReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Sample             string `json:"sample"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+// ReadReports reads the violation reports a browser posted, in either of the formats browsers send them in
+func ReadReports(w http.ResponseWriter, r *http.Request) ([]Report, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReportSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var reports []apiReport
+		err = json.Unmarshal(body, &reports)
+		if err != nil {
+			return nil, err
+		}
+
+		var out []Report
+		for _, rep := range reports {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			out = append(out, Report{
+				DocumentURI:       rep.Body.DocumentURL,
+				BlockedURI:        rep.Body.BlockedURL,
+				ViolatedDirective: rep.Body.EffectiveDirective,
+				SourceFile:        rep.Body.SourceFile,
+				LineNumber:        rep.Body.LineNumber,
+				Sample:            rep.Body.Sample,
+				Disposition:       rep.Body.Disposition,
+			})
+		}
+		return out, nil
+	}
+
+	var rep legacyReport
+	err = json.Unmarshal(body, &rep)
+	if err != nil {
+		return nil, err
+	}
+	if rep.Body.DocumentURI == "" {
+		return nil, errors.New("not a csp report")
+	}
+
+	directive := rep.Body.EffectiveDirective
+	if directive == "" {
+		directive = rep.Body.ViolatedDirective
+	}
+
+	return []Report{{
+		DocumentURI:       rep.Body.DocumentURI,
+		BlockedURI:        rep.Body.BlockedURI,
+		ViolatedDirective: directive,
+		SourceFile:        rep.Body.SourceFile,
+		LineNumber:        rep.Body.LineNumber,
+		Sample:            rep.Body.ScriptSample,
+		Disposition:       rep.Body.Disposition,
+	}}, nil
+}
@@ -0,0 +1,131 @@
+// Package secureheaders sets the headers that tell browsers to lock down the pages we serve: a Content Security
+// Policy with a nonce for our own scripts, HSTS, and policies on framing, referrers and sniffing. It also reads the
+// violation reports browsers send back
+package secureheaders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NonceSource, among the sources of a directive, is replaced with the nonce of the request
+const NonceSource = "'nonce'"
+
+// ReportGroup is the name the Reporting-Endpoints header gives the address reports go to
+const ReportGroup = "csp"
+
+// Directive is one directive of a Content Security Policy, such as script-src, and the sources it allows
+type Directive struct {
+	Name    string
+	Sources []string
+}
+
+// Policy is the set of headers a server sends with every response
+type Policy struct {
+	CSP               []Directive   // the Content Security Policy; none is sent when it is empty
+	ReportOnly        bool          // report violations of the CSP without blocking them
+	ReportURI         string        // where browsers send reports of violations, none are sent when it is empty
+	HSTS              time.Duration // how long browsers should only use https, no HSTS header is sent when it is zero
+	FrameOptions      string        // X-Frame-Options, for browsers that do not know frame-ancestors
+	ReferrerPolicy    string
+	PermissionsPolicy string
+}
+
+type contextKey string
+
+const nonceContextKey = contextKey("cspNonce")
+
+// Handler sets the headers of the policy on every response, and gives each request a new nonce
+func (p Policy) Handler(next http.Handler) http.Handler {
+	usesNonce := false
+	for _, d := range p.CSP {
+		for _, s := range d.Sources {
+			usesNonce = usesNonce || s == NonceSource
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+
+		if len(p.CSP) > 0 {
+			var nonce string
+			if usesNonce {
+				var err error
+				nonce, err = newNonce()
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), nonceContextKey, nonce))
+			}
+
+			header := "Content-Security-Policy"
+			if p.ReportOnly {
+				header = "Content-Security-Policy-Report-Only"
+			}
+			h.Set(header, p.csp(nonce))
+
+			if p.ReportURI != "" {
+				h.Set("Reporting-Endpoints", fmt.Sprintf(`%s="%s"`, ReportGroup, p.ReportURI))
+			}
+		}
+
+		if p.HSTS > 0 {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(p.HSTS.Seconds())))
+		}
+		if p.FrameOptions != "" {
+			h.Set("X-Frame-Options", p.FrameOptions)
+		}
+		if p.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", p.ReferrerPolicy)
+		}
+		if p.PermissionsPolicy != "" {
+			h.Set("Permissions-Policy", p.PermissionsPolicy)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Nonce returns the nonce Handler gave the request, for the script tags of the page it is answered with
+func Nonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceContextKey).(string)
+	return nonce
+}
+
+// csp writes out the Content Security Policy with nonce in it
+func (p Policy) csp(nonce string) string {
+	var directives []string
+
+	for _, d := range p.CSP {
+		sources := make([]string, 0, len(d.Sources))
+		for _, s := range d.Sources {
+			if s == NonceSource {
+				s = fmt.Sprintf("'nonce-%s'", nonce)
+			}
+			sources = append(sources, s)
+		}
+		directives = append(directives, strings.TrimSpace(d.Name+" "+strings.Join(sources, " ")))
+	}
+
+	if p.ReportURI != "" {
+		directives = append(directives, "report-uri "+p.ReportURI, "report-to "+ReportGroup)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
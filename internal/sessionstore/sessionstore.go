@@ -0,0 +1,71 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/driver"
+	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/v2"
+)
+
+// cleanupInterval is how often expired sessions are deleted
+const cleanupInterval = 5 * time.Minute
+
+// New returns a store keeping sessions in the sessions table of db, a database of the given dialect
+func New(db *sql.DB, dialect driver.Dialect) scs.Store {
+	if dialect.Name() == driver.MySQL {
+		return mysqlstore.New(db)
+	}
+
+	s := &SQLStore{DB: db, Dialect: dialect}
+	go s.cleanup()
+	return s
+}
+
+// SQLStore keeps sessions in the sessions table of a PostgreSQL or SQLite database. Expiry times are written and
+// compared in UTC
+type SQLStore struct {
+	DB      *sql.DB
+	Dialect driver.Dialect
+}
+
+// Find returns the data of the session with token, and whether there is one that has not expired
+func (s *SQLStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	err := s.DB.QueryRow("select data from sessions where token = ? and expiry > ?", token, time.Now().UTC()).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit saves the data of the session with token, to expire at expiry
+func (s *SQLStore) Commit(token string, b []byte, expiry time.Time) error {
+	stmt := s.Dialect.Upsert("insert into sessions (token, data, expiry) values (?, ?, ?)", []string{"token"}, "data", "expiry")
+	_, err := s.DB.Exec(stmt, token, b, expiry.UTC())
+	return err
+}
+
+// Delete deletes the session with token
+func (s *SQLStore) Delete(token string) error {
+	_, err := s.DB.Exec("delete from sessions where token = ?", token)
+	return err
+}
+
+// cleanup deletes the expired sessions every cleanupInterval
+func (s *SQLStore) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := s.DB.Exec("delete from sessions where expiry < ?", time.Now().UTC())
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
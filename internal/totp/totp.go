@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is how long each code is valid for, and Digits how many digits a code has; both are what authenticator
+// apps assume when an otpauth url does not say otherwise
+const (
+	Period = 30 * time.Second
+	Digits = 6
+)
+
+// skew is how many periods either side of now a code is still accepted, to allow for clocks that drift
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded the way authenticator apps expect it
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URL returns the otpauth url an authenticator app scans from a qr code to add the secret for account
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), v.Encode())
+}
+
+// Code returns the code for secret at time t, as RFC 6238 computes it with HMAC-SHA1
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+	return code(key, uint64(Step(t))), nil
+}
+
+// Validate reports whether code is the code for secret at time t, or one period either side of it, and returns the
+// time step it is the code for. A code for lastStep, the step of the last code accepted for secret, or for one before
+// it is rejected, so a code that was seen once cannot be used again
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	for i := -skew; i <= skew; i++ {
+		at := t.Add(time.Duration(i) * Period)
+		step := Step(at)
+		if step <= lastStep {
+			continue
+		}
+		expected, err := Code(secret, at)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Step returns the time step, the count of periods since the Unix epoch, that the code at time t is for
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// code computes the HOTP value of key for counter, RFC 4226
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// GenerateRecoveryCodes returns n random single use codes, for signing in when the authenticator app is lost
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		random := make([]byte, 5)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(encoding.EncodeToString(random))
+		codes[i] = c[:4] + "-" + c[4:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode puts a recovery code as typed into the form GenerateRecoveryCodes hands it out in
+func NormalizeRecoveryCode(c string) string {
+	c = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(c))
+	if len(c) != 8 {
+		return c
+	}
+	return c[:4] + "-" + c[4:]
+}
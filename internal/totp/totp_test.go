@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsACodeUsedBefore(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(secret, code, now, 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("got step %d, %v for the current code, want %d, true", step, ok, Step(now))
+	}
+
+	// the same code again, even within the period it is valid for
+	if _, ok := Validate(secret, code, now, step); ok {
+		t.Error("a code was accepted a second time")
+	}
+	if _, ok := Validate(secret, code, now.Add(Period), step); ok {
+		t.Error("a code was accepted a second time in the next period")
+	}
+
+	// an older code, still within the skew, once a newer one was accepted
+	previous, err := Code(secret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, previous, now, step); ok {
+		t.Error("a code from before the last one accepted was accepted")
+	}
+
+	// the next code is fine
+	next, err := Code(secret, now.Add(Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := Validate(secret, next, now.Add(Period), step); !ok || got != step+1 {
+		t.Errorf("got step %d, %v for the next code, want %d, true", got, ok, step+1)
+	}
+}
+
+func TestCode(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238, cut to six digits
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range tests {
+		got, err := Code(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %s at %d, want %s", got, unix, want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package webhooks delivers the events of the shop to the endpoints of other systems, like an ERP or a warehouse,
+// that admins register for them. Each delivery is signed with the secret of its webhook, retried with backoff until
+// the endpoint takes it, and every attempt is logged
+package webhooks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ahmedkhaeld/ecommerce/internal/encryption"
+	"github.com/ahmedkhaeld/ecommerce/internal/events"
+	"github.com/ahmedkhaeld/ecommerce/internal/models"
+	"github.com/ahmedkhaeld/ecommerce/internal/urlsigner"
+)
+
+// headers sent with every delivery, besides those of the signature
+const (
+	HeaderEvent    = "X-Webhook-Event"
+	HeaderDelivery = "X-Webhook-Delivery"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is given up on
+	MaxAttempts = 10
+
+	// firstRetry is how long after the first failed attempt the next is made; each wait is twice the one before
+	firstRetry = 30 * time.Second
+
+	// maxRetry is the longest wait between two attempts
+	maxRetry = 6 * time.Hour
+
+	// pollInterval is how often the dispatcher looks for deliveries that are due
+	pollInterval = 5 * time.Second
+
+	// claimLease is how long a delivery being made is kept from the other processes
+	claimLease = 2 * time.Minute
+
+	// requestTimeout is how long an endpoint has to answer
+	requestTimeout = 10 * time.Second
+
+	// batchSize is how many due deliveries are picked up at once, and workers how many are made at the same time
+	batchSize = 50
+	workers   = 4
+)
+
+// Dispatcher queues the events webhooks want, and delivers them
+type Dispatcher struct {
+	DB       models.DBModel
+	Key      []byte // the key the secrets of the webhooks are encrypted with
+	Client   *http.Client
+	ErrorLog *log.Logger
+	wake     chan struct{}
+}
+
+// New returns a dispatcher that delivers with a client that does not follow redirects; an endpoint that moved has to
+// be registered again
+func New(db models.DBModel, key []byte, errorLog *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		DB:  db,
+		Key: key,
+		Client: &http.Client{
+			Timeout: requestTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ErrorLog: errorLog,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues e for every active webhook that wants its type. Every process that hears about e may queue it; each
+// webhook is only sent it once
+func (d *Dispatcher) Enqueue(e events.Event) {
+	webhooks, err := d.DB.GetAllWebhooks()
+	if err != nil {
+		d.ErrorLog.Println(err)
+		return
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		d.ErrorLog.Println(err)
+		return
+	}
+
+	queued := false
+	for _, w := range webhooks {
+		if !w.Active || !w.Wants(e.Type) {
+			continue
+		}
+
+		inserted, err := d.DB.InsertWebhookDelivery(models.WebhookDelivery{
+			WebhookID: w.ID,
+			EventKey:  eventKey(e),
+			EventType: e.Type,
+			Payload:   string(payload),
+		})
+		if err != nil {
+			d.ErrorLog.Println(err)
+			continue
+		}
+		queued = queued || inserted
+	}
+
+	if queued {
+		d.Wake()
+	}
+}
+
+// Wake has the dispatcher look for due deliveries now, rather than when it next would
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run makes the deliveries that are due, as they fall due, for as long as the process runs
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue makes the deliveries that are due, a few at a time
+func (d *Dispatcher) deliverDue() {
+	for {
+		due, err := d.DB.GetDueWebhookDeliveries(batchSize)
+		if err != nil {
+			d.ErrorLog.Println(err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for _, delivery := range due {
+			claimed, err := d.DB.ClaimWebhookDelivery(delivery.ID, claimLease)
+			if err != nil {
+				d.ErrorLog.Println(err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(delivery *models.WebhookDelivery) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				d.deliver(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery, logs it, and works out what becomes of the delivery
+func (d *Dispatcher) deliver(delivery *models.WebhookDelivery) {
+	attempt := models.WebhookAttempt{
+		DeliveryID: delivery.ID,
+	}
+
+	start := time.Now()
+	statusCode, err := d.send(delivery)
+	attempt.DurationMS = int(time.Since(start).Milliseconds())
+	attempt.StatusCode = statusCode
+	if err != nil {
+		attempt.Error = err.Error()
+		if len(attempt.Error) > 255 {
+			attempt.Error = attempt.Error[:255]
+		}
+	}
+
+	status := models.DeliverySucceeded
+	var next *time.Time
+	if err != nil {
+		status = models.DeliveryFailed
+		if delivery.Attempts+1 < MaxAttempts {
+			status = models.DeliveryPending
+			at := time.Now().Add(Backoff(delivery.Attempts + 1))
+			next = &at
+		}
+	}
+
+	err = d.DB.RecordWebhookAttempt(attempt, status, next)
+	if err != nil {
+		d.ErrorLog.Println(err)
+	}
+}
+
+// send posts a delivery to its webhook, signed with the webhook's secret, and returns the status the endpoint
+// answered with. Anything but a 2xx is an error
+func (d *Dispatcher) send(delivery *models.WebhookDelivery) (int, error) {
+	w, err := d.DB.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return 0, err
+	}
+
+	encrypter := encryption.Encryption{
+		Key: d.Key,
+	}
+	secret, err := encrypter.Decrypt(w.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Widgets-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+
+	signer := urlsigner.Signer{
+		Secret: []byte(secret),
+	}
+	err = signer.SignRequest(req, body)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 65536))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Backoff returns how long to wait before the attempt after attempt, give or take a tenth so endpoints that were
+// down together are not all tried again at the same moment
+func Backoff(attempt int) time.Duration {
+	wait := maxRetry
+	if attempt < 20 {
+		wait = firstRetry << (attempt - 1)
+		if wait > maxRetry {
+			wait = maxRetry
+		}
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(wait)/5)) - wait/10
+	return wait + jitter
+}
+
+// eventKey tells events apart, so that the same event heard about by more than one process is delivered once
+func eventKey(e events.Event) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s", e.Type, e.At.UnixMilli(), e.Data)))
+	return hex.EncodeToString(sum[:])
+}
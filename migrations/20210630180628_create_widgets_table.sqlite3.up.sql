@@ -0,0 +1,9 @@
+create table widgets (
+    id integer primary key autoincrement,
+    name varchar(255) not null default '',
+    description text not null default '',
+    inventory_level integer not null,
+    price integer not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
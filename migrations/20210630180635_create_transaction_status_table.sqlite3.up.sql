@@ -0,0 +1,12 @@
+create table transaction_statuses (
+    id integer primary key autoincrement,
+    name varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+insert into transaction_statuses (name) values ('Pending');
+insert into transaction_statuses (name) values ('Cleared');
+insert into transaction_statuses (name) values ('Declined');
+insert into transaction_statuses (name) values ('Refunded');
+insert into transaction_statuses (name) values ('Partially refunded');
@@ -0,0 +1,11 @@
+create table transactions (
+    id integer primary key autoincrement,
+    amount integer not null,
+    currency varchar(255) not null,
+    last_four varchar(255) not null,
+    bank_return_code varchar(255) not null,
+    transaction_status_id integer not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint transactions_transaction_statuses_id_fk foreign key (transaction_status_id) references transaction_statuses (id) on delete cascade on update cascade
+);
@@ -0,0 +1,11 @@
+-- the foreign key from orders.status_id was made with the orders table, as SQLite cannot add one later
+create table statuses (
+    id integer primary key autoincrement,
+    name varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+insert into statuses (name) values ('Cleared');
+insert into statuses (name) values ('Refunded');
+insert into statuses (name) values ('Cancelled');
@@ -0,0 +1,8 @@
+create table customers (
+    id integer primary key autoincrement,
+    first_name varchar(255) not null,
+    last_name varchar(255) not null,
+    email varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
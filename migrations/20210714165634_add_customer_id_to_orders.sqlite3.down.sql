@@ -0,0 +1,20 @@
+-- SQLite cannot drop a column that refers to another table, so the orders table is made again without it
+create table orders_without_customer (
+    id integer primary key autoincrement,
+    widget_id integer not null,
+    transaction_id integer not null,
+    status_id integer not null,
+    quantity integer not null,
+    amount integer not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint orders_widgets_id_fk foreign key (widget_id) references widgets (id) on delete cascade on update cascade,
+    constraint orders_transactions_id_fk foreign key (transaction_id) references transactions (id) on delete cascade on update cascade,
+    constraint orders_statuses_id_fk foreign key (status_id) references statuses (id) on delete cascade on update cascade
+);
+
+insert into orders_without_customer (id, widget_id, transaction_id, status_id, quantity, amount, created_at, updated_at)
+select id, widget_id, transaction_id, status_id, quantity, amount, created_at, updated_at from orders;
+
+drop table orders;
+alter table orders_without_customer rename to orders;
@@ -0,0 +1,2 @@
+-- SQLite cannot add a column that is not null without a default, so customer_id may be null here
+alter table orders add column customer_id integer references customers (id) on delete cascade on update cascade;
@@ -0,0 +1,9 @@
+create table tokens (
+    id serial primary key,
+    user_id integer not null,
+    name varchar(255) not null,
+    email varchar(255) not null,
+    token_hash bytea not null,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now()
+);
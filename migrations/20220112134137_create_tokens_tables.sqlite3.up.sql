@@ -0,0 +1,9 @@
+create table tokens (
+    id integer primary key autoincrement,
+    user_id integer not null,
+    name varchar(255) not null,
+    email varchar(255) not null,
+    token_hash blob not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
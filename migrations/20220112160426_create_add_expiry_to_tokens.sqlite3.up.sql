@@ -0,0 +1,2 @@
+-- SQLite cannot add a column that is not null without a default, so expiry may be null here
+alter table tokens add column expiry datetime;
@@ -0,0 +1,14 @@
+create table subscription_invoices (
+    id integer primary key autoincrement,
+    order_id integer not null,
+    transaction_id integer not null,
+    stripe_invoice_id varchar(255) not null,
+    period_start datetime not null,
+    period_end datetime not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint subscription_invoices_orders_id_fk foreign key (order_id) references orders (id) on delete cascade on update cascade,
+    constraint subscription_invoices_transactions_id_fk foreign key (transaction_id) references transactions (id) on delete cascade on update cascade
+);
+
+create unique index subscription_invoices_stripe_invoice_id_idx on subscription_invoices (stripe_invoice_id);
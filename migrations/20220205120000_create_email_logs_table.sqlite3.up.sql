@@ -0,0 +1,10 @@
+create table email_logs (
+    id integer primary key autoincrement,
+    recipient varchar(255) not null,
+    subject varchar(255) not null,
+    template varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+create index email_logs_recipient_idx on email_logs (recipient);
@@ -0,0 +1,31 @@
+alter table users add column two_factor_secret varchar(255) not null default '';
+alter table users add column two_factor_enabled boolean not null default false;
+
+create table recovery_codes (
+    id integer primary key autoincrement,
+    user_id integer not null,
+    code_hash blob not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint recovery_codes_users_id_fk foreign key (user_id) references users (id) on delete cascade on update cascade
+);
+
+create table trusted_devices (
+    id integer primary key autoincrement,
+    user_id integer not null,
+    token_hash blob not null,
+    expiry datetime not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint trusted_devices_users_id_fk foreign key (user_id) references users (id) on delete cascade on update cascade
+);
+
+create table settings (
+    id integer primary key autoincrement,
+    name varchar(255) not null,
+    value varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+create unique index settings_name_idx on settings (name);
@@ -0,0 +1,10 @@
+create table login_attempts (
+    id integer primary key autoincrement,
+    email varchar(255) not null,
+    ip_address varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+create index login_attempts_email_created_at_idx on login_attempts (email, created_at);
+create index login_attempts_ip_address_created_at_idx on login_attempts (ip_address, created_at);
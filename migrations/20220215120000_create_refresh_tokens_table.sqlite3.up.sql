@@ -0,0 +1,17 @@
+alter table tokens add column family varchar(255) not null default '';
+create index tokens_family_idx on tokens (family);
+
+create table refresh_tokens (
+    id integer primary key autoincrement,
+    user_id integer not null,
+    family varchar(255) not null,
+    token_hash blob not null,
+    expiry datetime not null,
+    used_at datetime,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint refresh_tokens_users_id_fk foreign key (user_id) references users (id) on delete cascade on update cascade
+);
+
+create unique index refresh_tokens_token_hash_idx on refresh_tokens (token_hash);
+create index refresh_tokens_family_idx on refresh_tokens (family);
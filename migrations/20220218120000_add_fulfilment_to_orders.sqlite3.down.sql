@@ -0,0 +1,4 @@
+-- fulfilment_status_id is not a foreign key in SQLite, so there is none to drop
+alter table orders drop column invoiced_at;
+alter table orders drop column fulfilment_status_id;
+drop table fulfilment_statuses;
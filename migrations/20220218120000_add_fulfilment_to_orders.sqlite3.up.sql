@@ -0,0 +1,16 @@
+create table fulfilment_statuses (
+    id integer primary key autoincrement,
+    name varchar(255) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+insert into fulfilment_statuses (name) values ('Pending');
+insert into fulfilment_statuses (name) values ('Processing');
+insert into fulfilment_statuses (name) values ('Shipped');
+insert into fulfilment_statuses (name) values ('Delivered');
+
+-- SQLite cannot add a column referencing another table with a default other than null, so fulfilment_status_id is not
+-- a foreign key here
+alter table orders add column fulfilment_status_id integer not null default 1;
+alter table orders add column invoiced_at datetime;
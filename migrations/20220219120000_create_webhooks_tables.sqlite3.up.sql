@@ -0,0 +1,37 @@
+create table webhooks (
+    id integer primary key autoincrement,
+    url varchar(2048) not null,
+    event_types varchar(255) not null,
+    secret varchar(255) not null,
+    active boolean not null default true,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+);
+
+create table webhook_deliveries (
+    id integer primary key autoincrement,
+    webhook_id integer not null,
+    event_key varchar(64) not null,
+    event_type varchar(255) not null,
+    payload text not null,
+    status varchar(255) not null default 'pending',
+    attempts integer not null default 0,
+    next_attempt_at datetime,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint webhook_deliveries_webhooks_id_fk foreign key (webhook_id) references webhooks (id) on delete cascade on update cascade
+);
+
+create unique index webhook_deliveries_webhook_id_event_key_idx on webhook_deliveries (webhook_id, event_key);
+create index webhook_deliveries_status_next_attempt_at_idx on webhook_deliveries (status, next_attempt_at);
+
+create table webhook_attempts (
+    id integer primary key autoincrement,
+    delivery_id integer not null,
+    status_code integer not null default 0,
+    error varchar(255) not null default '',
+    duration_ms integer not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    constraint webhook_attempts_webhook_deliveries_id_fk foreign key (delivery_id) references webhook_deliveries (id) on delete cascade on update cascade
+);
@@ -0,0 +1,11 @@
+// Package migrations holds the migrations of the shop's database, for cmd/migrate to apply. Each is written once in
+// fizz, or in SQL for each database, and may have a version written for one database next to it, named like
+// 20220116131525_create_sessions_tables.postgres.up.sql
+package migrations
+
+import "embed"
+
+// FS holds the migration files
+//
+//go:embed *.up.fizz *.down.fizz *.up.sql *.down.sql
+var FS embed.FS
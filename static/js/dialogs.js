@@ -0,0 +1,85 @@
+// dialogs shows the alerts and confirmations of the front end in bootstrap modals. It is served from the front end
+// itself, so the Content Security Policy does not have to trust a CDN with scripts
+const dialogs = (function () {
+    "use strict";
+
+    function button(text, className) {
+        let b = document.createElement("button");
+        b.type = "button";
+        b.className = "btn " + className;
+        b.textContent = text;
+        return b;
+    }
+
+    // show opens a modal with title and text, and the buttons, and resolves to the value of the button pressed, or
+    // to false when it is closed another way
+    function show(title, text, buttons) {
+        return new Promise(function (resolve) {
+            let modal = document.createElement("div");
+            modal.className = "modal fade";
+            modal.tabIndex = -1;
+
+            let dialog = document.createElement("div");
+            dialog.className = "modal-dialog modal-dialog-centered";
+            let content = document.createElement("div");
+            content.className = "modal-content";
+
+            if (title) {
+                let header = document.createElement("div");
+                header.className = "modal-header";
+                let h = document.createElement("h5");
+                h.className = "modal-title";
+                h.textContent = title;
+                header.appendChild(h);
+                content.appendChild(header);
+            }
+
+            let body = document.createElement("div");
+            body.className = "modal-body";
+            body.textContent = text;
+            content.appendChild(body);
+
+            let footer = document.createElement("div");
+            footer.className = "modal-footer";
+            content.appendChild(footer);
+
+            dialog.appendChild(content);
+            modal.appendChild(dialog);
+            document.body.appendChild(modal);
+
+            let m = new bootstrap.Modal(modal);
+            let result = false;
+            buttons.forEach(function (b) {
+                let el = button(b.text, b.className);
+                el.addEventListener("click", function () {
+                    result = b.value;
+                    m.hide();
+                });
+                footer.appendChild(el);
+            });
+
+            modal.addEventListener("hidden.bs.modal", function () {
+                m.dispose();
+                modal.remove();
+                resolve(result);
+            });
+            m.show();
+        });
+    }
+
+    return {
+        // alert shows message, and resolves once it is dismissed
+        alert: function (message) {
+            return show("", message, [{text: "OK", className: "btn-primary", value: true}]);
+        },
+
+        // confirm asks to go ahead with what title and text describe, on a button saying confirmText, and resolves
+        // to whether it was
+        confirm: function ({title, text, confirmText}) {
+            return show(title, text, [
+                {text: "Cancel", className: "btn-outline-secondary", value: false},
+                {text: confirmText, className: "btn-danger", value: true},
+            ]);
+        },
+    };
+})();